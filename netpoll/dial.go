@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	stdcontext "context"
+	"net"
+	"time"
+)
+
+// Dialer dials connections served by the workers of a Server, so that one
+// event loop drives both the inbound and the outbound connections.
+//
+// The Server does not need to serve a listener, the first dial starts its
+// workers. Closing the Server closes the dialed connections.
+type Dialer struct {
+	// Server serves the dialed connections.
+	Server *Server
+	// Handler responds to the dialed connections.
+	// Nil means the Handler of the Server.
+	Handler Handler
+	// Timeout is the maximum amount of time a dial waits for a connect to
+	// complete. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// Dial connects to the address on the named network, see DialContext.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(stdcontext.Background(), network, address)
+}
+
+// Dial connects to the address on the named network with the server and
+// handler, see Dialer.
+func Dial(server *Server, network, address string, handler Handler) (net.Conn, error) {
+	d := &Dialer{Server: server, Handler: handler}
+	return d.Dial(network, address)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package netpoll
+
+import (
+	stdcontext "context"
+	"net"
+)
+
+// DialContext connects to the address on the named network using the
+// provided context. The connection is upgraded by the Handler and served
+// by a goroutine.
+func (d *Dialer) DialContext(ctx stdcontext.Context, network, address string) (net.Conn, error) {
+	if d.Server == nil {
+		return nil, ErrServer
+	}
+	handler := d.Handler
+	if handler == nil {
+		handler = d.Server.Handler
+	}
+	if handler == nil {
+		return nil, ErrHandler
+	}
+	dialer := &net.Dialer{Timeout: d.Timeout}
+	c, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	upgraded, err := handler.Upgrade(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	go func() {
+		var err error
+		for err == nil {
+			err = handler.Serve(upgraded)
+		}
+		c.Close()
+	}()
+	return c, nil
+}
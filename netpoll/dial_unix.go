@@ -0,0 +1,191 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	stdcontext "context"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// DialContext connects to the address on the named network, "tcp", "tcp4",
+// "tcp6" or "unix", using the provided context.
+//
+// The socket connects without blocking, a worker of the Server reports when
+// the connect completes. The connection is then upgraded by the Handler on
+// the calling goroutine, and DialContext returns once the upgrade is done.
+// The worker serves it from then on like the accepted connections.
+func (d *Dialer) DialContext(ctx stdcontext.Context, network, address string) (net.Conn, error) {
+	s := d.Server
+	if s == nil {
+		return nil, ErrServer
+	}
+	handler := d.Handler
+	if handler == nil {
+		handler = s.Handler
+	}
+	if handler == nil {
+		return nil, ErrHandler
+	}
+	if d.Timeout > 0 {
+		var cancel stdcontext.CancelFunc
+		ctx, cancel = stdcontext.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	raddr, sa, err := resolveDialAddr(network, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: network, Addr: raddr, Err: err}
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	var domain = syscall.AF_INET
+	switch sa.(type) {
+	case *syscall.SockaddrInet6:
+		domain = syscall.AF_INET6
+	case *syscall.SockaddrUnix:
+		domain = syscall.AF_UNIX
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, opError(os.NewSyscallError("socket", err))
+	}
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, opError(os.NewSyscallError("setnonblock", err))
+	}
+	if domain != syscall.AF_UNIX {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1); err != nil {
+			syscall.Close(fd)
+			return nil, opError(os.NewSyscallError("setsockopt", err))
+		}
+	}
+	// The socket is registered once connecting, an unconnected socket is
+	// reported as hung up.
+	if err := syscall.Connect(fd, sa); err != nil && err != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, opError(os.NewSyscallError("connect", err))
+	}
+	c := &conn{fd: fd, raddr: raddr, uring: s.uring, handler: handler, dialing: 1, dialed: make(chan error, 1)}
+	s.lock.Lock()
+	w := s.nextGroup().assignWorker()
+	c.w = w
+	w.setup(c)
+	w.Increase(c)
+	s.lock.Unlock()
+	s.stats.dialed.Add(1)
+	c.lock.Lock()
+	c.w.poll.Write(fd)
+	c.lock.Unlock()
+	select {
+	case err = <-c.dialed:
+		if err != nil {
+			err = os.NewSyscallError("connect", err)
+		}
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&c.dialing, 1, 0) {
+			if err = ctx.Err(); err == stdcontext.DeadlineExceeded {
+				err = os.ErrDeadlineExceeded
+			}
+		} else if err = <-c.dialed; err != nil {
+			err = os.NewSyscallError("connect", err)
+		}
+	}
+	if err != nil {
+		w.Decrease(c)
+		c.Close()
+		return nil, opError(err)
+	}
+	if sa, err := syscall.Getsockname(fd); err == nil {
+		c.laddr = sockaddrToAddr(sa)
+	}
+	if err := w.upgrade(c); err != nil {
+		return nil, opError(err)
+	}
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return nil, opError(net.ErrClosed)
+	}
+	// The data received during the upgrade is served on the worker.
+	if w := c.worker(); w != nil {
+		w.post(Event{Fd: fd, Mode: READ})
+	}
+	return c, nil
+}
+
+// connected completes the connect of a dialed connection.
+func (c *conn) connected() {
+	if !atomic.CompareAndSwapInt32(&c.dialing, 1, 0) {
+		return
+	}
+	errno, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = syscall.Errno(errno)
+	}
+	c.dialed <- err
+}
+
+func resolveDialAddr(network, address string) (net.Addr, syscall.Sockaddr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		ip := addr.IP
+		if len(ip) == 0 {
+			// Like net.Dial, an empty host is the local system.
+			if network == "tcp6" {
+				ip = net.IPv6loopback
+			} else {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+		}
+		addr = &net.TCPAddr{IP: ip, Port: addr.Port, Zone: addr.Zone}
+		if ip4 := ip.To4(); ip4 != nil && network != "tcp6" {
+			sa := &syscall.SockaddrInet4{Port: addr.Port}
+			copy(sa.Addr[:], ip4)
+			return addr, sa, nil
+		}
+		sa := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sa.Addr[:], ip.To16())
+		if addr.Zone != "" {
+			if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return addr, sa, nil
+	case "unix":
+		addr, err := net.ResolveUnixAddr(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return addr, &syscall.SockaddrUnix{Name: addr.Name}, nil
+	}
+	return nil, nil, net.UnknownNetworkError(network)
+}
+
+func sockaddrToAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		var zone string
+		if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+			zone = ifi.Name
+		}
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port, Zone: zone}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Net: "unix", Name: sa.Name}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oxtoacart/bpool"
+)
+
+func TestDialer(t *testing.T) {
+	var echo = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	server := &Server{
+		Handler: echo,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(l); err == nil {
+			t.Error()
+		}
+	}()
+	responses := make(chan string, 16)
+	var handler = &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		conn := context.(net.Conn)
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		responses <- string(buf[:n])
+		return nil
+	})
+	// The client does not serve a listener.
+	client := &Server{Handler: handler}
+	dialer := &Dialer{Server: client, Timeout: time.Second}
+	msg := strings.Repeat("Hello World", 50)
+	var conns []net.Conn
+	for i := 0; i < 8; i++ {
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr().String() != "127.0.0.1:9999" || conn.LocalAddr() == nil {
+			t.Error(conn.RemoteAddr(), conn.LocalAddr())
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		var res string
+		for len(res) < len(msg) {
+			select {
+			case s := <-responses:
+				res += s
+			case <-time.After(time.Second * 5):
+				t.Fatal("no response")
+			}
+		}
+		if res != msg {
+			t.Error(res)
+		}
+	}
+	client.Close()
+	server.Close()
+	wg.Wait()
+}
+
+func TestDialerServe(t *testing.T) {
+	// The server greets the connections once they are upgraded.
+	var greeter = &ConnHandler{}
+	greeter.SetUpgrade(func(conn net.Conn) (Context, error) {
+		conn.Write([]byte("hello"))
+		return conn, nil
+	})
+	greeter.SetServe(func(context Context) error {
+		_, err := context.(net.Conn).Read(make([]byte, 64))
+		return err
+	})
+	server := &Server{Network: "tcp", Address: "127.0.0.1:9997", Handler: greeter}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	defer func() {
+		server.Close()
+		wg.Wait()
+	}()
+	time.Sleep(time.Millisecond * 20)
+	dialed := make(chan struct{})
+	responses := make(chan string, 16)
+	var handler = &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		// The greeting is received before the upgrade is done.
+		time.Sleep(time.Millisecond * 50)
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		conn := context.(net.Conn)
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-dialed:
+		case <-time.After(time.Second):
+			t.Error("served on the dialing goroutine")
+		}
+		responses <- string(buf[:n])
+		return nil
+	})
+	client := &Server{Handler: handler}
+	defer client.Close()
+	dialer := &Dialer{Server: client, Timeout: time.Second}
+	conn, err := dialer.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	close(dialed)
+	select {
+	case s := <-responses:
+		if s != "hello" {
+			t.Error(s)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("no greeting")
+	}
+	// A connection closed by the upgrade is not returned.
+	dialer.Handler = NewHandler(func(conn net.Conn) (Context, error) {
+		conn.Close()
+		return conn, nil
+	}, func(Context) error {
+		return nil
+	})
+	if conn, err := dialer.Dial("tcp", "127.0.0.1:9997"); err == nil || conn != nil {
+		t.Error(conn, err)
+	}
+}
+
+func TestDialerError(t *testing.T) {
+	var handler = &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		return EOF
+	})
+	if _, err := (&Dialer{}).Dial("tcp", ":9999"); err != ErrServer {
+		t.Error(err)
+	}
+	client := &Server{}
+	if _, err := Dial(client, "tcp", ":9999", nil); err != ErrHandler {
+		t.Error(err)
+	}
+	if _, err := Dial(client, "udp", ":9999", handler); err == nil {
+		t.Error()
+	}
+	// Nothing is listening.
+	if _, err := Dial(client, "tcp", ":9997", handler); err == nil {
+		t.Error()
+	}
+	// The connects to a listener with a full backlog do not complete.
+	fd, _ := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	defer syscall.Close(fd)
+	syscall.Bind(fd, &syscall.SockaddrInet4{Port: 9996, Addr: [4]byte{127, 0, 0, 1}})
+	syscall.Listen(fd, 0)
+	for i := 0; i < 4; i++ {
+		if conn, err := net.DialTimeout("tcp", "127.0.0.1:9996", time.Millisecond*100); err == nil {
+			defer conn.Close()
+		}
+	}
+	dialer := &Dialer{Server: client, Handler: handler, Timeout: time.Millisecond * 100}
+	if _, err := dialer.Dial("tcp", "127.0.0.1:9996"); !os.IsTimeout(err) {
+		t.Error(err)
+	}
+	client.Close()
+	if _, err := Dial(client, "tcp", ":9999", handler); err != ErrServerClosed {
+		t.Error(err)
+	}
+}
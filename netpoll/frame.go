@@ -0,0 +1,132 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// DefaultMaxFrameSize is the default max frame size of the DataHandler.
+const DefaultMaxFrameSize = 0x400000
+
+// ErrFrameTooLarge is the error when a frame exceeds the max frame size.
+var ErrFrameTooLarge = errors.New("frame exceeds MaxFrameSize")
+
+// ErrFrameLength is the error when the length prefix of a frame is invalid.
+var ErrFrameLength = errors.New("invalid frame length")
+
+// ErrFraming is the error when the Framing is unknown.
+var ErrFraming = errors.New("unknown Framing")
+
+// Framing is the way the DataHandler splits the data read from a connection
+// into requests and writes the responses.
+type Framing int
+
+const (
+	// RAW serves the data of each read as a request and writes the response as it is.
+	RAW Framing = iota
+	// VARINT prefixes each frame with its length as an unsigned varint.
+	VARINT
+	// UINT16 prefixes each frame with its length as a big endian uint16.
+	UINT16
+	// UINT32 prefixes each frame with its length as a big endian uint32.
+	UINT32
+	// DELIMITER terminates each frame with the delimiter, a newline by default.
+	DELIMITER
+)
+
+// Splitter splits the next frame from the data accumulated for a connection.
+//
+// It returns the number of bytes to advance the data and the frame. If the
+// data holds no complete frame yet, it returns 0 and a nil frame to read
+// more data. A non-nil error closes the connection.
+type Splitter func(data []byte) (advance int, frame []byte, err error)
+
+// newline is the default delimiter.
+var newline = []byte{'\n'}
+
+// split returns the Splitter of the framing.
+func (f Framing) split(delimiter []byte, max int) Splitter {
+	switch f {
+	case VARINT:
+		return func(data []byte) (int, []byte, error) {
+			length, n := binary.Uvarint(data)
+			if n < 0 {
+				return 0, nil, ErrFrameLength
+			} else if n == 0 {
+				return 0, nil, nil
+			}
+			return frame(data, n, length, max)
+		}
+	case UINT16:
+		return func(data []byte) (int, []byte, error) {
+			if len(data) < 2 {
+				return 0, nil, nil
+			}
+			return frame(data, 2, uint64(binary.BigEndian.Uint16(data)), max)
+		}
+	case UINT32:
+		return func(data []byte) (int, []byte, error) {
+			if len(data) < 4 {
+				return 0, nil, nil
+			}
+			return frame(data, 4, uint64(binary.BigEndian.Uint32(data)), max)
+		}
+	case DELIMITER:
+		if len(delimiter) == 0 {
+			delimiter = newline
+		}
+		return func(data []byte) (int, []byte, error) {
+			if i := bytes.Index(data, delimiter); i >= 0 {
+				return i + len(delimiter), data[:i], nil
+			}
+			return 0, nil, nil
+		}
+	}
+	return nil
+}
+
+// frame returns the frame of the length following the header of the size n.
+func frame(data []byte, n int, length uint64, max int) (int, []byte, error) {
+	if length > uint64(max) {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if end := n + int(length); len(data) >= end {
+		return end, data[n:end], nil
+	}
+	return 0, nil, nil
+}
+
+// encode appends the frame of the response to the buffer.
+func (f Framing) encode(buf, res []byte, delimiter []byte) ([]byte, error) {
+	switch f {
+	case VARINT:
+		buf = binary.AppendUvarint(buf, uint64(len(res)))
+	case UINT16:
+		if len(res) > math.MaxUint16 {
+			return buf, ErrFrameTooLarge
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(res)))
+	case UINT32:
+		if uint64(len(res)) > math.MaxUint32 {
+			return buf, ErrFrameTooLarge
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(res)))
+	case DELIMITER:
+		if len(delimiter) == 0 {
+			delimiter = newline
+		}
+		return append(append(buf, res...), delimiter...), nil
+	}
+	return append(buf, res...), nil
+}
+
+// sameArray reports whether the slices end in the same underlying array,
+// as the frames split from the data read do.
+func sameArray(a, b []byte) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
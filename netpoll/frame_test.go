@@ -0,0 +1,149 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/oxtoacart/bpool"
+)
+
+func testFraming(t *testing.T, handler *DataHandler, input, output []byte) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	ctx, err := handler.Upgrade(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for err == nil {
+			err = handler.Serve(ctx)
+		}
+		server.Close()
+		done <- err
+	}()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		// Splits and coalesces the frames.
+		for i := 0; i < len(input); i += 3 {
+			end := i + 3
+			if end > len(input) {
+				end = len(input)
+			}
+			if _, err := client.Write(input[i:end]); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, len(output))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(buf, output) {
+		t.Errorf("%q != %q", buf, output)
+	}
+	<-written
+	client.Close()
+	return <-done
+}
+
+func TestDataHandlerFraming(t *testing.T) {
+	var messages = []string{"hello", "", "netpoll", strings.Repeat("x", 300)}
+	var encode = map[Framing]func(msg string) []byte{
+		VARINT: func(msg string) []byte {
+			return append(appendUvarint(nil, uint64(len(msg))), msg...)
+		},
+		UINT16: func(msg string) []byte {
+			return append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+		},
+		UINT32: func(msg string) []byte {
+			return append([]byte{0, 0, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+		},
+		DELIMITER: func(msg string) []byte {
+			return append([]byte(msg), "\r\n"...)
+		},
+	}
+	for framing, enc := range encode {
+		var handler = &DataHandler{
+			Pool:    bpool.NewBytePool(1024, 64),
+			Framing: framing,
+			HandlerFunc: func(req []byte) (res []byte) {
+				return append([]byte{}, req...)
+			},
+		}
+		if framing == DELIMITER {
+			handler.Delimiter = []byte("\r\n")
+		}
+		var input, output []byte
+		for _, msg := range messages {
+			input = append(input, enc(msg)...)
+			output = append(output, enc(msg)...)
+		}
+		if err := testFraming(t, handler, input, output); err != io.EOF {
+			t.Error(framing, err)
+		}
+	}
+}
+
+func TestDataHandlerSplitter(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 64),
+		// Splits the words by spaces.
+		Splitter: func(data []byte) (int, []byte, error) {
+			if i := bytes.IndexByte(data, ' '); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			return 0, nil, nil
+		},
+		HandlerFunc: func(req []byte) (res []byte) {
+			if len(req) == 0 {
+				return nil
+			}
+			return bytes.ToUpper(req)
+		},
+	}
+	if err := testFraming(t, handler, []byte("hello  frames of netpoll "), []byte("HELLOFRAMESOFNETPOLL")); err != io.EOF {
+		t.Error(err)
+	}
+}
+
+func TestDataHandlerMaxFrameSize(t *testing.T) {
+	var handler = &DataHandler{
+		Pool:         bpool.NewBytePool(1024, 64),
+		Framing:      UINT16,
+		MaxFrameSize: 4,
+		HandlerFunc: func(req []byte) (res []byte) {
+			return req
+		},
+	}
+	if err := testFraming(t, handler, []byte{0, 4, 'a', 'b', 'c', 'd', 0, 5, 'a'}, []byte{0, 4, 'a', 'b', 'c', 'd'}); err != ErrFrameTooLarge {
+		t.Error(err)
+	}
+	handler.Framing = DELIMITER
+	if err := testFraming(t, handler, []byte("abc\nabcdefgh"), []byte("abc\n")); err != ErrFrameTooLarge {
+		t.Error(err)
+	}
+	handler.Framing = VARINT
+	if err := testFraming(t, handler, bytes.Repeat([]byte{0xff}, 11), nil); err != ErrFrameLength {
+		t.Error(err)
+	}
+	handler.Framing = Framing(-1)
+	if _, err := handler.Upgrade(&conn{}); err != ErrFraming {
+		t.Error(err)
+	}
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	for x >= 0x80 {
+		buf = append(buf, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(buf, byte(x))
+}
@@ -4,6 +4,7 @@
 package netpoll
 
 import (
+	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
@@ -18,6 +19,10 @@ var ErrUpgradeFunc = errors.New("Upgrade function must be not nil")
 // ErrServeFunc is the error when the Serve func is nil
 var ErrServeFunc = errors.New("Serve function must be not nil")
 
+// ErrUnflushed is the error when a connection is closed with data left to
+// write that the socket does not accept right away.
+var ErrUnflushed = errors.New("connection closed with data left to write")
+
 // Context is returned by Upgrade for serving.
 type Context interface{}
 
@@ -29,6 +34,26 @@ type Handler interface {
 	Serve(Context) error
 }
 
+// WatermarkHandler is implemented by a Handler that applies backpressure
+// when a peer reads slower than the handler writes.
+type WatermarkHandler interface {
+	// HighWatermark is called when the data buffered for writing to the
+	// connection grows above the Server HighWatermark.
+	HighWatermark(Context)
+	// LowWatermark is called when the buffered data drains to the Server
+	// LowWatermark after HighWatermark was called.
+	LowWatermark(Context)
+}
+
+// FlushHandler is implemented by a Handler that is notified when the data
+// left to write to a closed connection is dropped.
+type FlushHandler interface {
+	// FlushFailed is called with ErrUnflushed when the connection is closed
+	// without Server.Linger, with os.ErrDeadlineExceeded when the linger
+	// times out, or with the error the connection failed with.
+	FlushFailed(Context, error)
+}
+
 // NewHandler returns a new Handler.
 func NewHandler(upgrade func(net.Conn) (Context, error), serve func(Context) error) Handler {
 	return &ConnHandler{upgrade: upgrade, serve: serve}
@@ -36,8 +61,11 @@ func NewHandler(upgrade func(net.Conn) (Context, error), serve func(Context) err
 
 // ConnHandler implements the Handler interface.
 type ConnHandler struct {
-	upgrade func(net.Conn) (Context, error)
-	serve   func(Context) error
+	upgrade       func(net.Conn) (Context, error)
+	serve         func(Context) error
+	highWatermark func(Context)
+	lowWatermark  func(Context)
+	flushFailed   func(Context, error)
 }
 
 // SetUpgrade sets the Upgrade function for upgrading the net.Conn.
@@ -52,6 +80,27 @@ func (h *ConnHandler) SetServe(serve func(Context) error) *ConnHandler {
 	return h
 }
 
+// SetHighWatermark sets the function called when the data buffered for
+// writing to a connection grows above the high watermark.
+func (h *ConnHandler) SetHighWatermark(highWatermark func(Context)) *ConnHandler {
+	h.highWatermark = highWatermark
+	return h
+}
+
+// SetLowWatermark sets the function called when the data buffered for
+// writing to a connection drains to the low watermark.
+func (h *ConnHandler) SetLowWatermark(lowWatermark func(Context)) *ConnHandler {
+	h.lowWatermark = lowWatermark
+	return h
+}
+
+// SetFlushFailed sets the function called when the data left to write to a
+// closed connection is dropped.
+func (h *ConnHandler) SetFlushFailed(flushFailed func(Context, error)) *ConnHandler {
+	h.flushFailed = flushFailed
+	return h
+}
+
 // Upgrade implements the Handler Upgrade method.
 func (h *ConnHandler) Upgrade(conn net.Conn) (Context, error) {
 	if h.upgrade == nil {
@@ -81,6 +130,16 @@ type DataHandler struct {
 	upgrade func(net.Conn) (net.Conn, error)
 	// HandlerFunc is the data Serve function.
 	HandlerFunc func(req []byte) (res []byte)
+	// Framing is the way the requests and responses are framed, RAW by default.
+	// With a framing other than RAW, a nil response writes nothing.
+	Framing Framing
+	// Delimiter terminates the frames with DELIMITER, a newline by default.
+	Delimiter []byte
+	// Splitter splits the requests instead of the Framing when it is not nil.
+	// The responses are written as they are.
+	Splitter Splitter
+	// MaxFrameSize is the max size of a frame, DefaultMaxFrameSize by default.
+	MaxFrameSize int
 }
 
 type context struct {
@@ -89,7 +148,10 @@ type context struct {
 	upgrade bool
 	conn    net.Conn
 	pool    BytePool
-	buffer  []byte
+	// buffer holds the partial frame read from the conn.
+	buffer []byte
+	split  Splitter
+	limit  int
 }
 
 // SetUpgrade sets the Upgrade function for upgrading the net.Conn.
@@ -113,12 +175,49 @@ func (h *DataHandler) Upgrade(conn net.Conn) (Context, error) {
 		}
 	}
 	var ctx = &context{upgrade: upgrade, conn: conn, pool: h.Pool}
+	if h.Splitter != nil || h.Framing != RAW {
+		if err := h.frames(ctx); err != nil {
+			return nil, err
+		}
+	}
 	return ctx, nil
 }
 
+// frames sets the Splitter of the Context and the max size of the partial
+// frame including the header or the delimiter.
+func (h *DataHandler) frames(c *context) error {
+	max := h.MaxFrameSize
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	c.split, c.limit = h.Splitter, max
+	if c.split != nil {
+		return nil
+	}
+	if c.split = h.Framing.split(h.Delimiter, max); c.split == nil {
+		return ErrFraming
+	}
+	switch h.Framing {
+	case VARINT:
+		c.limit += binary.MaxVarintLen64
+	case UINT16:
+		c.limit += 2
+	case UINT32:
+		c.limit += 4
+	case DELIMITER:
+		if c.limit += len(h.Delimiter); len(h.Delimiter) == 0 {
+			c.limit += len(newline)
+		}
+	}
+	return nil
+}
+
 // Serve should serve a single request with the Context ctx.
 func (h *DataHandler) Serve(ctx Context) error {
 	c := ctx.(*context)
+	if c.split != nil {
+		return h.serveFrames(c)
+	}
 	var conn = c.conn
 	var n int
 	var err error
@@ -145,6 +244,98 @@ func (h *DataHandler) Serve(ctx Context) error {
 	if c.upgrade {
 		c.writing.Unlock()
 	}
-	c.pool.Put(res)
+	if !sameArray(res, buf) {
+		// The buffer is put back once.
+		c.pool.Put(res)
+	}
+	return err
+}
+
+// serveFrames appends the data read to the partial frame of the Context,
+// and serves each complete frame as a request.
+func (h *DataHandler) serveFrames(c *context) error {
+	buf := c.pool.Get()
+	defer c.pool.Put(buf)
+	if c.upgrade {
+		c.reading.Lock()
+	}
+	n, err := c.conn.Read(buf)
+	if c.upgrade {
+		c.reading.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	data := buf[:n]
+	if len(c.buffer) > 0 {
+		c.buffer = append(c.buffer, data...)
+		data = c.buffer
+	}
+	var out []byte
+	for len(data) > 0 {
+		advance, req, err := c.split(data)
+		if err != nil {
+			return err
+		} else if advance <= 0 {
+			break
+		} else if advance > len(data) {
+			return ErrFrameLength
+		}
+		data = data[advance:]
+		res := h.HandlerFunc(req)
+		if res == nil {
+			continue
+		}
+		if out == nil {
+			out = c.pool.Get()[:0]
+		}
+		if h.Splitter != nil {
+			out = append(out, res...)
+		} else if out, err = h.Framing.encode(out, res, h.Delimiter); err != nil {
+			return err
+		}
+		if !sameArray(res, buf) && !sameArray(res, c.buffer) {
+			c.pool.Put(res)
+		}
+	}
+	if len(data) > c.limit {
+		return ErrFrameTooLarge
+	} else if len(data) == 0 && cap(c.buffer) > bufferSize {
+		c.buffer = nil
+	} else {
+		c.buffer = append(c.buffer[:0], data...)
+	}
+	if out == nil {
+		return nil
+	}
+	if c.upgrade {
+		c.writing.Lock()
+	}
+	_, err = c.conn.Write(out)
+	if c.upgrade {
+		c.writing.Unlock()
+	}
+	c.pool.Put(out)
 	return err
 }
+
+// HighWatermark implements the WatermarkHandler HighWatermark method.
+func (h *ConnHandler) HighWatermark(ctx Context) {
+	if h.highWatermark != nil {
+		h.highWatermark(ctx)
+	}
+}
+
+// LowWatermark implements the WatermarkHandler LowWatermark method.
+func (h *ConnHandler) LowWatermark(ctx Context) {
+	if h.lowWatermark != nil {
+		h.lowWatermark(ctx)
+	}
+}
+
+// FlushFailed implements the FlushHandler FlushFailed method.
+func (h *ConnHandler) FlushFailed(ctx Context, err error) {
+	if h.flushFailed != nil {
+		h.flushFailed(ctx, err)
+	}
+}
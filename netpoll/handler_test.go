@@ -40,7 +40,7 @@ func TestNewHandler(t *testing.T) {
 	}, func(context Context) error {
 		return nil
 	})
-	ctx, err := handler.Upgrade(&conn{})
+	ctx, err := handler.Upgrade(&conn{fd: -1})
 	if err != nil {
 		t.Error(err)
 	}
@@ -95,3 +95,45 @@ func TestDataHandler(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+type countPool struct {
+	BytePool
+	puts int
+}
+
+func (p *countPool) Put(b []byte) {
+	p.puts++
+	p.BytePool.Put(b)
+}
+
+func TestDataHandlerEcho(t *testing.T) {
+	var pool = &countPool{BytePool: bpool.NewBytePool(1024, 64)}
+	var handler = &DataHandler{
+		Pool: pool,
+		HandlerFunc: func(req []byte) (res []byte) {
+			return req
+		},
+	}
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	ctx, err := handler.Upgrade(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		client.Write([]byte("Hello World"))
+		buf := make([]byte, 64)
+		n, _ := client.Read(buf)
+		if string(buf[:n]) != "Hello World" {
+			t.Error(string(buf[:n]))
+		}
+	}()
+	if err := handler.Serve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The response is the read buffer, it is put back once.
+	if pool.puts != 1 {
+		t.Error(pool.puts)
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hslam/buffer"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the default HTTPHandler MaxHeaderBytes.
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+	// DefaultMaxBodyBytes is the default HTTPHandler MaxBodyBytes.
+	DefaultMaxBodyBytes = 0x400000
+	// maxChunkLineBytes is the max size of a chunk size line.
+	maxChunkLineBytes = 4096
+)
+
+// ErrHeaderTooLarge is the error when the request line and headers are
+// larger than the MaxHeaderBytes.
+var ErrHeaderTooLarge = errors.New("HTTP request header too large")
+
+// ErrBodyTooLarge is the error when a request body is larger than the
+// MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("HTTP request body too large")
+
+// ErrChunkedEncoding is the error when a chunked request body is malformed.
+var ErrChunkedEncoding = errors.New("malformed HTTP chunked encoding")
+
+var (
+	crlf     = []byte("\r\n")
+	crlfcrlf = []byte("\r\n\r\n")
+)
+
+// HTTPHandler implements the Handler interface, serving HTTP/1.1 requests
+// with an http.Handler without a goroutine per connection.
+//
+// The requests are parsed as they arrive, pipelined requests are served in
+// order, and each response is buffered and written once the http.Handler
+// returns, unless it is flushed with chunked encoding. The connections are
+// kept alive unless a request or a response asks to close them. A response
+// closing the connection is sent in full when the socket does not accept it
+// right away only with Server.Linger.
+type HTTPHandler struct {
+	// Handler serves the requests, http.DefaultServeMux if nil.
+	Handler http.Handler
+	// MaxHeaderBytes is the max size of the request line and headers,
+	// DefaultMaxHeaderBytes by default.
+	MaxHeaderBytes int
+	// MaxBodyBytes is the max size of a request body, DefaultMaxBodyBytes
+	// by default.
+	MaxBodyBytes int
+}
+
+type httpContext struct {
+	lock sync.Mutex
+	conn net.Conn
+	tls  *tls.ConnectionState
+	// buffer holds the partial request read from the conn, whose header is
+	// parsed in pending when its body is not complete.
+	buffer  []byte
+	pending *http.Request
+	head    int
+	// continued reports whether 100 Continue was sent for the pending request.
+	continued bool
+	// out holds the responses not written yet.
+	out []byte
+	// closing is set once a response closes the connection, the data read
+	// then is discarded.
+	closing bool
+}
+
+// Upgrade implements the Handler Upgrade method.
+func (h *HTTPHandler) Upgrade(conn net.Conn) (Context, error) {
+	c := &httpContext{conn: conn}
+	if tc, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := tc.ConnectionState()
+		c.tls = &state
+	}
+	return c, nil
+}
+
+// Serve implements the Handler Serve method.
+func (h *HTTPHandler) Serve(ctx Context) error {
+	c := ctx.(*httpContext)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	buf := buffer.GetBuffer(bufferSize)
+	defer buffer.PutBuffer(buf)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return err
+	} else if c.closing {
+		return nil
+	}
+	data := buf[:n]
+	if len(c.buffer) > 0 {
+		c.buffer = append(c.buffer, data...)
+		data = c.buffer
+	}
+	for len(data) > 0 && !c.closing {
+		req, advance, err := h.readRequest(c, data)
+		if err != nil {
+			c.error(err)
+			break
+		} else if req == nil {
+			break
+		}
+		data = data[advance:]
+		h.serveHTTP(c, req)
+	}
+	if c.closing {
+		data = nil
+	}
+	if len(data) == 0 && cap(c.buffer) > bufferSize {
+		c.buffer = nil
+	} else {
+		c.buffer = append(c.buffer[:0], data...)
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if c.closing {
+		// The data left to write is flushed before the socket is closed
+		// with Server.Linger.
+		return EOF
+	}
+	return nil
+}
+
+// readRequest reads a request from the data, and returns the number of
+// bytes it takes. It returns a nil request when the data is not complete.
+func (h *HTTPHandler) readRequest(c *httpContext, data []byte) (req *http.Request, advance int, err error) {
+	maxHeader, maxBody := h.MaxHeaderBytes, h.MaxBodyBytes
+	if maxHeader <= 0 {
+		maxHeader = DefaultMaxHeaderBytes
+	}
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBodyBytes
+	}
+	if c.pending == nil {
+		// An empty line before a request line is ignored.
+		for bytes.HasPrefix(data[advance:], crlf) {
+			advance += len(crlf)
+		}
+		end := bytes.Index(data[advance:], crlfcrlf)
+		if end < 0 {
+			if len(data)-advance > maxHeader {
+				return nil, 0, ErrHeaderTooLarge
+			}
+			return nil, 0, nil
+		} else if end > maxHeader {
+			return nil, 0, ErrHeaderTooLarge
+		}
+		head := advance + end + len(crlfcrlf)
+		if c.pending, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(data[advance:head]))); err != nil {
+			return nil, 0, err
+		}
+		c.head, c.continued = head, false
+	}
+	req, advance = c.pending, c.head
+	var body []byte
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		var n int
+		if body, n, err = dechunk(data[advance:], maxBody); err != nil {
+			return nil, 0, err
+		} else if n == 0 {
+			c.expectContinue()
+			return nil, 0, nil
+		}
+		advance += n
+	} else if req.ContentLength > int64(maxBody) {
+		return nil, 0, ErrBodyTooLarge
+	} else if req.ContentLength > 0 {
+		if int64(len(data)-advance) < req.ContentLength {
+			c.expectContinue()
+			return nil, 0, nil
+		}
+		body = data[advance : advance+int(req.ContentLength)]
+		advance += int(req.ContentLength)
+	}
+	c.pending = nil
+	if len(body) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	} else {
+		req.Body = http.NoBody
+	}
+	req.RemoteAddr = c.conn.RemoteAddr().String()
+	req.TLS = c.tls
+	return req, advance, nil
+}
+
+// dechunk decodes the chunked body at the start of the data, and returns
+// the number of bytes it takes, zero when the data is not complete.
+func dechunk(data []byte, max int) (body []byte, n int, err error) {
+	pos := 0
+	for {
+		end := bytes.Index(data[pos:], crlf)
+		if end < 0 {
+			if len(data)-pos > maxChunkLineBytes {
+				return nil, 0, ErrChunkedEncoding
+			}
+			return nil, 0, nil
+		}
+		line := string(data[pos : pos+end])
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			// The chunk extensions are ignored.
+			line = line[:i]
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(line), 16, 63)
+		if err != nil {
+			return nil, 0, ErrChunkedEncoding
+		}
+		pos += end + len(crlf)
+		if size == 0 {
+			break
+		} else if size > uint64(max-len(body)) {
+			return nil, 0, ErrBodyTooLarge
+		}
+		if len(data)-pos < int(size)+len(crlf) {
+			return nil, 0, nil
+		} else if !bytes.Equal(data[pos+int(size):pos+int(size)+len(crlf)], crlf) {
+			return nil, 0, ErrChunkedEncoding
+		}
+		body = append(body, data[pos:pos+int(size)]...)
+		pos += int(size) + len(crlf)
+	}
+	// The trailers are skipped until the empty line.
+	for {
+		end := bytes.Index(data[pos:], crlf)
+		if end < 0 {
+			if len(data)-pos > maxChunkLineBytes {
+				return nil, 0, ErrChunkedEncoding
+			}
+			return nil, 0, nil
+		}
+		pos += end + len(crlf)
+		if end == 0 {
+			return body, pos, nil
+		}
+	}
+}
+
+// expectContinue writes 100 Continue once when the client of the pending
+// request waits for it to send the body.
+func (c *httpContext) expectContinue() {
+	req := c.pending
+	if c.continued || !req.ProtoAtLeast(1, 1) || !strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		return
+	}
+	c.continued = true
+	c.out = append(c.out, "HTTP/1.1 100 Continue\r\n\r\n"...)
+	c.flush()
+}
+
+// error writes the response to a request that cannot be read, and closes
+// the connection.
+func (c *httpContext) error(err error) {
+	code := http.StatusBadRequest
+	switch err {
+	case ErrHeaderTooLarge:
+		code = http.StatusRequestHeaderFieldsTooLarge
+	case ErrBodyTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	}
+	text := http.StatusText(code)
+	c.out = append(c.out, fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		code, text, len(text), text)...)
+	c.closing = true
+}
+
+// flush writes the responses.
+func (c *httpContext) flush() error {
+	if len(c.out) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.out)
+	if cap(c.out) > bufferSize {
+		c.out = nil
+	} else {
+		c.out = c.out[:0]
+	}
+	return err
+}
+
+// serveHTTP serves the request with the http.Handler, and appends the
+// response to the responses not written yet.
+func (h *HTTPHandler) serveHTTP(c *httpContext, req *http.Request) {
+	handler := h.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	w := &response{c: c, req: req, header: make(http.Header)}
+	defer func() {
+		if err := recover(); err != nil {
+			// Like net/http, the connection is closed without a response.
+			c.closing = true
+		}
+	}()
+	handler.ServeHTTP(w, req)
+	w.finish()
+}
+
+// response implements the http.ResponseWriter and the http.Flusher
+// interfaces, buffering the body.
+type response struct {
+	c           *httpContext
+	req         *http.Request
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        []byte
+	// chunked is set once the header is written by Flush.
+	chunked bool
+}
+
+// Header implements the http.ResponseWriter Header method.
+func (w *response) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements the http.ResponseWriter WriteHeader method.
+func (w *response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// The informational responses are written before the final one.
+		w.c.out = w.appendHeader(w.c.out, code, w.header)
+		return
+	}
+	w.status, w.wroteHeader = code, true
+}
+
+// Write implements the http.ResponseWriter Write method.
+func (w *response) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	w.body = append(w.body, b...)
+	return len(b), nil
+}
+
+// Flush implements the http.Flusher Flush method. The response is written
+// with chunked encoding from then on, the body of an HTTP/1.0 response is
+// buffered.
+func (w *response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.req.ProtoAtLeast(1, 1) || !bodyAllowed(w.status) || w.req.Method == http.MethodHead {
+		return
+	}
+	if !w.chunked {
+		if w.header.Get("Content-Length") != "" {
+			return
+		}
+		w.chunked = true
+		w.header.Set("Transfer-Encoding", "chunked")
+		w.c.out = w.appendHeader(w.c.out, w.status, w.finalHeader())
+	}
+	w.c.out = appendChunk(w.c.out, w.body)
+	w.body = w.body[:0]
+	w.c.flush()
+}
+
+// finish appends the response to the responses not written yet.
+func (w *response) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.chunked {
+		w.c.out = appendChunk(w.c.out, w.body)
+		w.c.out = append(w.c.out, "0\r\n\r\n"...)
+		return
+	}
+	if bodyAllowed(w.status) && w.header.Get("Content-Length") == "" && w.header.Get("Transfer-Encoding") == "" {
+		w.header.Set("Content-Length", strconv.Itoa(len(w.body)))
+	}
+	w.c.out = w.appendHeader(w.c.out, w.status, w.finalHeader())
+	if w.req.Method != http.MethodHead {
+		w.c.out = append(w.c.out, w.body...)
+	}
+}
+
+// finalHeader completes the header of the final response, and decides
+// whether the connection is closed after it.
+func (w *response) finalHeader() http.Header {
+	h := w.header
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if _, ok := h["Content-Type"]; !ok && len(w.body) > 0 && bodyAllowed(w.status) {
+		h.Set("Content-Type", http.DetectContentType(w.body))
+	}
+	if w.req.Close || hasToken(h.Get("Connection"), "close") {
+		w.c.closing = true
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+	return h
+}
+
+// appendHeader appends the status line and the header to the buffer.
+func (w *response) appendHeader(buf []byte, code int, header http.Header) []byte {
+	proto := "HTTP/1.0"
+	if w.req.ProtoAtLeast(1, 1) {
+		proto = "HTTP/1.1"
+	}
+	buf = append(buf, fmt.Sprintf("%s %03d %s\r\n", proto, code, http.StatusText(code))...)
+	b := bytes.NewBuffer(buf)
+	header.Write(b)
+	b.Write(crlf)
+	return b.Bytes()
+}
+
+func appendChunk(buf []byte, data []byte) []byte {
+	if len(data) == 0 {
+		return buf
+	}
+	buf = strconv.AppendUint(buf, uint64(len(data)), 16)
+	buf = append(buf, crlf...)
+	buf = append(buf, data...)
+	return append(buf, crlf...)
+}
+
+// bodyAllowed reports whether a response with the status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// hasToken reports whether the comma separated header value has the token.
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
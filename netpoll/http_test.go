@@ -0,0 +1,309 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bufio"
+	"bytes"
+	stdcontext "context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testHTTPServer(t *testing.T, handler *HTTPHandler) (server *Server, done func()) {
+	var accepted int32
+	server = &Server{
+		Network: "tcp",
+		Address: "127.0.0.1:9997",
+		Handler: handler,
+		ConnState: func(conn net.Conn, state ConnState) {
+			if state == StateNew {
+				atomic.AddInt32(&accepted, 1)
+			}
+		},
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	return server, func() {
+		server.Close()
+		wg.Wait()
+	}
+}
+
+func httpMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		fmt.Fprintf(w, "%s %d %v:", r.Method, r.ContentLength, r.TransferEncoding)
+		w.Write(body)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "part %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/close", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte("bye"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	return mux
+}
+
+func TestHTTPHandler(t *testing.T) {
+	_, done := testHTTPServer(t, &HTTPHandler{Handler: httpMux()})
+	defer done()
+	var dials int32
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx stdcontext.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	defer client.CloseIdleConnections()
+	get := func(path string) (int, string) {
+		resp, err := client.Get("http://127.0.0.1:9997" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	for i := 0; i < 3; i++ {
+		if code, body := get("/hello"); code != http.StatusOK || body != "Hello World" {
+			t.Error(code, body)
+		}
+	}
+	if code, _ := get("/missing"); code != http.StatusNotFound {
+		t.Error(code)
+	}
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Error("not kept alive", dials)
+	}
+	large := strings.Repeat("netpoll", 0x4000)
+	resp, err := client.Post("http://127.0.0.1:9997/echo", "text/plain", strings.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := fmt.Sprintf("POST %d []:%s", len(large), large); string(body) != want {
+		t.Error(len(body))
+	}
+	// A reader of unknown length is sent chunked.
+	resp, err = client.Post("http://127.0.0.1:9997/echo", "text/plain", io.MultiReader(strings.NewReader("chunked "), strings.NewReader("body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "POST -1 [chunked]:chunked body" {
+		t.Error(string(body))
+	}
+	if code, body := get("/stream"); code != http.StatusOK || body != "part 0\npart 1\npart 2\n" {
+		t.Error(code, body)
+	}
+	if code, body := get("/close"); code != http.StatusOK || body != "bye" {
+		t.Error(code, body)
+	}
+	if _, err := client.Get("http://127.0.0.1:9997/panic"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestHTTPHandlerPipelining(t *testing.T) {
+	_, done := testHTTPServer(t, &HTTPHandler{Handler: httpMux(), MaxHeaderBytes: 256, MaxBodyBytes: 64})
+	defer done()
+	conn, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	requests := "GET /hello HTTP/1.1\r\nHost: netpoll\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nHost: netpoll\r\nContent-Length: 5\r\n\r\nhello" +
+		"\r\nPOST /echo HTTP/1.1\r\nHost: netpoll\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n2\r\nde\r\n0\r\nTrailer: x\r\n\r\n" +
+		"HEAD /hello HTTP/1.1\r\nHost: netpoll\r\n\r\n"
+	// The requests are split across reads.
+	for i := 0; i < len(requests); i += 7 {
+		end := i + 7
+		if end > len(requests) {
+			end = len(requests)
+		}
+		conn.Write([]byte(requests[i:end]))
+		time.Sleep(time.Millisecond)
+	}
+	r := bufio.NewReader(conn)
+	read := func(method string) (*http.Response, string) {
+		resp, err := http.ReadResponse(r, &http.Request{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+	if _, body := read("GET"); body != "Hello World" {
+		t.Error(body)
+	}
+	if _, body := read("POST"); body != "POST 5 []:hello" {
+		t.Error(body)
+	}
+	if _, body := read("POST"); body != "POST -1 [chunked]:abcde" {
+		t.Error(body)
+	}
+	if resp, body := read("HEAD"); body != "" || resp.ContentLength != int64(len("Hello World")) {
+		t.Error(resp.ContentLength, body)
+	}
+	// 100 Continue is sent to a client waiting to send the body.
+	conn.Write([]byte("POST /echo HTTP/1.1\r\nHost: netpoll\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n"))
+	if resp, _ := read("POST"); resp.StatusCode != http.StatusContinue {
+		t.Error(resp.StatusCode)
+	}
+	conn.Write([]byte("ok"))
+	if _, body := read("POST"); body != "POST 2 []:ok" {
+		t.Error(body)
+	}
+	// An HTTP/1.0 request closes the connection unless it is kept alive.
+	conn.Write([]byte("GET /hello HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	if resp, body := read("GET"); body != "Hello World" || resp.Header.Get("Connection") != "keep-alive" {
+		t.Error(resp.Header, body)
+	}
+	conn.Write([]byte("POST /echo HTTP/1.1\r\nHost: netpoll\r\nContent-Length: 65\r\n\r\n"))
+	if resp, _ := read("POST"); resp.StatusCode != http.StatusRequestEntityTooLarge || !resp.Close {
+		t.Error(resp.StatusCode, resp.Close)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Error(err)
+	}
+	for _, c := range []struct {
+		req  string
+		code int
+	}{
+		{"GET /hello HTTP/1.1\r\nHost: netpoll\r\nX: " + strings.Repeat("x", 256) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"GET /hello HTTP/1.1\r\nHost: netpoll\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n", http.StatusBadRequest},
+		{"GARBAGE\r\n\r\n", http.StatusBadRequest},
+	} {
+		conn, err := net.Dial("tcp", "127.0.0.1:9997")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(c.req))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != c.code {
+			t.Error(resp.StatusCode, c.code)
+		}
+		conn.Close()
+	}
+}
+
+func TestHTTPHandlerClose(t *testing.T) {
+	large := strings.Repeat("netpoll", 0x120000)
+	mux := httpMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		io.WriteString(w, large)
+	})
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := writeCert(t, certFile, keyFile, 1)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	for _, secure := range []bool{false, true} {
+		server := &Server{Network: "tcp", Address: "127.0.0.1:9997", Handler: &HTTPHandler{Handler: mux}, Linger: time.Second * 10}
+		if secure {
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+		}
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.ListenAndServe(); err != ErrServerClosed {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(time.Millisecond * 20)
+		// The connection is closed once the response is sent, however large.
+		for path, want := range map[string]string{"/close": "bye", "/large": large} {
+			conn, err := net.Dial("tcp", "127.0.0.1:9997")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secure {
+				conn = tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+			}
+			conn.SetDeadline(time.Now().Add(time.Second * 10))
+			fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: netpoll\r\n\r\n", path)
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatal(secure, path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !resp.Close || string(body) != want {
+				t.Error(secure, path, resp.Close, len(body))
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Error(secure, path, err)
+			}
+			conn.Close()
+		}
+		server.Close()
+		wg.Wait()
+	}
+}
+
+func TestDechunk(t *testing.T) {
+	body, n, err := dechunk([]byte("4\r\nwiki\r\n5\r\npedia\r\n0\r\n\r\nnext"), 64)
+	if err != nil || n != 24 || !bytes.Equal(body, []byte("wikipedia")) {
+		t.Error(string(body), n, err)
+	}
+	for _, data := range []string{"4\r\nwi", "4\r\nwiki\r\n0\r\n", "4"} {
+		if _, n, err := dechunk([]byte(data), 64); n != 0 || err != nil {
+			t.Error(data, n, err)
+		}
+	}
+	if _, _, err := dechunk([]byte("41\r\n"), 64); err != ErrBodyTooLarge {
+		t.Error(err)
+	}
+	if _, _, err := dechunk([]byte("2\r\nabc\r\n"), 64); err != ErrChunkedEncoding {
+		t.Error(err)
+	}
+}
@@ -30,6 +30,44 @@ var ErrHandler = errors.New("Handler must be not nil")
 // ErrListener is the error when the Listener is nil
 var ErrListener = errors.New("Listener must be not nil")
 
+// ErrPacketHandler is the error when the PacketHandler is nil
+var ErrPacketHandler = errors.New("PacketHandler must be not nil")
+
+// ErrServer is the error when the Dialer Server is nil
+var ErrServer = errors.New("Server must be not nil")
+
+// ConnState represents the state of a connection, reported to the Server
+// ConnState hook.
+type ConnState int
+
+const (
+	// StateNew represents a new connection that is being upgraded.
+	StateNew ConnState = iota
+	// StateActive represents a connection that is serving requests.
+	StateActive
+	// StateIdle represents a connection that is waiting for requests.
+	StateIdle
+	// StateClosed represents a closed connection.
+	StateClosed
+)
+
+var stateName = map[ConnState]string{
+	StateNew:    "new",
+	StateActive: "active",
+	StateIdle:   "idle",
+	StateClosed: "closed",
+}
+
+func (c ConnState) String() string {
+	return stateName[c]
+}
+
+// Address is a network address a Server listens on.
+type Address struct {
+	Network string
+	Address string
+}
+
 // ListenAndServe listens on the network address and then calls
 // Serve with handler to handle requests on incoming connections.
 //
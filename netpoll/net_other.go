@@ -7,9 +7,11 @@
 package netpoll
 
 import (
-	"errors"
+	stdcontext "context"
+	"crypto/tls"
 	"net"
 	"sync/atomic"
+	"time"
 )
 
 // Server defines parameters for running a server.
@@ -18,6 +20,13 @@ type Server struct {
 	Address string
 	// Handler responds to a single request.
 	Handler Handler
+	// PacketHandler responds to the datagrams when the Network is a
+	// datagram network.
+	PacketHandler PacketHandler
+	// Addresses do not work for consisted with other system.
+	Addresses []Address
+	// ReusePort do not work for consisted with other system.
+	ReusePort int
 	// NoAsync do not work for consisted with other system.
 	NoAsync bool
 	// UnsharedWorkers do not work for consisted with other system.
@@ -26,8 +35,27 @@ type Server struct {
 	SharedWorkers int
 	// TasksPerWorker do not work for consisted with other system.
 	TasksPerWorker int
-	netServer      *netServer
-	closed         int32
+	// IdleTimeout do not work for consisted with other system.
+	IdleTimeout time.Duration
+	// HighWatermark do not work for consisted with other system.
+	HighWatermark int
+	// LowWatermark do not work for consisted with other system.
+	LowWatermark int
+	// Linger do not work for consisted with other system.
+	Linger time.Duration
+	// Trigger do not work for consisted with other system.
+	Trigger Trigger
+	// IOUring do not work for consisted with other system.
+	IOUring bool
+	// TLSConfig makes the server terminate TLS on the connections.
+	TLSConfig *tls.Config
+	// TLSHandshakeTimeout do not work for consisted with other system.
+	TLSHandshakeTimeout time.Duration
+	// ConnState do not work for consisted with other system.
+	ConnState  func(net.Conn, ConnState)
+	netServer  *netServer
+	packetConn net.PacketConn
+	closed     int32
 }
 
 // ListenAndServe listens on the network address and then calls
@@ -39,6 +67,13 @@ func (s *Server) ListenAndServe() error {
 	if atomic.LoadInt32(&s.closed) != 0 {
 		return ErrServerClosed
 	}
+	if isPacketNetwork(s.Network) {
+		conn, err := net.ListenPacket(s.Network, s.Address)
+		if err != nil {
+			return err
+		}
+		return s.ServePacket(conn)
+	}
 	ln, err := net.Listen(s.Network, s.Address)
 	if err != nil {
 		return err
@@ -63,13 +98,54 @@ func (s *Server) Serve(l net.Listener) (err error) {
 		return ErrServerClosed
 	}
 	s.netServer = &netServer{Handler: s.Handler}
+	if s.TLSConfig != nil {
+		return s.netServer.Serve(tls.NewListener(l, s.TLSConfig))
+	}
 	return s.netServer.Serve(l)
 }
 
+// ServePacket serves the datagrams of the net.PacketConn conn with the
+// PacketHandler.
+//
+// ServePacket always returns a non-nil error.
+// After Close the returned error is ErrServerClosed.
+func (s *Server) ServePacket(conn net.PacketConn) error {
+	if conn == nil {
+		return ErrListener
+	}
+	if s.PacketHandler == nil {
+		conn.Close()
+		return ErrPacketHandler
+	}
+	if atomic.LoadInt32(&s.closed) != 0 {
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.packetConn = conn
+	err := servePacketConn(conn, s.PacketHandler)
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrServerClosed
+	}
+	return err
+}
+
 // Close closes the server.
 func (s *Server) Close() error {
 	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
 		return nil
 	}
+	if s.packetConn != nil {
+		return s.packetConn.Close()
+	}
 	return s.netServer.Close()
 }
+
+// Shutdown closes the server. The connections are not drained on this system.
+func (s *Server) Shutdown(ctx stdcontext.Context) error {
+	return s.Close()
+}
+
+// Stats returns an empty snapshot, the statistics are not tracked on this system.
+func (s *Server) Stats() Stats {
+	return Stats{}
+}
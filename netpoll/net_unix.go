@@ -7,7 +7,8 @@
 package netpoll
 
 import (
-	"errors"
+	stdcontext "context"
+	"crypto/tls"
 	"github.com/hslam/buffer"
 	"github.com/hslam/scheduler"
 	"github.com/hslam/sendfile"
@@ -24,6 +25,22 @@ import (
 
 const (
 	idleTime = time.Second
+	// shutdownPollInterval is how often Shutdown checks for idle connections.
+	shutdownPollInterval = 10 * time.Millisecond
+	// newConnGrace is how long Shutdown waits for a new connection to be
+	// upgraded before it shuts the socket down, like net/http.
+	newConnGrace = 5 * time.Second
+	// defaultHighWatermark is the default Server.HighWatermark.
+	defaultHighWatermark = 0x10000
+	// acceptBatch is the number of accepts a listener keeps queued with
+	// io_uring.
+	acceptBatch = 16
+	// recvSize is the size of the buffer a connection receives its data in
+	// with io_uring.
+	recvSize = 0x4000
+	// closeWaitTimeout is how long a lingering connection waits for the peer
+	// to close once its data is flushed, before the socket is closed.
+	closeWaitTimeout = 500 * time.Millisecond
 )
 
 var (
@@ -36,17 +53,67 @@ type Server struct {
 	Address string
 	// Handler responds to a single request.
 	Handler Handler
+	// PacketHandler responds to the datagrams when the Network is a
+	// datagram network, udp, udp4, udp6 or unixgram. A udp network is
+	// served with a socket bound with SO_REUSEPORT for each of the
+	// SharedWorkers, the number of CPUs by default.
+	PacketHandler PacketHandler
+	// Addresses are the addresses ListenAndServe listens on besides the
+	// Network and Address, to serve several tcp and unix addresses.
+	Addresses []Address
+	// ReusePort is the number of listeners ListenAndServe binds with
+	// SO_REUSEPORT on each tcp address, so that the kernel spreads the
+	// connections across their accept loops. Each listener is attached to
+	// a group of workers, the UnsharedWorkers and SharedWorkers being split
+	// across ReusePort groups. Zero means one listener and one group.
+	ReusePort int
 	// NoAsync disables async.
 	NoAsync         bool
 	UnsharedWorkers int
 	SharedWorkers   int
-	addr            net.Addr
+	// IdleTimeout is the maximum amount of time a connection may stay
+	// without sending any data before it is closed. Zero means no timeout.
+	IdleTimeout time.Duration
+	// HighWatermark is the number of bytes buffered for writing to a
+	// connection above which a WatermarkHandler is notified.
+	// Zero means 64 KiB.
+	HighWatermark int
+	// LowWatermark is the number of buffered bytes at which a
+	// WatermarkHandler is notified again once the buffer drains.
+	// Zero means half the HighWatermark.
+	LowWatermark int
+	// Linger is how long a connection closed with data left to write keeps
+	// being flushed by its worker, or until the write deadline if it comes
+	// first. Zero means the data the socket does not accept right away is
+	// dropped on Close. A FlushHandler is notified when data is dropped.
+	Linger time.Duration
+	// Trigger selects how the workers poll the connections. With EDGE or
+	// ONESHOT the Handler must keep reading until Read returns EAGAIN,
+	// otherwise the connection is rearmed to be served again.
+	Trigger Trigger
+	// IOUring makes the server poll with io_uring on Linux, accepting,
+	// reading and writing the connections with accept, recv and send
+	// requests submitted in batches. It falls back to epoll when the kernel
+	// does not support io_uring.
+	IOUring bool
+	// TLSConfig makes the server terminate TLS on the accepted connections.
+	// The handshake completes before the Handler Upgrade on the upgrade
+	// goroutine, woken by the events of the worker without blocking on the
+	// socket or the worker, then the records are read and written per event
+	// on the workers. The Handler is upgraded with a *tls.Conn wrapper.
+	TLSConfig *tls.Config
+	// TLSHandshakeTimeout is the maximum amount of time the TLS handshake
+	// may take. Zero means 10 seconds.
+	TLSHandshakeTimeout time.Duration
+	// ConnState specifies an optional callback function that is called
+	// when a connection changes state.
+	ConnState       func(net.Conn, ConnState)
+	uring           bool
 	netServer       *netServer
-	file            *os.File
-	fd              int
-	poll            *Poll
+	listeners       []*listener
+	groups          []*group
 	workers         []*worker
-	heap            []*worker
+	next            uint32
 	rescheduled     bool
 	lock            sync.Mutex
 	wake            bool
@@ -57,11 +124,23 @@ type Server struct {
 	sharedWorkers   uint
 	wg              sync.WaitGroup
 	closed          int32
+	stopped         int32
 	done            chan struct{}
+	stats           stats
+	packets         []*packetConn
+	packetConn      net.PacketConn
 }
 
+// forceIOUring makes every Server poll with io_uring, the tests run with and
+// without it.
+var forceIOUring bool
+
 // ListenAndServe listens on the network address and then calls
 // Serve with handler to handle requests on incoming connections.
+// A datagram network is served with the PacketHandler.
+//
+// ListenAndServe also listens on the Addresses, and binds ReusePort
+// listeners on each tcp address.
 //
 // ListenAndServe always returns a non-nil error.
 // After Close the returned error is ErrServerClosed.
@@ -69,11 +148,61 @@ func (s *Server) ListenAndServe() error {
 	if atomic.LoadInt32(&s.closed) != 0 {
 		return ErrServerClosed
 	}
-	ln, err := net.Listen(s.Network, s.Address)
-	if err != nil {
-		return err
+	if isPacketNetwork(s.Network) {
+		return s.listenAndServePacket()
+	} else if s.Handler == nil {
+		return ErrHandler
+	}
+	addresses := append([]Address{{Network: s.Network, Address: s.Address}}, s.Addresses...)
+	var ls []net.Listener
+	for _, address := range addresses {
+		lns, err := listen(address.Network, address.Address, s.ReusePort)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return err
+		}
+		ls = append(ls, lns...)
+	}
+	return s.serve(ls)
+}
+
+// listen listens on the network address with n listeners bound with
+// SO_REUSEPORT, or with one listener when n < 2 or the network is unix.
+func listen(network, address string, n int) ([]net.Listener, error) {
+	if n < 2 || network == "unix" || network == "unixpacket" {
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
 	}
-	return s.Serve(ln)
+	lc := net.ListenConfig{Control: reusePort}
+	ls := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(stdcontext.Background(), network, address)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		// The other listeners bind the port of the first one.
+		address = l.Addr().String()
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// reusePort sets SO_REUSEPORT on the socket before it is bound.
+func reusePort(network, address string, c syscall.RawConn) (err error) {
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return
 }
 
 // Serve accepts incoming connections on the listener l,
@@ -85,95 +214,257 @@ func (s *Server) ListenAndServe() error {
 // Serve always returns a non-nil error.
 // After Close the returned error is ErrServerClosed.
 func (s *Server) Serve(l net.Listener) (err error) {
+	return s.serve([]net.Listener{l})
+}
+
+// serve accepts the connections of each listener on its own loop, and
+// assigns them to the workers of the group of the listener.
+func (s *Server) serve(ls []net.Listener) (err error) {
 	if atomic.LoadInt32(&s.closed) != 0 {
 		return ErrServerClosed
 	}
-	if s.UnsharedWorkers == 0 {
-		s.unsharedWorkers = 16
-	} else if s.UnsharedWorkers > 0 {
-		s.unsharedWorkers = uint(s.UnsharedWorkers)
-	}
-	if s.SharedWorkers == 0 {
-		s.sharedWorkers = uint(numCPU)
-	} else if s.SharedWorkers > 0 {
-		s.sharedWorkers = uint(s.SharedWorkers)
-	} else {
+	if s.SharedWorkers < 0 {
 		panic("SharedWorkers < 0")
 	}
-	if l == nil {
-		return ErrListener
-	} else if s.Handler == nil {
+	for _, l := range ls {
+		if l == nil {
+			return ErrListener
+		}
+	}
+	if s.Handler == nil {
 		return ErrHandler
 	}
-	switch netListener := l.(type) {
-	case *net.TCPListener:
-		if s.file, err = netListener.File(); err != nil {
-			l.Close()
-			return err
+	if len(ls) == 1 {
+		switch ls[0].(type) {
+		case *net.TCPListener, *net.UnixListener:
+		default:
+			s.netServer = &netServer{Handler: s.Handler}
+			if s.TLSConfig != nil {
+				return s.netServer.Serve(tls.NewListener(ls[0], s.TLSConfig))
+			}
+			return s.netServer.Serve(ls[0])
 		}
-	case *net.UnixListener:
-		if s.file, err = netListener.File(); err != nil {
-			l.Close()
+	}
+	listeners := make([]*listener, 0, len(ls))
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.close()
+		}
+	}
+	for i, l := range ls {
+		ln, err := newListener(l)
+		if err != nil {
+			closeListeners()
+			for _, l := range ls[i+1:] {
+				l.Close()
+			}
 			return err
 		}
-	default:
-		s.netServer = &netServer{Handler: s.Handler}
-		return s.netServer.Serve(l)
+		listeners = append(listeners, ln)
 	}
-	s.fd = int(s.file.Fd())
-	s.addr = l.Addr()
-	l.Close()
-	if err := syscall.SetNonblock(s.fd, true); err != nil {
+	if err = s.start(); err != nil {
+		closeListeners()
 		return err
 	}
-	if s.poll, err = Create(); err != nil {
-		return err
+	for _, l := range listeners {
+		if l.poll, err = s.create(); err != nil {
+			closeListeners()
+			return err
+		}
+		l.uring = s.uring
 	}
-	s.poll.Register(s.fd)
-	if !s.NoAsync && s.unsharedWorkers > 0 {
-		s.rescheduled = true
+	s.lock.Lock()
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		s.lock.Unlock()
+		closeListeners()
+		return ErrServerClosed
+	}
+	for _, l := range listeners {
+		l.group = s.groups[len(s.listeners)%len(s.groups)]
+		s.listeners = append(s.listeners, l)
+	}
+	s.lock.Unlock()
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners))
+	for i, l := range listeners {
+		wg.Add(1)
+		go func(i int, l *listener) {
+			defer wg.Done()
+			errs[i] = s.acceptLoop(l)
+		}(i, l)
+	}
+	wg.Wait()
+	s.wg.Wait()
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		return ErrServerClosed
 	}
-	for i := 0; i < int(s.unsharedWorkers+s.sharedWorkers); i++ {
-		p, err := Create()
+	for _, err := range errs {
 		if err != nil {
 			return err
 		}
-		var async bool
-		if i >= int(s.unsharedWorkers) && !s.NoAsync {
-			async = true
+	}
+	return ErrServerClosed
+}
+
+// acceptLoop accepts the connections of the listener l until it fails.
+func (s *Server) acceptLoop(l *listener) (err error) {
+	l.poll.Register(l.fd)
+	if s.uring {
+		return s.acceptRing(l)
+	}
+	var n int
+	var events = make([]Event, 1)
+	for err == nil {
+		if n, err = l.poll.Wait(events); n > 0 {
+			if events[0].Fd == l.fd {
+				err = s.accept(l)
+			}
+			s.wakeReschedule()
 		}
-		w := &worker{
-			index:  i,
-			server: s,
-			conns:  make(map[int]*conn),
-			poll:   p,
-			events: make([]Event, 0x400),
-			async:  async,
-			done:   make(chan struct{}, 1),
+		runtime.Gosched()
+	}
+	return err
+}
+
+// start creates the workers, once. Serve and Dialer start the server.
+func (s *Server) start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrServerClosed
+	} else if s.workers != nil {
+		return nil
+	}
+	if s.UnsharedWorkers == 0 {
+		s.unsharedWorkers = 16
+	} else if s.UnsharedWorkers > 0 {
+		s.unsharedWorkers = uint(s.UnsharedWorkers)
+	}
+	if s.SharedWorkers == 0 {
+		s.sharedWorkers = uint(numCPU)
+	} else if s.SharedWorkers > 0 {
+		s.sharedWorkers = uint(s.SharedWorkers)
+	} else {
+		panic("SharedWorkers < 0")
+	}
+	if !s.NoAsync && s.unsharedWorkers > 0 {
+		s.rescheduled = true
+	}
+	n := s.ReusePort
+	if n < 1 {
+		n = 1
+	}
+	if s.sharedWorkers < uint(n) {
+		// Each group has a shared worker at least.
+		s.sharedWorkers = uint(n)
+	}
+	var workers []*worker
+	var groups []*group
+	for i := 0; i < n; i++ {
+		g := &group{
+			index:    i,
+			unshared: split(s.unsharedWorkers, n, i),
 		}
-		s.workers = append(s.workers, w)
-		if i >= int(s.unsharedWorkers) {
-			s.heap = append(s.heap, w)
+		shared := split(s.sharedWorkers, n, i)
+		for j := 0; j < int(g.unshared+shared); j++ {
+			p, err := s.create()
+			if err != nil {
+				return err
+			}
+			if err := p.SetTrigger(s.Trigger); err != nil {
+				p.Close()
+				return err
+			}
+			w := &worker{
+				index:  len(workers),
+				group:  i,
+				shared: j >= int(g.unshared),
+				server: s,
+				conns:  make(map[int]*conn),
+				poll:   p,
+				events: make([]Event, 0x400),
+				done:   make(chan struct{}, 1),
+				timers: newTimerWheel(timerTick, timerSlots),
+			}
+			w.async = w.shared && !s.NoAsync
+			if err := w.openWakeup(); err != nil {
+				p.Close()
+				return err
+			}
+			workers = append(workers, w)
+			g.workers = append(g.workers, w)
 		}
+		g.heap = append([]*worker{}, g.workers[g.unshared:]...)
+		groups = append(groups, g)
 	}
+	s.workers, s.groups = workers, groups
 	s.done = make(chan struct{}, 1)
-	var n int
-	var events = make([]Event, 1)
+	return nil
+}
+
+// split returns the share of the group i of the n workers split across
+// groups.
+func split(n uint, groups, i int) uint {
+	share := n / uint(groups)
+	if uint(i) < n%uint(groups) {
+		share++
+	}
+	return share
+}
+
+// create creates a poll, with io_uring when it is enabled and supported.
+func (s *Server) create() (*Poll, error) {
+	if s.IOUring || forceIOUring {
+		p, err := CreateIOUring()
+		if err != ErrIOUring {
+			s.uring = err == nil
+			return p, err
+		}
+	}
+	return Create()
+}
+
+// acceptRing accepts the connections of the listener l with acceptBatch
+// io_uring accept requests, queued again once they complete.
+func (s *Server) acceptRing(l *listener) (err error) {
+	ops := make([]*ioOp, acceptBatch)
+	for i := range ops {
+		ops[i] = &ioOp{buf: make([]byte, syscall.SizeofSockaddrAny)}
+		if err = l.poll.accept(l.fd, ops[i]); err != nil {
+			return err
+		}
+	}
+	var events = make([]Event, acceptBatch)
 	for err == nil {
-		if n, err = s.poll.Wait(events); n > 0 {
-			if events[0].Fd == s.fd {
-				err = s.accept()
+		if _, err = l.poll.Wait(events); err != nil {
+			break
+		}
+		for _, op := range ops {
+			res, done := op.take()
+			if !done {
+				continue
+			}
+			switch {
+			case res >= 0:
+				err = s.register(l, int(res), op.sockaddr())
+			case res != -int32(syscall.EAGAIN) && res != -int32(syscall.EINTR) && res != -int32(syscall.ECONNABORTED):
+				err = syscall.Errno(-res)
+			}
+			if err != nil {
+				break
+			}
+			if err = l.poll.accept(l.fd, op); err != nil {
+				break
 			}
-			s.wakeReschedule()
 		}
+		s.wakeReschedule()
 		runtime.Gosched()
 	}
-	s.wg.Wait()
 	return err
 }
 
-func (s *Server) accept() (err error) {
-	nfd, sa, err := syscall.Accept(s.fd)
+func (s *Server) accept(l *listener) (err error) {
+	nfd, sa, err := syscall.Accept(l.fd)
 	if err != nil {
 		if err == syscall.EAGAIN {
 			return nil
@@ -183,6 +474,11 @@ func (s *Server) accept() (err error) {
 	if err := syscall.SetNonblock(nfd, true); err != nil {
 		return err
 	}
+	return s.register(l, nfd, sa)
+}
+
+// register assigns the connection accepted by the listener l to a worker.
+func (s *Server) register(l *listener, nfd int, sa syscall.Sockaddr) (err error) {
 	var raddr net.Addr
 	switch sockaddr := sa.(type) {
 	case *syscall.SockaddrUnix:
@@ -210,33 +506,17 @@ func (s *Server) accept() (err error) {
 		}
 	}
 	s.lock.Lock()
-	w := s.assignWorker()
-	err = w.register(&conn{w: w, fd: nfd, raddr: raddr, laddr: s.addr})
+	w := l.group.assignWorker()
+	err = w.register(&conn{w: w, fd: nfd, raddr: raddr, laddr: l.addr, uring: s.uring})
 	s.lock.Unlock()
+	s.stats.accepted.Add(1)
 	return
 }
 
-func (s *Server) assignWorker() (w *worker) {
-	if w := s.idleUnsharedWorkers(); w != nil {
-		return w
-	}
-	return s.leastConnectedSharedWorkers()
-}
-
-func (s *Server) idleUnsharedWorkers() (w *worker) {
-	if s.unsharedWorkers > 0 {
-		for i := 0; i < int(s.unsharedWorkers); i++ {
-			if s.workers[i].count < 1 {
-				return s.workers[i]
-			}
-		}
-	}
-	return nil
-}
-
-func (s *Server) leastConnectedSharedWorkers() (w *worker) {
-	minHeap(s.heap)
-	return s.heap[0]
+// nextGroup returns the groups in turn, for the dialed connections.
+func (s *Server) nextGroup() *group {
+	s.next++
+	return s.groups[s.next%uint32(len(s.groups))]
 }
 
 func (s *Server) wakeReschedule() {
@@ -281,17 +561,33 @@ func (s *Server) reschedule() (stop bool) {
 		return false
 	}
 	defer atomic.StoreInt32(&s.rescheduling, 0)
+	s.stats.reschedules.Add(1)
+	stop = true
+	for _, g := range s.groups {
+		if !s.rescheduleGroup(g) {
+			stop = false
+		}
+	}
+	return stop
+}
+
+// rescheduleGroup moves the busiest connections of the group to its unshared
+// workers.
+func (s *Server) rescheduleGroup(g *group) (stop bool) {
+	if g.unshared == 0 {
+		return true
+	}
 	s.adjust = s.adjust[:0]
 	s.list = s.list[:0]
 	sum := int64(0)
-	for idx, w := range s.workers {
+	for idx, w := range g.workers {
 		w.lock.Lock()
 		if !w.running {
 			w.lock.Unlock()
 			continue
 		}
 		for _, conn := range w.conns {
-			if uint(idx) < s.unsharedWorkers {
+			if uint(idx) < g.unshared {
 				s.adjust = append(s.adjust, conn)
 			}
 			conn.score = atomic.LoadInt64(&conn.count)
@@ -304,8 +600,8 @@ func (s *Server) reschedule() (stop bool) {
 	if len(s.list) == 0 || sum == 0 {
 		return true
 	}
-	unsharedWorkers := s.unsharedWorkers
-	if uint(len(s.list)) < s.unsharedWorkers {
+	unsharedWorkers := g.unshared
+	if uint(len(s.list)) < g.unshared {
 		unsharedWorkers = uint(len(s.list))
 	}
 	topK(s.list, int(unsharedWorkers))
@@ -351,6 +647,7 @@ func (s *Server) reschedule() (stop bool) {
 		sharedWorker.decrease(reschedules[i])
 		reschedules[i].w = unsharedWorker
 		unsharedWorker.increase(reschedules[i])
+		s.stats.rescheduled.Add(2)
 		sharedWorker.lock.Unlock()
 		unsharedWorker.lock.Unlock()
 		s.adjust[i].lock.Unlock()
@@ -359,28 +656,226 @@ func (s *Server) reschedule() (stop bool) {
 	return false
 }
 
-// Close closes the server.
+// Stats returns a snapshot of the statistics of the server.
+func (s *Server) Stats() Stats {
+	stats := s.stats.snapshot()
+	s.lock.Lock()
+	workers := s.workers
+	s.lock.Unlock()
+	for _, w := range workers {
+		w.lock.Lock()
+		running := w.running
+		w.lock.Unlock()
+		conns := atomic.LoadInt64(&w.count)
+		stats.Active += conns
+		stats.Workers = append(stats.Workers, WorkerStats{
+			Index:   w.index,
+			Group:   w.group,
+			Shared:  w.shared,
+			Running: running,
+			Conns:   conns,
+			Events:  w.polled.Load(),
+		})
+	}
+	return stats
+}
+
+// Close closes the server. It closes the connections immediately,
+// even after a Shutdown that did not complete.
 func (s *Server) Close() error {
-	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+	atomic.StoreInt32(&s.closed, 1)
+	if s.netServer == nil {
+		s.closeWorkers()
+	}
+	return s.stop()
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections,
+// closes the idle connections, and waits for the active connections to
+// finish serving and flushing their writes, then closes the workers. A new
+// connection not upgraded within 5 seconds is shut down.
+//
+// If the context expires first, Shutdown returns the context's error and the
+// remaining connections keep being served until Close is called.
+func (s *Server) Shutdown(ctx stdcontext.Context) error {
+	atomic.StoreInt32(&s.closed, 1)
+	if s.netServer != nil {
+		return s.stop()
+	}
+	err := s.stop()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeIdleConns() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.closeWorkers()
+	return err
+}
+
+// closeIdleConns closes the idle connections and reports whether all the
+// connections are closed.
+func (s *Server) closeIdleConns() bool {
+	s.lock.Lock()
+	workers := s.workers
+	s.lock.Unlock()
+	quiescent := true
+	var idle []*conn
+	for _, w := range workers {
+		w.lock.Lock()
+		for _, c := range w.conns {
+			if c.closeIdle() {
+				idle = append(idle, c)
+			} else {
+				quiescent = false
+			}
+		}
+		w.lock.Unlock()
+	}
+	for _, c := range idle {
+		if atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+			c.Close()
+		}
+	}
+	return quiescent
+}
+
+func (s *Server) closeWorkers() {
+	s.lock.Lock()
+	workers := s.workers
+	s.lock.Unlock()
+	for i := 0; i < len(workers); i++ {
+		workers[i].Close()
+	}
+}
+
+// stop stops accepting connections, once.
+func (s *Server) stop() error {
+	if !atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
 		return nil
 	}
 	if s.netServer != nil {
 		return s.netServer.Close()
 	}
-	for i := 0; i < len(s.workers); i++ {
-		s.workers[i].Close()
+	s.lock.Lock()
+	listeners, done := s.listeners, s.done
+	packets, packetConn := s.packets, s.packetConn
+	s.lock.Unlock()
+	for _, c := range packets {
+		c.wake()
 	}
-	if err := s.file.Close(); err != nil {
-		return err
+	if packetConn != nil {
+		packetConn.Close()
+	}
+	if done != nil {
+		close(done)
+	}
+	var err error
+	for _, l := range listeners {
+		if lerr := l.close(); err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+// group is a group of workers serving the connections of its listeners.
+// The unshared workers come first.
+type group struct {
+	index    int
+	workers  []*worker
+	heap     []*worker
+	unshared uint
+}
+
+func (g *group) assignWorker() (w *worker) {
+	if w := g.idleUnsharedWorkers(); w != nil {
+		return w
 	}
-	if s.done != nil {
-		close(s.done)
+	return g.leastConnectedSharedWorkers()
+}
+
+func (g *group) idleUnsharedWorkers() (w *worker) {
+	if g.unshared > 0 {
+		for i := 0; i < int(g.unshared); i++ {
+			if atomic.LoadInt64(&g.workers[i].count) < 1 {
+				return g.workers[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (g *group) leastConnectedSharedWorkers() (w *worker) {
+	minHeap(g.heap)
+	return g.heap[0]
+}
+
+// listener is a listening socket accepted on its own loop.
+type listener struct {
+	// ln is closed once the server stops, a unix listener removes its
+	// socket file then.
+	ln    net.Listener
+	file  *os.File
+	fd    int
+	addr  net.Addr
+	poll  *Poll
+	group *group
+	// uring is set when the listener accepts with io_uring requests.
+	uring bool
+}
+
+// newListener duplicates the socket of the *net.TCPListener or
+// *net.UnixListener l in non-blocking mode.
+func newListener(l net.Listener) (*listener, error) {
+	var file *os.File
+	var err error
+	switch netListener := l.(type) {
+	case *net.TCPListener:
+		file, err = netListener.File()
+	case *net.UnixListener:
+		file, err = netListener.File()
+	default:
+		err = ErrListener
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	ln := &listener{ln: l, file: file, fd: int(file.Fd()), addr: l.Addr()}
+	if err := syscall.SetNonblock(ln.fd, true); err != nil {
+		ln.close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (l *listener) close() error {
+	if l.poll != nil {
+		l.poll.Unregister(l.fd)
+	}
+	if l.uring {
+		// The accepts in flight keep the socket listening until the
+		// kernel cancels them.
+		syscall.Shutdown(l.fd, syscall.SHUT_RDWR)
+	}
+	err := l.file.Close()
+	l.ln.Close()
+	if l.poll != nil {
+		if perr := l.poll.Close(); err == nil {
+			err = perr
+		}
 	}
-	return s.poll.Close()
+	return err
 }
 
 type worker struct {
 	index    int
+	group    int
+	shared   bool
 	server   *Server
 	count    int64
 	lock     sync.Mutex
@@ -393,6 +888,12 @@ type worker struct {
 	running  bool
 	slept    int32
 	closed   int32
+	timers   *timerWheel
+	wakeup   [2]int
+	polled   atomic.Uint64
+	// posted are the events dispatched once the loop is woken up, guarded
+	// by lock.
+	posted []Event
 }
 
 func (w *worker) run(wg *sync.WaitGroup) {
@@ -400,24 +901,26 @@ func (w *worker) run(wg *sync.WaitGroup) {
 	var n int
 	var err error
 	for err == nil {
+		w.poll.SetTimeout(w.timers.timeout(time.Now(), time.Second))
 		n, err = w.poll.Wait(w.events)
 		if n > 0 {
+			w.polled.Add(uint64(n))
 			for i := range w.events[:n] {
 				ev := w.events[i]
-				if w.async {
-					wg.Add(1)
-					scheduler.Schedule(func() {
-						w.serve(ev)
-						wg.Done()
-					})
-				} else {
-					w.serve(ev)
+				if ev.Fd == w.wakeup[0] {
+					w.drainWakeup()
+					w.dispatchPosted()
+					continue
 				}
+				w.dispatch(ev)
 			}
 		}
+		w.timers.advance(time.Now())
 		if atomic.LoadInt64(&w.count) < 1 {
 			w.lock.Lock()
-			if len(w.conns) == 0 && w.lastIdle.Add(idleTime).Before(time.Now()) {
+			// The timers of a rescheduled connection may be left, and the
+			// requests it queued complete on this worker.
+			if len(w.conns) == 0 && w.timers.pending() == 0 && w.poll.inflight() == 0 && w.lastIdle.Add(idleTime).Before(time.Now()) {
 				w.sleep()
 				w.running = false
 				w.lock.Unlock()
@@ -429,6 +932,81 @@ func (w *worker) run(wg *sync.WaitGroup) {
 	}
 }
 
+// dispatch serves ev on the worker loop, or on the scheduler for async
+// workers.
+func (w *worker) dispatch(ev Event) {
+	if w.async {
+		wg := &w.server.wg
+		wg.Add(1)
+		scheduler.Schedule(func() {
+			w.serve(ev)
+			wg.Done()
+		})
+	} else {
+		w.serve(ev)
+	}
+}
+
+// afterFunc runs f on the worker loop at when, in unix nanoseconds.
+func (w *worker) afterFunc(when int64, f func()) *timer {
+	t, earlier := w.timers.add(when, f)
+	if earlier {
+		// The loop may be waiting with a later timeout.
+		syscall.Write(w.wakeup[1], []byte{0})
+	}
+	return t
+}
+
+func (w *worker) openWakeup() error {
+	if err := syscall.Pipe(w.wakeup[:]); err != nil {
+		return err
+	}
+	for _, fd := range w.wakeup {
+		syscall.CloseOnExec(fd)
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			w.closeWakeup()
+			return err
+		}
+	}
+	if err := w.poll.Register(w.wakeup[0]); err != nil {
+		w.closeWakeup()
+		return err
+	}
+	return nil
+}
+
+// post dispatches ev on the worker loop.
+func (w *worker) post(ev Event) {
+	w.lock.Lock()
+	w.posted = append(w.posted, ev)
+	w.lock.Unlock()
+	syscall.Write(w.wakeup[1], []byte{0})
+}
+
+func (w *worker) dispatchPosted() {
+	w.lock.Lock()
+	posted := w.posted
+	w.posted = nil
+	w.lock.Unlock()
+	for _, ev := range posted {
+		w.dispatch(ev)
+	}
+}
+
+func (w *worker) drainWakeup() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(w.wakeup[0], buf[:]); n < len(buf) {
+			return
+		}
+	}
+}
+
+func (w *worker) closeWakeup() {
+	syscall.Close(w.wakeup[0])
+	syscall.Close(w.wakeup[1])
+}
+
 func (w *worker) serve(ev Event) error {
 	fd := ev.Fd
 	if fd == 0 {
@@ -441,28 +1019,65 @@ func (w *worker) serve(ev Event) error {
 		return nil
 	}
 	w.lock.Unlock()
+	if atomic.LoadInt32(&c.dialing) == 1 {
+		c.connected()
+		return nil
+	}
+	if c.hs != nil && c.hs.resume() {
+		return nil
+	}
 	if atomic.LoadInt32(&c.ready) == 0 {
 		return nil
 	}
-	switch ev.Mode {
-	case WRITE:
-	case READ:
-		w.serveConn(c)
+	if ev.Mode&WRITE != 0 {
+		c.flush()
 	}
-	return nil
+	if atomic.LoadInt32(&c.lingering) != 0 {
+		if ev.Mode&READ != 0 {
+			c.discard()
+		}
+		if w.server.Trigger == ONESHOT && atomic.LoadInt32(&c.lingering) < 3 {
+			c.rearm()
+		}
+		return nil
+	}
+	if ev.Mode&READ != 0 {
+		w.serveConn(c)
+	} else if w.server.Trigger == ONESHOT {
+		c.rearm()
+	}
+	return nil
 }
 
 func (w *worker) serveConn(c *conn) error {
+	if !c.activate() {
+		return nil
+	}
+	defer c.deactivate()
 	for {
-		err := w.server.Handler.Serve(c.context)
+		err := c.handler.Serve(c.context)
 		if err != nil {
 			if err == syscall.EAGAIN {
+				switch w.server.Trigger {
+				case EDGE:
+					// No more edge is reported for data left unread.
+					if atomic.LoadInt32(&c.drained) == 0 {
+						c.rearm()
+					}
+				case ONESHOT:
+					c.rearm()
+				default:
+					// The socket polled is drained, the data received with
+					// io_uring and left unread is reported again.
+					if c.rop != nil {
+						c.rearm()
+					}
+				}
 				return nil
 			}
 			if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
 				return nil
 			}
-			w.Decrease(c)
 			c.Close()
 			return nil
 		}
@@ -470,27 +1085,74 @@ func (w *worker) serveConn(c *conn) error {
 }
 
 func (w *worker) register(c *conn) error {
+	w.setup(c)
+	if w.server.TLSConfig != nil {
+		c.hs = newHandshake()
+	}
 	w.Increase(c)
-	go func(w *worker, c *conn) {
-		var err error
-		defer func() {
-			if err != nil {
-				w.Decrease(c)
-				c.Close()
-			}
-		}()
-		if err = syscall.SetNonblock(c.fd, false); err != nil {
-			return
+	go func() {
+		if w.upgrade(c) == nil {
+			w.serveConn(c)
 		}
-		if c.context, err = w.server.Handler.Upgrade(c); err != nil {
-			return
+	}()
+	return nil
+}
+
+// setup sets the connection options from the server.
+func (w *worker) setup(c *conn) {
+	c.created = time.Now().UnixNano()
+	if c.handler == nil {
+		c.handler = w.server.Handler
+	}
+	c.connState = w.server.ConnState
+	c.stats = &w.server.stats
+	c.highWatermark, c.lowWatermark = defaultHighWatermark, 0
+	if w.server.HighWatermark > 0 {
+		c.highWatermark = w.server.HighWatermark
+	}
+	if c.lowWatermark = w.server.LowWatermark; c.lowWatermark <= 0 || c.lowWatermark > c.highWatermark {
+		c.lowWatermark = c.highWatermark / 2
+	}
+	c.watermark, _ = c.handler.(WatermarkHandler)
+	c.flushHandler, _ = c.handler.(FlushHandler)
+	c.lingerTimeout = int64(w.server.Linger)
+}
+
+// upgrade upgrades the connection in blocking mode and makes it ready to be
+// served. The connection is closed when it fails.
+func (w *worker) upgrade(c *conn) (err error) {
+	defer func() {
+		if err != nil {
+			w.Decrease(c)
+			c.Close()
 		}
-		if err = syscall.SetNonblock(c.fd, true); err != nil {
+	}()
+	c.setState(StateNew)
+	if w.server.IdleTimeout > 0 {
+		c.idleTimeout = int64(w.server.IdleTimeout)
+		c.touch()
+		c.armIdle()
+	}
+	var conn net.Conn = c
+	// The dialed connections are not terminated.
+	if c.hs != nil {
+		if conn, err = c.handshake(w.server.TLSConfig, w.server.TLSHandshakeTimeout); err != nil {
 			return
 		}
-		atomic.StoreInt32(&c.ready, 1)
-		w.serveConn(c)
-	}(w, c)
+	}
+	if err = syscall.SetNonblock(c.fd, false); err != nil {
+		return
+	}
+	if c.context, err = c.handler.Upgrade(conn); err != nil {
+		return
+	}
+	if err = syscall.SetNonblock(c.fd, true); err != nil {
+		return
+	}
+	if c.uring {
+		c.startIO()
+	}
+	atomic.StoreInt32(&c.ready, 1)
 	return nil
 }
 
@@ -504,6 +1166,14 @@ func (w *worker) increase(c *conn) {
 	w.conns[c.fd] = c
 	atomic.AddInt64(&w.count, 1)
 	w.poll.Register(c.fd)
+	if atomic.LoadInt32(&c.blocked) != 0 {
+		w.poll.Write(c.fd)
+	}
+	if c.rop != nil {
+		// The requests completed on the previous worker may have been
+		// reaped but not served.
+		w.poll.notify(c.fd)
+	}
 	w.wake()
 }
 
@@ -514,6 +1184,10 @@ func (w *worker) Decrease(c *conn) {
 }
 
 func (w *worker) decrease(c *conn) {
+	// The file descriptor of a closed connection may be reused already.
+	if w.conns[c.fd] != c {
+		return
+	}
 	w.poll.Unregister(c.fd)
 	delete(w.conns, c.fd)
 	if atomic.AddInt64(&w.count, -1) < 1 {
@@ -543,12 +1217,19 @@ func (w *worker) Close() {
 		return
 	}
 	w.lock.Lock()
-	for _, c := range w.conns {
-		c.Close()
-		delete(w.conns, c.fd)
+	conns := w.conns
+	w.conns = make(map[int]*conn)
+	w.lock.Unlock()
+	// The connections are closed right away, discarding the data left.
+	for _, c := range conns {
+		if c.Close(); atomic.LoadInt32(&c.lingering) != 0 {
+			c.endLinger(ErrServerClosed)
+		}
 	}
+	w.lock.Lock()
 	w.sleep()
 	w.poll.Close()
+	w.closeWakeup()
 	w.lock.Unlock()
 }
 
@@ -561,11 +1242,72 @@ type conn struct {
 	laddr   net.Addr
 	raddr   net.Addr
 	context Context
-	ready   int32
-	count   int64
-	score   int64
-	closing int32
-	closed  int32
+	handler Handler
+	// dialing is set until the connect of a dialed connection completes,
+	// which is reported on dialed.
+	dialing int32
+	dialed  chan error
+	// hs drives the TLS handshake of an accepted connection.
+	hs *handshake
+	// state is the ConnState, serving counts the Serve loops running,
+	// both guarded by stateLock.
+	stateLock sync.Mutex
+	state     ConnState
+	serving   int
+	// created is when the connection was registered, in unix nanoseconds.
+	created   int64
+	connState func(net.Conn, ConnState)
+	stats     *stats
+	ready     int32
+	count     int64
+	score     int64
+	closing   int32
+	closed    int32
+	// drained reports whether the last Read returned EAGAIN.
+	drained int32
+	// uring reports whether the connection is polled with io_uring,
+	// whose poll requests keep the socket open until they complete.
+	uring bool
+	// Deadlines are in unix nanoseconds, zero means none.
+	rdeadline   int64
+	wdeadline   int64
+	rtimeo      bool
+	wtimeo      bool
+	idleTimeout int64
+	lastActive  int64
+	timerLock   sync.Mutex
+	rtimer      *timer
+	itimer      *timer
+	ltimer      *timer
+	// timers are the Timers scheduled with AfterFunc and Every.
+	timers map[*connTimer]struct{}
+	// wbuf holds the data not written yet, guarded by wlock.
+	wbuf          []byte
+	buffered      int64
+	highWatermark int
+	lowWatermark  int
+	aboveHigh     bool
+	watermark     WatermarkHandler
+	flushHandler  FlushHandler
+	// lingerTimeout is the Server.Linger in nanoseconds.
+	lingerTimeout int64
+	// blocked reports whether the worker waits for the socket to be
+	// writable, set with wlock held.
+	blocked int32
+	// transfers are queued by SendFile and Splice, guarded by wlock.
+	transfers    []*Transfer
+	transferring int32
+	// rop and wop are the recv and send requests of a connection polled with
+	// io_uring, set once it is upgraded. rbuf holds the data received by rop
+	// and not read yet, and rerr the error received, guarded by rlock.
+	rop  *ioOp
+	wop  *ioOp
+	rbuf []byte
+	rerr error
+	// lingering is set to 1 when the connection is closed with data left to
+	// write, to 2 once the data is flushed, and to 3 once its socket is
+	// closed.
+	lingering int32
 }
 
 // Read reads data from the connection.
@@ -573,38 +1315,113 @@ func (c *conn) Read(b []byte) (n int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return 0, EOF
+	}
 	c.lock.Lock()
-	if c.w.server.rescheduled {
+	if c.w != nil && c.w.server.rescheduled {
 		c.lock.Unlock()
 		atomic.AddInt64(&c.count, 1)
 	} else {
 		c.lock.Unlock()
 	}
 	c.rlock.Lock()
-	n, err = syscall.Read(c.fd, b)
+	deadline := atomic.LoadInt64(&c.rdeadline)
+	blocking := atomic.LoadInt32(&c.ready) == 0
+	if blocking {
+		err = c.setSockTimeout(syscall.SO_RCVTIMEO, deadline, &c.rtimeo)
+	} else if deadlineExceeded(deadline) {
+		err = os.ErrDeadlineExceeded
+	}
+	for err == nil {
+		if !blocking && c.rop != nil {
+			n, err = c.recv(b)
+			break
+		}
+		// A blocking read with a timeout is interrupted by signals.
+		if n, err = syscall.Read(c.fd, b); err != syscall.EINTR || !blocking {
+			break
+		}
+		err = c.setSockTimeout(syscall.SO_RCVTIMEO, deadline, &c.rtimeo)
+	}
 	c.rlock.Unlock()
+	if err == syscall.EAGAIN {
+		atomic.StoreInt32(&c.drained, 1)
+		if c.stats != nil {
+			c.stats.eagains.Add(1)
+		}
+	} else if n > 0 {
+		atomic.StoreInt32(&c.drained, 0)
+		if c.stats != nil {
+			c.stats.read.Add(uint64(n))
+		}
+	}
+	if err == syscall.EAGAIN && blocking && deadline != 0 {
+		err = os.ErrDeadlineExceeded
+	}
+	if err == os.ErrDeadlineExceeded {
+		return 0, err
+	}
 	if err != nil && err != syscall.EAGAIN || err == nil && n == 0 {
 		err = EOF
 	}
 	if n < 0 {
 		n = 0
 	}
+	if n > 0 && c.idleTimeout > 0 {
+		c.touch()
+	}
 	return
 }
 
 // Write writes data to the connection.
+//
+// Once the connection is upgraded, Write never blocks. The data the socket
+// does not accept right away is buffered and flushed by the worker when the
+// socket becomes writable. A Handler implementing WatermarkHandler is notified
+// when the buffer grows above Server.HighWatermark and when it drains again.
 func (c *conn) Write(b []byte) (n int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
 	var remain = len(b)
 	c.wlock.Lock()
+	if atomic.LoadInt32(&c.closed) != 0 {
+		c.wlock.Unlock()
+		return 0, EOF
+	}
+	deadline := atomic.LoadInt64(&c.wdeadline)
+	blocking := atomic.LoadInt32(&c.ready) == 0
+	if blocking {
+		err = c.setSockTimeout(syscall.SO_SNDTIMEO, deadline, &c.wtimeo)
+	} else if deadlineExceeded(deadline) {
+		err = os.ErrDeadlineExceeded
+	}
+	if err != nil {
+		c.wlock.Unlock()
+		return 0, err
+	}
+	if !blocking {
+		return c.bufferedWrite(b)
+	}
+	defer func() { c.wrote(int64(n)) }()
 	for remain > 0 {
 		n, err = syscall.Write(c.fd, b[len(b)-remain:])
 		if n > 0 {
 			remain -= n
 			continue
 		}
+		if err == syscall.EINTR {
+			if err = c.setSockTimeout(syscall.SO_SNDTIMEO, deadline, &c.wtimeo); err != nil {
+				c.wlock.Unlock()
+				return len(b) - remain, err
+			}
+			continue
+		}
+		if err == syscall.EAGAIN && deadline != 0 && (blocking || deadlineExceeded(deadline)) {
+			c.wlock.Unlock()
+			return len(b) - remain, os.ErrDeadlineExceeded
+		}
 		if err != syscall.EAGAIN {
 			c.wlock.Unlock()
 			return len(b) - remain, EOF
@@ -614,14 +1431,448 @@ func (c *conn) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
-// Close closes the connection.
+// wrote counts the bytes written to the connection.
+func (c *conn) wrote(n int64) {
+	if n > 0 && c.stats != nil {
+		c.stats.written.Add(uint64(n))
+	}
+}
+
+// bufferedWrite writes b without blocking, buffering what the socket does
+// not accept. The bytes buffered are counted as written before they are
+// flushed. c.wlock must be held and is released.
+func (c *conn) bufferedWrite(b []byte) (n int, err error) {
+	if len(c.wbuf) == 0 && len(c.transfers) == 0 && c.wop == nil {
+		for n < len(b) {
+			written, err := syscall.Write(c.fd, b[n:])
+			if written > 0 {
+				n += written
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			c.wlock.Unlock()
+			c.wrote(int64(n))
+			return n, EOF
+		}
+		if n == len(b) {
+			c.wlock.Unlock()
+			c.wrote(int64(n))
+			return n, nil
+		}
+	}
+	// The bytes are counted before a send can deliver them to the peer.
+	c.wrote(int64(len(b)))
+	// The data written after a transfer waits for it.
+	first := atomic.LoadInt32(&c.blocked) == 0 && len(c.transfers) == 0
+	c.wbuf = append(c.wbuf, b[n:]...)
+	atomic.StoreInt64(&c.buffered, int64(len(c.wbuf)))
+	high := !c.aboveHigh && len(c.wbuf) > c.highWatermark
+	if high {
+		c.aboveHigh = true
+	}
+	if first {
+		// The worker flushes once the socket is writable.
+		atomic.StoreInt32(&c.blocked, 1)
+		c.waitWritable()
+	}
+	c.wlock.Unlock()
+	if high && c.watermark != nil {
+		c.watermark.HighWatermark(c.context)
+	}
+	return len(b), nil
+}
+
+// flush writes the buffered data the socket accepts and sends the transfers
+// queued, on the worker loop.
+func (c *conn) flush() {
+	c.wlock.Lock()
+	if atomic.LoadInt32(&c.lingering) == 3 {
+		// The socket is closed.
+		c.wlock.Unlock()
+		return
+	}
+	written, blocked, sent, finished := c.sendTransfers()
+	if written == len(c.wbuf) {
+		if cap(c.wbuf) > c.highWatermark {
+			c.wbuf = nil
+		} else {
+			c.wbuf = c.wbuf[:0]
+		}
+	} else {
+		c.wbuf = c.wbuf[:copy(c.wbuf, c.wbuf[written:])]
+	}
+	for _, t := range c.transfers {
+		t.pos -= written
+	}
+	if blocked {
+		atomic.StoreInt32(&c.blocked, 1)
+		c.waitWritable()
+	} else if w := c.worker(); atomic.SwapInt32(&c.blocked, 0) != 0 && !c.uring && w != nil && w.server.Trigger == LEVEL {
+		// A level triggered write event is reported until it is removed.
+		c.rearm()
+	}
+	atomic.StoreInt64(&c.buffered, int64(len(c.wbuf)))
+	low := c.aboveHigh && len(c.wbuf) <= c.lowWatermark
+	if low {
+		c.aboveHigh = false
+	}
+	// The writing side of a lingering connection is shut down once its
+	// data is flushed.
+	closeWrite := len(c.wbuf) == 0 && len(c.transfers) == 0 && atomic.CompareAndSwapInt32(&c.lingering, 1, 2)
+	if closeWrite {
+		syscall.Shutdown(c.fd, syscall.SHUT_WR)
+	}
+	c.wlock.Unlock()
+	finishTransfers(sent, finished)
+	if closeWrite {
+		c.waitClose()
+		return
+	}
+	if low && c.watermark != nil {
+		c.watermark.LowWatermark(c.context)
+	}
+}
+
+// rearm rearms the connection in the poll of its worker after it was served
+// with EDGE or ONESHOT, keeping the write event while the socket is waited
+// for to be writable.
+func (c *conn) rearm() {
+	if c.rop != nil {
+		// The data received and left unread is reported again.
+		c.rlock.Lock()
+		left := len(c.rbuf) > 0
+		c.rlock.Unlock()
+		if left {
+			c.notify()
+			return
+		}
+	}
+	c.lock.Lock()
+	if c.w != nil {
+		if atomic.LoadInt32(&c.blocked) != 0 {
+			c.w.poll.Write(c.fd)
+		} else {
+			c.w.poll.Rearm(c.fd)
+		}
+	}
+	c.lock.Unlock()
+}
+
+// startIO makes the upgraded connection read and write with io_uring recv
+// and send requests.
+func (c *conn) startIO() {
+	c.rop = &ioOp{buf: make([]byte, recvSize), wake: c.resume}
+	c.wop = &ioOp{wake: c.resume}
+}
+
+// recv reads the data received with io_uring. Without a recv in flight the
+// socket is read directly, and a recv is queued once it is drained. c.rlock
+// must be held.
+func (c *conn) recv(b []byte) (n int, err error) {
+	if len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		res, done := c.rop.take()
+		switch {
+		case done && res > 0:
+			c.rbuf = c.rop.buf[:res]
+		case done && res != -int32(syscall.EAGAIN) && res != -int32(syscall.EINTR) && res != -int32(syscall.ECANCELED):
+			c.rerr = EOF
+			return 0, c.rerr
+		case !done && atomic.LoadInt32(&c.rop.state) != opIdle:
+			return 0, syscall.EAGAIN
+		default:
+			// No recv is in flight, or it was canceled by a reschedule.
+			for {
+				if n, err = syscall.Read(c.fd, b); err != syscall.EINTR {
+					break
+				}
+			}
+			if err == syscall.EAGAIN {
+				c.queueRecv()
+			}
+			return n, err
+		}
+	}
+	n = copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// queueRecv queues a recv to the worker. c.rlock must be held.
+func (c *conn) queueRecv() {
+	err := error(EOF)
+	c.lock.Lock()
+	if c.w != nil {
+		err = c.w.poll.recv(c.fd, c.rop)
+	}
+	c.lock.Unlock()
+	if err != nil {
+		c.rerr = EOF
+	}
+}
+
+// waitWritable makes the worker flush once the socket is writable, or sends
+// the data buffered before the first transfer with io_uring. c.wlock must be
+// held.
+func (c *conn) waitWritable() {
+	end := len(c.wbuf)
+	if len(c.transfers) > 0 {
+		end = c.transfers[0].pos
+	}
+	if c.wop == nil || end == 0 {
+		c.pollWrite()
+		return
+	}
+	if atomic.LoadInt32(&c.wop.state) != opIdle {
+		return
+	}
+	c.wop.buf = c.wbuf[:end]
+	c.lock.Lock()
+	if c.w != nil {
+		c.w.poll.send(c.fd, c.wop)
+	}
+	c.lock.Unlock()
+}
+
+// sent takes the number of bytes of the completed send, it returns -1
+// while the send is in flight or has to be queued again. c.wlock must be
+// held.
+func (c *conn) sent(end int) int {
+	res, done := c.wop.take()
+	switch {
+	case !done || res == -int32(syscall.EAGAIN) || res == -int32(syscall.EINTR) || res == -int32(syscall.ECANCELED):
+		return -1
+	case res < 0:
+		// The peer is gone, the data is dropped.
+		return end
+	}
+	return int(res)
+}
+
+// notify serves the connection again on its worker.
+func (c *conn) notify() {
+	c.lock.Lock()
+	if c.w != nil {
+		c.w.poll.notify(c.fd)
+	}
+	c.lock.Unlock()
+}
+
+// resume serves the connection on its new worker once a request queued to
+// its previous worker completes.
+func (c *conn) resume() {
+	if lingering := atomic.LoadInt32(&c.lingering); lingering == 3 {
+		return
+	} else if lingering == 0 && atomic.LoadInt32(&c.closed) != 0 {
+		// The send in flight when the connection was closed without
+		// lingering completes.
+		c.wlock.Lock()
+		res, done := c.wop.take()
+		c.wlock.Unlock()
+		if done && int(res) < len(c.wop.buf) {
+			c.flushFailed(ErrUnflushed)
+		}
+		return
+	}
+	c.notify()
+}
+
+// Buffered returns the number of bytes written to the connection that are
+// still waiting to be sent.
+func (c *conn) Buffered() int {
+	return int(atomic.LoadInt64(&c.buffered))
+}
+
+// Close closes the connection and removes it from its worker. The data
+// still buffered for writing is written as far as the socket accepts it
+// right away and the rest is dropped, unless Server.Linger is set. Then the
+// buffered data and the transfers queued of an upgraded connection keep
+// being sent by the worker, for Linger at most or until the write deadline.
+// Once they are sent, the socket is closed when the peer closes the
+// connection, or half a second later, and the data received meanwhile is
+// discarded. A FlushHandler is notified when data is dropped.
 func (c *conn) Close() (err error) {
 	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		return
 	}
+	defer c.setState(StateClosed)
+	if c.stats != nil {
+		c.stats.closed.Add(1)
+	}
+	if c.hs != nil {
+		c.hs.abort(net.ErrClosed)
+	}
+	c.timerLock.Lock()
+	c.stopTimer(&c.rtimer)
+	c.stopTimer(&c.itimer)
+	c.timerLock.Unlock()
+	c.stopTimers()
+	if c.linger() {
+		return nil
+	}
+	if w := c.worker(); w != nil {
+		w.Decrease(c)
+	}
+	if c.drop() {
+		c.flushFailed(ErrUnflushed)
+	}
+	if atomic.LoadInt32(&c.transferring) > 0 {
+		c.closeTransfers()
+	}
+	return c.shut()
+}
+
+// drop writes the data left to write by a connection closed without
+// lingering as far as the socket accepts it right away, and reports whether
+// the rest is dropped.
+func (c *conn) drop() bool {
+	if atomic.LoadInt64(&c.buffered) == 0 && atomic.LoadInt32(&c.transferring) == 0 {
+		return false
+	} else if !c.wlock.TryLock() {
+		// A write is in progress.
+		return true
+	}
+	defer c.wlock.Unlock()
+	written := 0
+	if c.wop != nil {
+		if res, done := c.wop.take(); done && res > 0 {
+			written = int(res)
+		} else if atomic.LoadInt32(&c.wop.state) != opIdle {
+			// The data a send in flight holds is not written twice, its
+			// completion reports whether some is dropped.
+			return len(c.transfers) > 0
+		}
+	}
+	end := len(c.wbuf)
+	if len(c.transfers) > 0 {
+		end = c.transfers[0].pos
+	}
+	for written < end {
+		n, err := syscall.Write(c.fd, c.wbuf[written:end])
+		if n > 0 {
+			written += n
+		} else if err != syscall.EINTR {
+			break
+		}
+	}
+	c.wbuf = nil
+	return written < end || len(c.transfers) > 0
+}
+
+// flushFailed notifies the FlushHandler that the data left to write is
+// dropped.
+func (c *conn) flushFailed(err error) {
+	if c.flushHandler != nil {
+		c.flushHandler.FlushFailed(c.context, err)
+	}
+}
+
+// shut closes the socket.
+func (c *conn) shut() error {
+	if c.uring {
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+	}
 	return syscall.Close(c.fd)
 }
 
+// linger keeps the socket of the connection closed with data left to write
+// open, until the worker flushes it, and reports whether it does.
+func (c *conn) linger() bool {
+	w := c.worker()
+	if c.lingerTimeout <= 0 || w == nil || atomic.LoadInt32(&w.closed) != 0 || atomic.LoadInt32(&c.ready) == 0 {
+		return false
+	}
+	c.wlock.Lock()
+	pending := len(c.wbuf) > 0 || len(c.transfers) > 0
+	if pending {
+		atomic.StoreInt32(&c.lingering, 1)
+	}
+	c.wlock.Unlock()
+	if !pending {
+		return false
+	}
+	when := time.Now().UnixNano() + c.lingerTimeout
+	if deadline := atomic.LoadInt64(&c.wdeadline); deadline != 0 && deadline < when {
+		when = deadline
+	}
+	c.timerLock.Lock()
+	c.ltimer = w.afterFunc(when, func() { c.endLinger(os.ErrDeadlineExceeded) })
+	c.timerLock.Unlock()
+	return true
+}
+
+// waitClose waits for the peer to close the lingering connection once its
+// data is flushed, as the peer may not read the data if the socket is closed
+// with some of the data received not read.
+func (c *conn) waitClose() {
+	c.timerLock.Lock()
+	c.stopTimer(&c.ltimer)
+	if w := c.worker(); w != nil {
+		c.ltimer = w.afterFunc(time.Now().UnixNano()+int64(closeWaitTimeout), func() { c.endLinger(nil) })
+	}
+	c.timerLock.Unlock()
+}
+
+// endLinger closes the socket of the lingering connection once the peer is
+// gone or the linger timed out, err being the reason reported to the
+// FlushHandler if data is left.
+func (c *conn) endLinger(err error) {
+	if atomic.SwapInt32(&c.lingering, 3) == 3 {
+		return
+	}
+	c.timerLock.Lock()
+	c.stopTimer(&c.ltimer)
+	c.timerLock.Unlock()
+	c.wlock.Lock()
+	left := len(c.wbuf) > 0 || len(c.transfers) > 0
+	c.wlock.Unlock()
+	if w := c.worker(); w != nil {
+		w.Decrease(c)
+	}
+	if atomic.LoadInt32(&c.transferring) > 0 {
+		c.closeTransfers()
+	}
+	// The worker does not use the socket once it is closed.
+	c.rlock.Lock()
+	c.wlock.Lock()
+	c.shut()
+	c.wlock.Unlock()
+	c.rlock.Unlock()
+	if left && err != nil {
+		c.flushFailed(err)
+	}
+}
+
+// discard drops the data received by the lingering connection, and ends
+// the linger once the peer closes or resets the connection.
+func (c *conn) discard() {
+	var buf [4096]byte
+	var err error
+	c.rlock.Lock()
+	for atomic.LoadInt32(&c.lingering) < 3 {
+		var n int
+		if c.rop != nil {
+			n, err = c.recv(buf[:])
+		} else {
+			n, err = syscall.Read(c.fd, buf[:])
+		}
+		if n > 0 || err == syscall.EINTR {
+			continue
+		} else if err == nil {
+			err = EOF
+		}
+		break
+	}
+	c.rlock.Unlock()
+	if err != nil && err != syscall.EAGAIN {
+		c.endLinger(err)
+	}
+}
+
 // LocalAddr returns the local network address.
 func (c *conn) LocalAddr() net.Addr {
 	return c.laddr
@@ -632,16 +1883,186 @@ func (c *conn) RemoteAddr() net.Addr {
 	return c.raddr
 }
 
+// SetDeadline sets the read and write deadlines associated
+// with the connection.
 func (c *conn) SetDeadline(t time.Time) error {
-	return errors.New("not supported")
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
 }
 
+// SetReadDeadline sets the deadline for future Read calls.
+// When it expires the handler is served, so that Read returns
+// os.ErrDeadlineExceeded even if no data arrives.
 func (c *conn) SetReadDeadline(t time.Time) error {
-	return errors.New("not supported")
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	d := unixNano(t)
+	atomic.StoreInt64(&c.rdeadline, d)
+	c.timerLock.Lock()
+	c.stopTimer(&c.rtimer)
+	if w := c.worker(); d != 0 && w != nil && w.timers != nil {
+		c.rtimer = w.afterFunc(d, c.expire)
+	}
+	c.timerLock.Unlock()
+	return nil
 }
 
+// SetWriteDeadline sets the deadline for future Write calls.
 func (c *conn) SetWriteDeadline(t time.Time) error {
-	return errors.New("not supported")
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	atomic.StoreInt64(&c.wdeadline, unixNano(t))
+	return nil
+}
+
+func (c *conn) worker() *worker {
+	c.lock.Lock()
+	w := c.w
+	c.lock.Unlock()
+	return w
+}
+
+// stopTimer cancels *t, c.timerLock must be held.
+func (c *conn) stopTimer(t **timer) {
+	if *t != nil {
+		(*t).stop()
+		*t = nil
+	}
+}
+
+// setState sets the state of the connection and calls the ConnState hook.
+func (c *conn) setState(state ConnState) {
+	c.stateLock.Lock()
+	c.state = state
+	c.stateLock.Unlock()
+	if c.connState != nil {
+		c.connState(c, state)
+	}
+}
+
+// activate makes the connection active unless it is closed.
+func (c *conn) activate() bool {
+	c.stateLock.Lock()
+	if c.state == StateClosed {
+		c.stateLock.Unlock()
+		return false
+	}
+	c.serving++
+	if c.serving > 1 || c.state == StateActive {
+		c.stateLock.Unlock()
+		return true
+	}
+	c.stateLock.Unlock()
+	c.setState(StateActive)
+	return true
+}
+
+// deactivate makes the connection idle when no Serve loop is running.
+func (c *conn) deactivate() {
+	c.stateLock.Lock()
+	c.serving--
+	if c.serving > 0 || c.state != StateActive {
+		c.stateLock.Unlock()
+		return
+	}
+	c.stateLock.Unlock()
+	c.setState(StateIdle)
+}
+
+// closeIdle marks the idle connection with no data left to write as closed,
+// and reports whether it did. It shuts down the socket of a connection not
+// upgraded within newConnGrace.
+func (c *conn) closeIdle() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.state == StateNew && time.Now().UnixNano()-c.created >= int64(newConnGrace) {
+		// The upgrade may block on a silent peer, it fails once the socket
+		// is shut down and closes the connection.
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+		return false
+	}
+	if c.state != StateIdle || c.serving > 0 || atomic.LoadInt64(&c.buffered) > 0 || atomic.LoadInt32(&c.transferring) > 0 {
+		return false
+	}
+	c.state = StateClosed
+	return true
+}
+
+// expire serves the connection once its read deadline has passed.
+func (c *conn) expire() {
+	if atomic.LoadInt32(&c.ready) == 0 || atomic.LoadInt32(&c.closed) != 0 {
+		return
+	}
+	if w := c.worker(); w != nil {
+		w.dispatch(Event{Fd: c.fd, Mode: READ})
+	}
+}
+
+// touch records activity on the connection for the idle timeout.
+func (c *conn) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *conn) armIdle() {
+	c.timerLock.Lock()
+	if w := c.worker(); w != nil && atomic.LoadInt32(&c.closed) == 0 {
+		c.itimer = w.afterFunc(atomic.LoadInt64(&c.lastActive)+c.idleTimeout, c.checkIdle)
+	}
+	c.timerLock.Unlock()
+}
+
+// checkIdle closes the connection if it has been silent for the idle
+// timeout, and checks again later otherwise.
+func (c *conn) checkIdle() {
+	c.timerLock.Lock()
+	c.itimer = nil
+	c.timerLock.Unlock()
+	if time.Now().UnixNano()-atomic.LoadInt64(&c.lastActive) < c.idleTimeout {
+		c.armIdle()
+		return
+	}
+	if atomic.LoadInt32(&c.ready) == 0 {
+		// Unblock the upgrade, which cleans up when it fails.
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return
+	}
+	c.Close()
+}
+
+// setSockTimeout applies the deadline d to the socket option opt, for the
+// blocking I/O done while upgrading. set tracks whether a timeout is in place.
+func (c *conn) setSockTimeout(opt int, d int64, set *bool) error {
+	var timeout int64
+	if d != 0 {
+		if timeout = d - time.Now().UnixNano(); timeout <= 0 {
+			return os.ErrDeadlineExceeded
+		} else if timeout < int64(time.Microsecond) {
+			timeout = int64(time.Microsecond)
+		}
+	} else if !*set {
+		return nil
+	}
+	*set = d != 0
+	tv := syscall.NsecToTimeval(timeout)
+	return syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, opt, &tv)
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func deadlineExceeded(d int64) bool {
+	return d != 0 && time.Now().UnixNano() >= d
 }
 
 func (c *conn) ok() bool { return c != nil && c.fd > 0 && atomic.LoadInt32(&c.closed) == 0 }
@@ -661,7 +2082,7 @@ func (c *conn) ReadFrom(r io.Reader) (int64, error) {
 			return 0, nil
 		}
 	}
-	if syscallConn, ok := r.(syscall.Conn); ok {
+	if syscallConn, ok := r.(syscall.Conn); ok && c.Buffered() == 0 && atomic.LoadInt32(&c.transferring) == 0 {
 		if src, ok := r.(net.Conn); ok {
 			if remain <= 0 {
 				remain = bufferSize
@@ -670,6 +2091,7 @@ func (c *conn) ReadFrom(r io.Reader) (int64, error) {
 			var err error
 			n, err = splice.Splice(c, src, remain)
 			if err != splice.ErrNotHandled {
+				c.wrote(n)
 				return n, err
 			}
 		}
@@ -687,7 +2109,9 @@ func (c *conn) ReadFrom(r io.Reader) (int64, error) {
 				if remain <= 0 {
 					return 0, nil
 				}
-				return sendfile.SendFile(c, src, pos, remain)
+				n, err := sendfile.SendFile(c, src, pos, remain)
+				c.wrote(n)
+				return n, err
 			}
 		}
 	}
@@ -761,7 +2185,7 @@ type workers []*worker
 
 func (l workers) Len() int { return len(l) }
 func (l workers) Less(i, j int) bool {
-	return l[i].count < l[j].count
+	return atomic.LoadInt64(&l[i].count) < atomic.LoadInt64(&l[j].count)
 }
 func (l workers) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
 
@@ -4,6 +4,7 @@
 package netpoll
 
 import (
+	stdcontext "context"
 	"io"
 	"net"
 	"os"
@@ -77,11 +78,11 @@ func TestServerPoll(t *testing.T) {
 		server.Serve(l)
 	}()
 	time.Sleep(time.Millisecond * 10)
-	server.accept()
+	server.accept(server.listeners[0])
 	time.Sleep(time.Millisecond * 10)
 	server.Close()
 	time.Sleep(time.Millisecond * 10)
-	server.accept()
+	server.accept(server.listeners[0])
 	time.Sleep(time.Millisecond * 10)
 	wg.Wait()
 }
@@ -462,7 +463,7 @@ func TestWorker(t *testing.T) {
 	}()
 	time.Sleep(time.Millisecond * 10)
 	server.workers[0].serve(Event{Fd: 0})
-	server.workers[0].register(&conn{})
+	server.workers[0].register(&conn{fd: -1})
 	server.workers[0].Close()
 	server.Close()
 	wg.Wait()
@@ -1019,6 +1020,589 @@ func TestGenericReadFromRemain(t *testing.T) {
 	wg.Wait()
 }
 
+func TestDeadline(t *testing.T) {
+	expired := make(chan error, 1)
+	var handler = &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		conn.SetWriteDeadline(time.Now().Add(-time.Second))
+		if _, err := conn.Write([]byte("Hello World")); err != os.ErrDeadlineExceeded {
+			t.Error(err)
+		}
+		conn.SetWriteDeadline(time.Time{})
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 20))
+		if _, err := conn.Read(make([]byte, 64)); err != os.ErrDeadlineExceeded {
+			t.Error(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		conn := context.(net.Conn)
+		_, err := conn.Read(make([]byte, 64))
+		if err == os.ErrDeadlineExceeded {
+			expired <- err
+		}
+		return err
+	})
+	server := &Server{
+		Handler: handler,
+		NoAsync: false,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(l); err == nil {
+			t.Error()
+		}
+	}()
+	conn, _ := net.Dial(network, addr)
+	select {
+	case <-expired:
+	case <-time.After(time.Second * 5):
+		t.Error("read deadline not exceeded")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 64)); err != io.EOF {
+		t.Error(err)
+	}
+	conn.Close()
+	server.Close()
+	wg.Wait()
+}
+
+func TestIdleTimeout(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	server := &Server{
+		Handler:     handler,
+		NoAsync:     false,
+		IdleTimeout: time.Millisecond * 100,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(l); err == nil {
+			t.Error()
+		}
+	}()
+	conn, _ := net.Dial(network, addr)
+	msg := "Hello World"
+	buf := make([]byte, len(msg))
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 60)
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if n, err := io.ReadFull(conn, buf); err != nil {
+			t.Error(err)
+		} else if string(buf[:n]) != msg {
+			t.Error(string(buf[:n]))
+		}
+	}
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Error(err)
+	}
+	if d := time.Since(start); d < time.Millisecond*50 {
+		t.Error(d)
+	}
+	conn.Close()
+	server.Close()
+	wg.Wait()
+}
+
+func TestWriteBackpressure(t *testing.T) {
+	const size = 1 << 23
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	high := make(chan struct{}, 1)
+	low := make(chan struct{}, 1)
+	written := make(chan struct{}, 1)
+	var handler = &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		conn := context.(net.Conn)
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			return err
+		}
+		start := time.Now()
+		for i := 0; i < size; i += 4096 {
+			if n, err := conn.Write(payload[i : i+4096]); err != nil || n != 4096 {
+				t.Error(n, err)
+			}
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Error("write blocked", d)
+		}
+		if conn.(interface{ Buffered() int }).Buffered() == 0 {
+			t.Error("nothing buffered")
+		}
+		written <- struct{}{}
+		return EAGAIN
+	})
+	handler.SetHighWatermark(func(context Context) {
+		high <- struct{}{}
+	})
+	handler.SetLowWatermark(func(context Context) {
+		low <- struct{}{}
+	})
+	server := &Server{
+		Handler: handler,
+		NoAsync: false,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(l); err == nil {
+			t.Error()
+		}
+	}()
+	conn, _ := net.Dial(network, addr)
+	conn.Write([]byte("go"))
+	select {
+	case <-high:
+	case <-time.After(time.Second * 5):
+		t.Error("high watermark not reached")
+	}
+	// The peer does not read until the handler has written everything.
+	<-written
+	buf := make([]byte, size)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Error(err)
+	} else if string(buf) != string(payload) {
+		t.Error("payload mismatch")
+	}
+	select {
+	case <-low:
+	case <-time.After(time.Second * 5):
+		t.Error("low watermark not reached")
+	}
+	conn.Close()
+	server.Close()
+	wg.Wait()
+}
+
+func TestCloseLinger(t *testing.T) {
+	const size = 1 << 23
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	closed := make(chan struct{}, 1)
+	for _, trigger := range []Trigger{LEVEL, EDGE, ONESHOT} {
+		var handler = &ConnHandler{}
+		handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+			return conn, nil
+		})
+		handler.SetServe(func(context Context) error {
+			conn := context.(net.Conn)
+			if _, err := conn.Read(make([]byte, 64)); err != nil {
+				return err
+			}
+			conn.Write(payload)
+			if conn.(interface{ Buffered() int }).Buffered() == 0 {
+				t.Error("nothing buffered")
+			}
+			err := conn.Close()
+			closed <- struct{}{}
+			return err
+		})
+		server := &Server{
+			Handler: handler,
+			Trigger: trigger,
+			Linger:  time.Second * 10,
+		}
+		network := "tcp"
+		addr := ":9999"
+		l, _ := net.Listen(network, addr)
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Serve(l); err == nil {
+				t.Error()
+			}
+		}()
+		conn, _ := net.Dial(network, addr)
+		conn.Write([]byte("go"))
+		// The peer does not read until the connection is closed.
+		<-closed
+		// The data received once the connection is closed is discarded.
+		conn.Write([]byte("discarded"))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+		if buf, err := io.ReadAll(conn); err != nil {
+			t.Error(trigger, err)
+		} else if string(buf) != string(payload) {
+			t.Error(trigger, "payload mismatch", len(buf))
+		}
+		conn.Close()
+		server.Close()
+		wg.Wait()
+	}
+}
+
+func TestCloseFlushFailed(t *testing.T) {
+	payload := make([]byte, 1<<23)
+	for _, linger := range []time.Duration{0, time.Millisecond * 50} {
+		failed := make(chan error, 1)
+		var handler = &ConnHandler{}
+		handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+			return conn, nil
+		})
+		handler.SetServe(func(context Context) error {
+			conn := context.(net.Conn)
+			if _, err := conn.Read(make([]byte, 64)); err != nil {
+				return err
+			}
+			conn.Write(payload)
+			return conn.Close()
+		})
+		handler.SetFlushFailed(func(context Context, err error) {
+			failed <- err
+		})
+		server := &Server{
+			Handler: handler,
+			Linger:  linger,
+		}
+		network := "tcp"
+		addr := ":9999"
+		l, _ := net.Listen(network, addr)
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Serve(l); err == nil {
+				t.Error()
+			}
+		}()
+		conn, _ := net.Dial(network, addr)
+		// The peer does not read, the data left is dropped.
+		conn.Write([]byte("go"))
+		want := ErrUnflushed
+		if linger > 0 {
+			want = os.ErrDeadlineExceeded
+		}
+		select {
+		case err := <-failed:
+			if err != want {
+				t.Error(linger, err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Error(linger, "not notified")
+		}
+		conn.Close()
+		server.Close()
+		wg.Wait()
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	for _, trigger := range []Trigger{EDGE, ONESHOT} {
+		var dataHandler = &DataHandler{
+			Pool: bpool.NewBytePool(1024, 1024),
+			HandlerFunc: func(req []byte) (res []byte) {
+				res = req
+				return
+			},
+		}
+		// connHandler leaves data unread, the connection is rearmed.
+		var connHandler = &ConnHandler{}
+		connHandler.SetUpgrade(func(conn net.Conn) (Context, error) {
+			return conn, nil
+		})
+		connHandler.SetServe(func(context Context) error {
+			conn := context.(net.Conn)
+			buf := make([]byte, 16)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+			conn.Write(buf[:n])
+			return EAGAIN
+		})
+		for _, handler := range []Handler{dataHandler, connHandler} {
+			server := &Server{
+				Handler: handler,
+				Trigger: trigger,
+			}
+			network := "tcp"
+			addr := ":9999"
+			l, _ := net.Listen(network, addr)
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := server.Serve(l); err == nil {
+					t.Error()
+				}
+			}()
+			conn, _ := net.Dial(network, addr)
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			msg := strings.Repeat("Hello World", 1000)
+			buf := make([]byte, len(msg))
+			for i := 0; i < 3; i++ {
+				if _, err := conn.Write([]byte(msg)); err != nil {
+					t.Error(err)
+				}
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					t.Error(trigger, err)
+					break
+				} else if string(buf) != msg {
+					t.Error(trigger, "message mismatch")
+				}
+			}
+			conn.Close()
+			server.Close()
+			wg.Wait()
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			time.Sleep(time.Millisecond * 200)
+			return append([]byte{}, req...)
+		},
+	}
+	var lock sync.Mutex
+	var states = make(map[string][]ConnState)
+	server := &Server{
+		Handler: handler,
+		NoAsync: false,
+		ConnState: func(c net.Conn, state ConnState) {
+			lock.Lock()
+			states[c.RemoteAddr().String()] = append(states[c.RemoteAddr().String()], state)
+			lock.Unlock()
+		},
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(l); err == nil {
+			t.Error()
+		}
+	}()
+	idle, _ := net.Dial(network, addr)
+	active, _ := net.Dial(network, addr)
+	time.Sleep(time.Millisecond * 20)
+	msg := "Hello World"
+	if _, err := active.Write([]byte(msg)); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), time.Second*5)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+	buf := make([]byte, len(msg))
+	idle.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := idle.Read(buf); err != io.EOF {
+		t.Error(err)
+	}
+	active.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if n, err := io.ReadFull(active, buf); err != nil {
+		t.Error(err)
+	} else if string(buf[:n]) != msg {
+		t.Error(string(buf[:n]))
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if _, err := active.Read(buf); err != io.EOF {
+		t.Error(err)
+	}
+	if _, err := net.Dial(network, addr); err == nil {
+		t.Error("should be refused")
+	}
+	idle.Close()
+	active.Close()
+	wg.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	if s := states[idle.LocalAddr().String()]; len(s) != 4 || s[0] != StateNew || s[3] != StateClosed {
+		t.Error(s)
+	}
+	if s := states[active.LocalAddr().String()]; len(s) != 6 || s[3] != StateActive || s[4] != StateIdle || s[5] != StateClosed {
+		t.Error(s)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			time.Sleep(time.Millisecond * 300)
+			return append([]byte{}, req...)
+		},
+	}
+	server := &Server{
+		Handler: handler,
+		NoAsync: false,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.Serve(l)
+	}()
+	conn, _ := net.Dial(network, addr)
+	time.Sleep(time.Millisecond * 20)
+	conn.Write([]byte("Hello World"))
+	time.Sleep(time.Millisecond * 20)
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != stdcontext.DeadlineExceeded {
+		t.Error(err)
+	}
+	server.Close()
+	if err := server.Shutdown(stdcontext.Background()); err != nil {
+		t.Error(err)
+	}
+	conn.Close()
+	wg.Wait()
+}
+
+func TestShutdownNew(t *testing.T) {
+	handler := &ConnHandler{}
+	handler.SetUpgrade(func(conn net.Conn) (Context, error) {
+		// The client never sends its greeting.
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
+	handler.SetServe(func(context Context) error {
+		return nil
+	})
+	server := &Server{
+		Handler: handler,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.Serve(l)
+	}()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 20)
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), newConnGrace*2)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Error(err)
+		server.Close()
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Error(err)
+	}
+	wg.Wait()
+}
+
+func TestStats(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	server := &Server{
+		Handler: handler,
+		NoAsync: false,
+	}
+	network := "tcp"
+	addr := ":9999"
+	l, _ := net.Listen(network, addr)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.Serve(l)
+	}()
+	conn, _ := net.Dial(network, addr)
+	msg := "Hello World"
+	buf := make([]byte, len(msg))
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Error(err)
+		}
+	}
+	stats := server.Stats()
+	if stats.Accepted != 1 || stats.Active != 1 || stats.Closed != 0 {
+		t.Error(stats)
+	}
+	if stats.BytesRead != uint64(len(msg)*3) || stats.BytesWritten != uint64(len(msg)*3) {
+		t.Error(stats.BytesRead, stats.BytesWritten)
+	}
+	if stats.EAGAINs == 0 {
+		t.Error(stats.EAGAINs)
+	}
+	if len(stats.Workers) != int(server.unsharedWorkers+server.sharedWorkers) {
+		t.Error(len(stats.Workers))
+	}
+	var conns int64
+	var events uint64
+	for _, w := range stats.Workers {
+		conns += w.Conns
+		events += w.Events
+	}
+	if conns != 1 || events == 0 {
+		t.Error(conns, events)
+	}
+	conn.Close()
+	time.Sleep(time.Millisecond * 50)
+	if stats = server.Stats(); stats.Active != 0 || stats.Closed != 1 {
+		t.Error(stats)
+	}
+	server.Close()
+	wg.Wait()
+}
+
 func TestTopK(t *testing.T) {
 	{
 		l := list{&conn{score: 10}, &conn{score: 7}, &conn{score: 2}, &conn{score: 5}, &conn{score: 1}}
@@ -1056,3 +1640,93 @@ func TestMinHeap(t *testing.T) {
 		}
 	}
 }
+
+func TestReusePort(t *testing.T) {
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	unixAddr := t.TempDir() + "/netpoll.sock"
+	server := &Server{
+		Network:         "tcp",
+		Address:         "127.0.0.1:9997",
+		Addresses:       []Address{{Network: "unix", Address: unixAddr}},
+		Handler:         handler,
+		ReusePort:       4,
+		NoAsync:         true,
+		UnsharedWorkers: 4,
+		SharedWorkers:   6,
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	server.lock.Lock()
+	if len(server.listeners) != 5 || len(server.groups) != 4 {
+		t.Error(len(server.listeners), len(server.groups))
+	}
+	for i, g := range server.groups {
+		shared := len(g.workers) - int(g.unshared)
+		if g.unshared != 1 || i < 2 && shared != 2 || i >= 2 && shared != 1 {
+			t.Error(i, g.unshared, shared)
+		}
+	}
+	if server.listeners[4].group != server.groups[0] {
+		t.Error(server.listeners[4].group.index)
+	}
+	server.lock.Unlock()
+	echo := func(network, addr string) net.Conn {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := "Hello World"
+		buf := make([]byte, len(msg))
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Error(err)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Error(err)
+		} else if string(buf) != msg {
+			t.Error(string(buf))
+		}
+		return conn
+	}
+	var conns []net.Conn
+	for i := 0; i < 32; i++ {
+		conns = append(conns, echo("tcp", "127.0.0.1:9997"))
+	}
+	conns = append(conns, echo("unix", unixAddr))
+	groups := make(map[int]int64)
+	for _, w := range server.Stats().Workers {
+		groups[w.Group] += w.Conns
+	}
+	if len(groups) != 4 || groups[0]+groups[1]+groups[2]+groups[3] != 33 {
+		t.Error(groups)
+	}
+	var busy int
+	for _, n := range groups {
+		if n > 0 {
+			busy++
+		}
+	}
+	if busy < 2 {
+		t.Error(groups)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	server.Close()
+	wg.Wait()
+	if _, err := os.Stat(unixAddr); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
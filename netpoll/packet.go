@@ -0,0 +1,60 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"net"
+)
+
+// PacketHandler responds to the datagrams of a packet network.
+type PacketHandler interface {
+	// ServePacket serves a datagram received from addr, and returns the
+	// datagram to send back to addr, nil for none. The data is only valid
+	// until ServePacket returns, and res may be reused once it returns. The
+	// addr of an unnamed unixgram sender is nil.
+	ServePacket(addr net.Addr, data []byte) (res []byte)
+}
+
+// PacketHandlerFunc is an adapter to allow the use of ordinary functions
+// as PacketHandler.
+type PacketHandlerFunc func(addr net.Addr, data []byte) (res []byte)
+
+// ServePacket implements the PacketHandler ServePacket method.
+func (f PacketHandlerFunc) ServePacket(addr net.Addr, data []byte) (res []byte) {
+	return f(addr, data)
+}
+
+// ListenAndServePacket listens on the datagram network address and then
+// calls handler to serve the datagrams.
+//
+// The handler must be not nil.
+//
+// ListenAndServePacket always returns a non-nil error.
+func ListenAndServePacket(network, address string, handler PacketHandler) error {
+	server := &Server{Network: network, Address: address, PacketHandler: handler}
+	return server.ListenAndServe()
+}
+
+// isPacketNetwork reports whether the network is a datagram network.
+func isPacketNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// servePacketConn serves the datagrams of the net.PacketConn in blocking mode.
+func servePacketConn(conn net.PacketConn, handler PacketHandler) error {
+	buf := make([]byte, bufferSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if res := handler.ServePacket(addr, buf[:n]); res != nil {
+			conn.WriteTo(res, addr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"net"
+	"syscall"
+)
+
+const soReusePort = syscall.SO_REUSEPORT
+
+// batch holds the buffer receiving the datagrams one by one.
+type batch struct {
+	buf []byte
+}
+
+func newBatch() *batch {
+	return &batch{buf: make([]byte, bufferSize)}
+}
+
+// read serves the datagrams until the socket has no more.
+func (c *packetConn) read() error {
+	for {
+		n, sa, err := syscall.Recvfrom(c.fd, c.batch.buf, 0)
+		switch err {
+		case nil:
+		case syscall.EAGAIN:
+			return nil
+		case syscall.EINTR, syscall.ECONNREFUSED:
+			continue
+		default:
+			return err
+		}
+		c.stats.read.Add(uint64(n))
+		res := c.handler.ServePacket(packetAddr(sa), c.batch.buf[:n])
+		if res != nil && sa != nil {
+			if err := syscall.Sendto(c.fd, res, 0, sa); err == nil {
+				c.stats.written.Add(uint64(len(res)))
+			}
+		}
+	}
+}
+
+// packetAddr returns the net.Addr of the datagram socket address.
+func packetAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port, Zone: zone}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Net: "unixgram", Name: sa.Name}
+	}
+	return &net.UnixAddr{Net: "unixgram"}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// packetBatch is the max number of datagrams received or sent by a system call.
+const packetBatch = 16
+
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// batch receives the datagrams with recvmmsg and sends the responses with sendmmsg.
+type batch struct {
+	msgs  [packetBatch]mmsghdr
+	iovs  [packetBatch]syscall.Iovec
+	names [packetBatch]syscall.RawSockaddrAny
+	buf   []byte
+	out   [packetBatch]mmsghdr
+	outs  [packetBatch]syscall.Iovec
+	// res holds the copies of the responses until they are sent, the
+	// handler may reuse its buffer.
+	res []byte
+}
+
+func newBatch() *batch {
+	b := &batch{buf: make([]byte, packetBatch*bufferSize)}
+	for i := range b.msgs {
+		b.iovs[i].Base = &b.buf[i*bufferSize]
+		b.iovs[i].SetLen(bufferSize)
+		b.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].hdr.Iov = &b.iovs[i]
+		b.msgs[i].hdr.Iovlen = 1
+		b.out[i].hdr.Iov = &b.outs[i]
+		b.out[i].hdr.Iovlen = 1
+	}
+	return b
+}
+
+// read serves the datagrams until the socket has no more.
+func (c *packetConn) read() error {
+	b := c.batch
+	for {
+		for i := range b.msgs {
+			b.msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+		}
+		r, _, errno := syscall.Syscall6(syscall.SYS_RECVMMSG, uintptr(c.fd), uintptr(unsafe.Pointer(&b.msgs[0])), packetBatch, 0, 0, 0)
+		switch errno {
+		case 0:
+		case syscall.EAGAIN:
+			return nil
+		case syscall.EINTR, syscall.ECONNREFUSED:
+			continue
+		default:
+			return errno
+		}
+		n, out := int(r), 0
+		b.res = b.res[:0]
+		for i := 0; i < n; i++ {
+			msg := &b.msgs[i]
+			c.stats.read.Add(uint64(msg.len))
+			data := b.buf[i*bufferSize : i*bufferSize+int(msg.len)]
+			res := c.handler.ServePacket(rawToAddr(&b.names[i], msg.hdr.Namelen), data)
+			if res == nil {
+				continue
+			}
+			b.out[out].hdr.Name, b.out[out].hdr.Namelen = msg.hdr.Name, msg.hdr.Namelen
+			b.outs[out].SetLen(len(res))
+			b.res = append(b.res, res...)
+			out++
+		}
+		// The copies are addressed once res stops growing.
+		pos := 0
+		for i := 0; i < out; i++ {
+			b.outs[i].Base = nil
+			if size := int(b.outs[i].Len); size > 0 {
+				b.outs[i].Base = &b.res[pos]
+				pos += size
+			}
+		}
+		c.send(out)
+		if n < packetBatch {
+			return nil
+		}
+	}
+}
+
+// send sends the n responses, a response that fails is dropped.
+func (c *packetConn) send(n int) {
+	b := c.batch
+	for i := 0; i < n; {
+		r, _, errno := syscall.Syscall6(sysSendmmsg, uintptr(c.fd), uintptr(unsafe.Pointer(&b.out[i])), uintptr(n-i), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		} else if errno != 0 {
+			i++
+			continue
+		}
+		for j := i; j < i+int(r); j++ {
+			c.stats.written.Add(uint64(b.out[j].len))
+		}
+		i += int(r)
+	}
+	for i := 0; i < n; i++ {
+		b.outs[i].Base = nil
+	}
+}
+
+// rawToAddr returns the net.Addr of the raw socket address, nil for an
+// unnamed sender.
+func rawToAddr(rsa *syscall.RawSockaddrAny, namelen uint32) net.Addr {
+	if namelen == 0 {
+		return nil
+	}
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: int(port[0])<<8 | int(port[1])}
+	case syscall.AF_INET6:
+		sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		var zone string
+		if sa.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.Scope_id)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: int(port[0])<<8 | int(port[1]), Zone: zone}
+	case syscall.AF_UNIX:
+		sa := (*syscall.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		n := int(namelen) - int(unsafe.Offsetof(sa.Path))
+		if n > len(sa.Path) {
+			n = len(sa.Path)
+		}
+		name := make([]byte, 0, n)
+		for i := 0; i < n; i++ {
+			if sa.Path[i] == 0 && i > 0 {
+				break
+			}
+			name = append(name, byte(sa.Path[i]))
+		}
+		if len(name) > 0 && name[0] == 0 {
+			// An abstract socket address.
+			name[0] = '@'
+		}
+		return &net.UnixAddr{Net: "unixgram", Name: string(name)}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+// The syscall package misses the constants on this architecture.
+const (
+	soReusePort = 0xf
+	sysSendmmsg = 307
+)
@@ -0,0 +1,14 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux && !amd64 && !386 && !arm
+// +build linux,!amd64,!386,!arm
+
+package netpoll
+
+import "syscall"
+
+const (
+	soReusePort = syscall.SO_REUSEPORT
+	sysSendmmsg = syscall.SYS_SENDMMSG
+)
@@ -0,0 +1,240 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	stdcontext "context"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// packetConn is a datagram socket served on its own poll loop.
+type packetConn struct {
+	file    *os.File
+	fd      int
+	unix    bool
+	poll    *Poll
+	wakeup  [2]int
+	handler PacketHandler
+	stats   *stats
+	batch   *batch
+	lock    sync.Mutex
+	closed  bool
+}
+
+// listenAndServePacket serves the datagram network with a socket bound with
+// SO_REUSEPORT for each shared worker, so that the kernel fans the datagrams
+// out across the poll loops. A unixgram network is served with one socket.
+func (s *Server) listenAndServePacket() error {
+	if s.PacketHandler == nil {
+		return ErrPacketHandler
+	}
+	var lc net.ListenConfig
+	n := 1
+	if s.Network != "unixgram" {
+		if n = s.SharedWorkers; n <= 0 {
+			n = numCPU
+		}
+		lc.Control = reusePort
+	}
+	address := s.Address
+	conns := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := lc.ListenPacket(stdcontext.Background(), s.Network, address)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return err
+		}
+		// The other sockets bind the port of the first one.
+		address = conn.LocalAddr().String()
+		conns = append(conns, conn)
+	}
+	return s.servePacket(conns)
+}
+
+// ServePacket serves the datagrams of the net.PacketConn conn with the
+// PacketHandler. The datagrams of a *net.UDPConn or a *net.UnixConn are
+// served on a poll loop, batched with recvmmsg and sendmmsg on Linux.
+//
+// ServePacket always returns a non-nil error.
+// After Close the returned error is ErrServerClosed.
+func (s *Server) ServePacket(conn net.PacketConn) error {
+	if conn == nil {
+		return ErrListener
+	}
+	return s.servePacket([]net.PacketConn{conn})
+}
+
+func (s *Server) servePacket(conns []net.PacketConn) error {
+	packets, err := s.openPackets(conns)
+	if err == nil && packets == nil {
+		return s.servePacketConn(conns[0])
+	}
+	for _, conn := range conns {
+		// The sockets are served with the duplicated file descriptors.
+		conn.Close()
+	}
+	if err != nil {
+		for _, c := range packets {
+			c.close()
+		}
+		return err
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(packets))
+	for i, c := range packets {
+		wg.Add(1)
+		go func(i int, c *packetConn) {
+			defer wg.Done()
+			errs[i] = c.serve()
+		}(i, c)
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&s.stopped) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ErrServerClosed
+}
+
+// openPackets opens a packetConn for each socket, or returns nil when conn
+// is neither a *net.UDPConn nor a *net.UnixConn.
+func (s *Server) openPackets(conns []net.PacketConn) (packets []*packetConn, err error) {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return nil, ErrServerClosed
+	} else if s.PacketHandler == nil {
+		return nil, ErrPacketHandler
+	}
+	for _, conn := range conns {
+		var file *os.File
+		var unix bool
+		switch conn := conn.(type) {
+		case *net.UDPConn:
+			file, err = conn.File()
+		case *net.UnixConn:
+			file, err = conn.File()
+			unix = true
+		default:
+			if len(conns) > 1 {
+				return packets, ErrListener
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		c := &packetConn{
+			file:    file,
+			fd:      int(file.Fd()),
+			unix:    unix,
+			wakeup:  [2]int{-1, -1},
+			handler: s.PacketHandler,
+			stats:   &s.stats,
+		}
+		packets = append(packets, c)
+		if err = syscall.SetNonblock(c.fd, true); err != nil {
+			return packets, err
+		}
+		if err = c.open(s); err != nil {
+			return packets, err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		return packets, ErrServerClosed
+	}
+	s.packets = append(s.packets, packets...)
+	return packets, nil
+}
+
+// servePacketConn serves another net.PacketConn in blocking mode.
+func (s *Server) servePacketConn(conn net.PacketConn) error {
+	s.lock.Lock()
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		s.lock.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.packetConn = conn
+	s.lock.Unlock()
+	err := servePacketConn(conn, s.PacketHandler)
+	if atomic.LoadInt32(&s.stopped) != 0 {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// open creates the poll of the socket and its wakeup pipe.
+func (c *packetConn) open(s *Server) (err error) {
+	if c.poll, err = s.create(); err != nil {
+		return err
+	}
+	if err = syscall.Pipe(c.wakeup[:]); err != nil {
+		return err
+	}
+	for _, fd := range c.wakeup {
+		syscall.CloseOnExec(fd)
+	}
+	if err = c.poll.Register(c.wakeup[0]); err != nil {
+		return err
+	}
+	c.batch = newBatch()
+	return c.poll.Register(c.fd)
+}
+
+// serve serves the datagrams until the wakeup pipe is written.
+func (c *packetConn) serve() error {
+	defer c.close()
+	events := make([]Event, 2)
+	for {
+		n, err := c.poll.Wait(events)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events[:n] {
+			if ev.Fd == c.wakeup[0] {
+				return nil
+			}
+			if err := c.read(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// wake stops the poll loop.
+func (c *packetConn) wake() {
+	c.lock.Lock()
+	if !c.closed {
+		syscall.Write(c.wakeup[1], []byte{0})
+	}
+	c.lock.Unlock()
+}
+
+func (c *packetConn) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	if c.poll != nil {
+		c.poll.Unregister(c.fd)
+		c.poll.Close()
+	}
+	if c.wakeup[0] >= 0 {
+		syscall.Close(c.wakeup[0])
+		syscall.Close(c.wakeup[1])
+	}
+	c.file.Close()
+}
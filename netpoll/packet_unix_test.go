@@ -0,0 +1,240 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var echoPacket = PacketHandlerFunc(func(addr net.Addr, data []byte) []byte {
+	return append([]byte{}, data...)
+})
+
+func testPacketEcho(t *testing.T, conn net.Conn, n int) {
+	buf := make([]byte, 64)
+	for i := 0; i < n; i++ {
+		msg := fmt.Sprintf("datagram %d", i)
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if n, err := conn.Read(buf); err != nil {
+			t.Error(err)
+			return
+		} else if string(buf[:n]) != msg {
+			t.Error(string(buf[:n]))
+		}
+	}
+}
+
+func TestListenAndServePacket(t *testing.T) {
+	server := &Server{
+		Network:       "udp",
+		Address:       "127.0.0.1:9995",
+		PacketHandler: echoPacket,
+		SharedWorkers: 4,
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	// The packet connections are served once they are all listening.
+	packets := 0
+	for when := time.Now().Add(time.Second); packets < 4 && time.Now().Before(when); {
+		time.Sleep(time.Millisecond)
+		server.lock.Lock()
+		packets = len(server.packets)
+		server.lock.Unlock()
+	}
+	if packets != 4 {
+		t.Error(packets)
+	}
+	clients := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			conn, err := net.Dial("udp", "127.0.0.1:9995")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			testPacketEcho(t, conn, 100)
+		}()
+	}
+	clients.Wait()
+	// The bytes written are counted once sendmmsg returns on the server.
+	stats := server.Stats()
+	for when := time.Now().Add(time.Second); stats.BytesRead != stats.BytesWritten && time.Now().Before(when); {
+		time.Sleep(time.Millisecond)
+		stats = server.Stats()
+	}
+	if stats.BytesRead == 0 || stats.BytesRead != stats.BytesWritten {
+		t.Error(stats.BytesRead, stats.BytesWritten)
+	}
+	server.Close()
+	wg.Wait()
+	if err := server.ListenAndServe(); err != ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestServePacketUnixgram(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "server.sock")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{PacketHandler: echoPacket}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ServePacket(conn); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	client, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Net: "unixgram", Name: filepath.Join(dir, "client.sock")},
+		&net.UnixAddr{Net: "unixgram", Name: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketEcho(t, client, 10)
+	client.Close()
+	server.Close()
+	wg.Wait()
+	os.Remove(addr)
+}
+
+func TestServePacketBatch(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "server.sock")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The handler reuses its response buffer, the datagrams left queued by
+	// the first one are served in a batch.
+	var res []byte
+	unnamed := make(chan bool, 16)
+	handler := PacketHandlerFunc(func(addr net.Addr, data []byte) []byte {
+		if len(res) == 0 {
+			time.Sleep(time.Millisecond * 20)
+		}
+		if addr == nil {
+			unnamed <- true
+			return nil
+		}
+		res = append(res[:0], data...)
+		return res
+	})
+	server := &Server{PacketHandler: handler}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ServePacket(conn); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	var clients []*net.UnixConn
+	for i := 0; i < 8; i++ {
+		client, err := net.DialUnix("unixgram",
+			&net.UnixAddr{Net: "unixgram", Name: filepath.Join(dir, fmt.Sprintf("client%d.sock", i))},
+			&net.UnixAddr{Net: "unixgram", Name: addr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	for i, client := range clients {
+		client.Write([]byte(fmt.Sprintf("datagram %d", i)))
+	}
+	buf := make([]byte, 64)
+	for i, client := range clients {
+		client.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if n, err := client.Read(buf); err != nil {
+			t.Error(err)
+		} else if msg := fmt.Sprintf("datagram %d", i); string(buf[:n]) != msg {
+			t.Error(msg, string(buf[:n]))
+		}
+	}
+	// An unbound socket sends from an unnamed address.
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Sendto(fd, []byte("unnamed"), 0, &syscall.SockaddrUnix{Name: addr}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-unnamed:
+	case <-time.After(time.Second * 5):
+		t.Error("not served with a nil address")
+	}
+	server.Close()
+	wg.Wait()
+}
+
+type packetConnWrapper struct {
+	net.PacketConn
+}
+
+func TestServePacketConn(t *testing.T) {
+	server := &Server{}
+	if err := server.ServePacket(nil); err != ErrListener {
+		t.Error(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:9995")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ServePacket(conn); err != ErrPacketHandler {
+		t.Error(err)
+	}
+	if err := ListenAndServePacket("udp", "127.0.0.1:9995", nil); err != ErrPacketHandler {
+		t.Error(err)
+	}
+	conn, err = net.ListenPacket("udp", "127.0.0.1:9995")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.PacketHandler = echoPacket
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ServePacket(&packetConnWrapper{conn}); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	client, err := net.Dial("udp", "127.0.0.1:9995")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketEcho(t, client, 10)
+	client.Close()
+	server.Close()
+	wg.Wait()
+}
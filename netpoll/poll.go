@@ -4,6 +4,15 @@
 // Package netpoll implements a network poller based on epoll/kqueue.
 package netpoll
 
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrIOUring is the error returned by CreateIOUring when io_uring is not
+// supported.
+var ErrIOUring = errors.New("io_uring not supported")
+
 // Mode represents the read/write mode.
 type Mode int
 
@@ -21,3 +30,48 @@ type Event struct {
 	// Mode represents the event mode.
 	Mode Mode
 }
+
+// Trigger represents how the poll reports the readiness of file descriptors.
+type Trigger int
+
+const (
+	// LEVEL reports a file descriptor as long as it is ready.
+	LEVEL Trigger = iota
+	// EDGE reports a file descriptor when it becomes ready, so the reader
+	// must drain it until EAGAIN.
+	EDGE
+	// ONESHOT reports a file descriptor once, then disables it until
+	// it is rearmed.
+	ONESHOT
+)
+
+// The states of an ioOp.
+const (
+	opIdle int32 = iota
+	opBusy
+	opDone
+)
+
+// ioOp is an io_uring accept, recv or send request. The kernel uses its
+// buffer while it is busy. Once it completes, Wait sets its result and marks
+// it done, and the owner takes the result and makes it idle again.
+type ioOp struct {
+	buf   []byte
+	state int32
+	res   int32
+	// addrlen is the length of the peer address an accept stores in buf.
+	addrlen uint32
+	// wake is called when the request completes after its file descriptor
+	// was unregistered, as no event is reported then.
+	wake func()
+}
+
+// take returns the result of the request once it is done, and makes it idle.
+func (op *ioOp) take() (res int32, done bool) {
+	if atomic.LoadInt32(&op.state) != opDone {
+		return 0, false
+	}
+	res = op.res
+	atomic.StoreInt32(&op.state, opIdle)
+	return res, true
+}
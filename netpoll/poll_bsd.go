@@ -19,12 +19,16 @@ var Tag = "kqueue"
 // ErrTimeout is the error returned by SetTimeout when time.Duration d < time.Millisecond.
 var ErrTimeout = errors.New("non-positive interval for SetTimeout")
 
+// ErrTrigger is the error returned by SetTrigger when the trigger is unknown.
+var ErrTrigger = errors.New("unknown Trigger for SetTrigger")
+
 // Poll represents the poll that supports non-blocking I/O on file descriptors with polling.
 type Poll struct {
 	fd      int
 	events  []syscall.Kevent_t
 	pool    *sync.Pool
 	timeout *syscall.Timespec
+	trigger Trigger
 }
 
 // Create creates a new poll.
@@ -43,6 +47,11 @@ func Create() (*Poll, error) {
 	}, nil
 }
 
+// CreateIOUring returns ErrIOUring, io_uring is only supported on Linux.
+func CreateIOUring() (*Poll, error) {
+	return nil, ErrIOUring
+}
+
 // SetTimeout sets the wait timeout.
 func (p *Poll) SetTimeout(d time.Duration) (err error) {
 	if d < time.Millisecond {
@@ -53,24 +62,60 @@ func (p *Poll) SetTimeout(d time.Duration) (err error) {
 	return nil
 }
 
+// SetTrigger sets how the readiness of the file descriptors registered
+// afterwards is reported.
+func (p *Poll) SetTrigger(trigger Trigger) (err error) {
+	switch trigger {
+	case LEVEL, EDGE, ONESHOT:
+		p.trigger = trigger
+		return nil
+	}
+	return ErrTrigger
+}
+
+// add sets the flags adding the event with the trigger of the poll.
+func (p *Poll) add(change *syscall.Kevent_t) {
+	change.Flags = syscall.EV_ADD
+	switch p.trigger {
+	case EDGE:
+		change.Flags |= syscall.EV_CLEAR
+	case ONESHOT:
+		change.Flags |= syscall.EV_ONESHOT
+	}
+}
+
 // Register registers a file descriptor.
 func (p *Poll) Register(fd int) (err error) {
 	changes := p.pool.Get().([]syscall.Kevent_t)
-	changes[0].Ident, changes[0].Flags = uint64(fd), syscall.EV_ADD
+	changes[0].Ident = uint64(fd)
+	p.add(&changes[0])
 	_, err = syscall.Kevent(p.fd, changes[:1], nil, nil)
 	p.pool.Put(changes)
 	return
 }
 
-// Write adds a write event.
+// Write adds a write event. With ONESHOT it rearms the read event as well.
 func (p *Poll) Write(fd int) (err error) {
 	changes := p.pool.Get().([]syscall.Kevent_t)
-	changes[1].Ident, changes[1].Flags = uint64(fd), syscall.EV_ADD
-	_, err = syscall.Kevent(p.fd, changes[1:], nil, nil)
+	changes[0].Ident, changes[1].Ident = uint64(fd), uint64(fd)
+	p.add(&changes[0])
+	p.add(&changes[1])
+	if p.trigger == ONESHOT {
+		_, err = syscall.Kevent(p.fd, changes, nil, nil)
+	} else {
+		_, err = syscall.Kevent(p.fd, changes[1:], nil, nil)
+	}
 	p.pool.Put(changes)
 	return
 }
 
+// Rearm rearms the read event of a file descriptor after it was reported
+// with ONESHOT. With EDGE it reports the file descriptor again if it is still
+// readable.
+func (p *Poll) Rearm(fd int) (err error) {
+	return p.Register(fd)
+}
+
 // Unregister unregisters a file descriptor.
 func (p *Poll) Unregister(fd int) (err error) {
 	changes := p.pool.Get().([]syscall.Kevent_t)
@@ -81,6 +126,31 @@ func (p *Poll) Unregister(fd int) (err error) {
 	return
 }
 
+// accept returns ErrIOUring, io_uring is only supported on Linux.
+func (p *Poll) accept(fd int, op *ioOp) error {
+	return ErrIOUring
+}
+
+// recv returns ErrIOUring, io_uring is only supported on Linux.
+func (p *Poll) recv(fd int, op *ioOp) error {
+	return ErrIOUring
+}
+
+// send returns ErrIOUring, io_uring is only supported on Linux.
+func (p *Poll) send(fd int, op *ioOp) error {
+	return ErrIOUring
+}
+
+// notify returns ErrIOUring, io_uring is only supported on Linux.
+func (p *Poll) notify(fd int) error {
+	return ErrIOUring
+}
+
+// inflight returns 0, io_uring is only supported on Linux.
+func (p *Poll) inflight() int {
+	return 0
+}
+
 // Wait waits events.
 func (p *Poll) Wait(events []Event) (n int, err error) {
 	if cap(p.events) >= len(events) {
@@ -93,7 +163,7 @@ func (p *Poll) Wait(events []Event) (n int, err error) {
 		if err != syscall.EINTR {
 			return 0, err
 		}
-		err = nil
+		n, err = 0, nil
 	}
 	for i := 0; i < n; i++ {
 		ev := p.events[i]
@@ -103,6 +173,9 @@ func (p *Poll) Wait(events []Event) (n int, err error) {
 			events[i].Mode = READ
 		case syscall.EVFILT_WRITE:
 			events[i].Mode = WRITE
+			if p.trigger == ONESHOT {
+				break
+			}
 			changes := p.pool.Get().([]syscall.Kevent_t)
 			changes[1].Ident, changes[1].Flags = ev.Ident, syscall.EV_DELETE
 			syscall.Kevent(p.fd, changes[1:], nil, nil)
@@ -117,3 +190,8 @@ func (p *Poll) Wait(events []Event) (n int, err error) {
 func (p *Poll) Close() error {
 	return syscall.Close(p.fd)
 }
+
+// sockaddr returns nil, io_uring is only supported on Linux.
+func (op *ioOp) sockaddr() syscall.Sockaddr {
+	return nil
+}
@@ -19,12 +19,18 @@ var Tag = "epoll"
 // ErrTimeout is the error returned by SetTimeout when time.Duration d < time.Millisecond.
 var ErrTimeout = errors.New("non-positive interval for SetTimeout")
 
+// ErrTrigger is the error returned by SetTrigger when the trigger is unknown.
+var ErrTrigger = errors.New("unknown Trigger for SetTrigger")
+
 // Poll represents the poll that supports non-blocking I/O on file descriptors with polling.
 type Poll struct {
 	fd      int
 	events  []syscall.EpollEvent
 	pool    *sync.Pool
 	timeout int
+	trigger Trigger
+	flags   uint32
+	ring    *uring
 }
 
 // Create creates a new poll.
@@ -52,19 +58,61 @@ func (p *Poll) SetTimeout(d time.Duration) (err error) {
 	return nil
 }
 
+// SetTrigger sets how the readiness of the file descriptors registered
+// afterwards is reported.
+func (p *Poll) SetTrigger(trigger Trigger) (err error) {
+	switch trigger {
+	case LEVEL:
+		p.flags = 0
+	case EDGE:
+		p.flags = syscall.EPOLLET & 0xffffffff
+	case ONESHOT:
+		p.flags = syscall.EPOLLONESHOT
+	default:
+		return ErrTrigger
+	}
+	p.trigger = trigger
+	if p.ring != nil {
+		p.ring.lock.Lock()
+		p.ring.trigger = trigger
+		p.ring.lock.Unlock()
+	}
+	return nil
+}
+
 // Register registers a file descriptor.
 func (p *Poll) Register(fd int) (err error) {
+	if p.ring != nil {
+		return p.ring.register(fd)
+	}
 	event := p.pool.Get().(syscall.EpollEvent)
-	event.Fd, event.Events = int32(fd), syscall.EPOLLIN
+	event.Fd, event.Events = int32(fd), syscall.EPOLLIN|p.flags
 	err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &event)
 	p.pool.Put(event)
 	return
 }
 
-// Write adds a write event.
+// Write adds a write event. With ONESHOT it rearms the read event as well.
 func (p *Poll) Write(fd int) (err error) {
+	if p.ring != nil {
+		return p.ring.write(fd)
+	}
 	event := p.pool.Get().(syscall.EpollEvent)
-	event.Fd, event.Events = int32(fd), syscall.EPOLLIN|syscall.EPOLLOUT
+	event.Fd, event.Events = int32(fd), syscall.EPOLLIN|syscall.EPOLLOUT|p.flags
+	err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, &event)
+	p.pool.Put(event)
+	return
+}
+
+// Rearm rearms the read event of a file descriptor after it was reported
+// with ONESHOT. With EDGE it reports the file descriptor again if it is still
+// readable.
+func (p *Poll) Rearm(fd int) (err error) {
+	if p.ring != nil {
+		return p.ring.rearm(fd)
+	}
+	event := p.pool.Get().(syscall.EpollEvent)
+	event.Fd, event.Events = int32(fd), syscall.EPOLLIN|p.flags
 	err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, &event)
 	p.pool.Put(event)
 	return
@@ -72,6 +120,9 @@ func (p *Poll) Write(fd int) (err error) {
 
 // Unregister unregisters a file descriptor.
 func (p *Poll) Unregister(fd int) (err error) {
+	if p.ring != nil {
+		return p.ring.unregister(fd)
+	}
 	event := p.pool.Get().(syscall.EpollEvent)
 	event.Fd, event.Events = int32(fd), syscall.EPOLLIN|syscall.EPOLLOUT
 	err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, &event)
@@ -79,8 +130,54 @@ func (p *Poll) Unregister(fd int) (err error) {
 	return
 }
 
+// accept queues an accept of the listening socket fd with io_uring, which
+// completes with a READ event.
+func (p *Poll) accept(fd int, op *ioOp) error {
+	if p.ring == nil {
+		return ErrIOUring
+	}
+	return p.ring.io(uringAccept, fd, op)
+}
+
+// recv queues a read of fd into the buffer of op with io_uring, which
+// completes with a READ event.
+func (p *Poll) recv(fd int, op *ioOp) error {
+	if p.ring == nil {
+		return ErrIOUring
+	}
+	return p.ring.io(uringRecv, fd, op)
+}
+
+// send queues a write of the buffer of op to fd with io_uring, which
+// completes with a WRITE event.
+func (p *Poll) send(fd int, op *ioOp) error {
+	if p.ring == nil {
+		return ErrIOUring
+	}
+	return p.ring.io(uringSend, fd, op)
+}
+
+// notify reports a READ and WRITE event of fd with io_uring.
+func (p *Poll) notify(fd int) error {
+	if p.ring == nil {
+		return ErrIOUring
+	}
+	return p.ring.notify(fd)
+}
+
+// inflight returns the number of io_uring I/O requests not completed yet.
+func (p *Poll) inflight() int {
+	if p.ring == nil {
+		return 0
+	}
+	return p.ring.inflight()
+}
+
 // Wait waits events.
 func (p *Poll) Wait(events []Event) (n int, err error) {
+	if p.ring != nil {
+		return p.ring.wait(events, p.timeout)
+	}
 	if cap(p.events) >= len(events) {
 		p.events = p.events[:len(events)]
 	} else {
@@ -91,19 +188,23 @@ func (p *Poll) Wait(events []Event) (n int, err error) {
 		if err != syscall.EINTR {
 			return 0, err
 		}
-		err = nil
+		n, err = 0, nil
 	}
 	for i := 0; i < n; i++ {
 		ev := p.events[i]
 		events[i].Fd = int(ev.Fd)
-		if ev.Events&syscall.EPOLLIN != 0 {
+		events[i].Mode = 0
+		if ev.Events&(syscall.EPOLLIN|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
 			events[i].Mode = READ
-		} else if ev.Events&syscall.EPOLLOUT != 0 {
-			events[i].Mode = WRITE
-			event := p.pool.Get().(syscall.EpollEvent)
-			event.Fd, event.Events = ev.Fd, syscall.EPOLLIN
-			syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, int(ev.Fd), &event)
-			p.pool.Put(event)
+		}
+		if ev.Events&syscall.EPOLLOUT != 0 {
+			events[i].Mode |= WRITE
+			if p.trigger != ONESHOT {
+				event := p.pool.Get().(syscall.EpollEvent)
+				event.Fd, event.Events = ev.Fd, syscall.EPOLLIN|p.flags
+				syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, int(ev.Fd), &event)
+				p.pool.Put(event)
+			}
 		}
 	}
 	return
@@ -112,5 +213,8 @@ func (p *Poll) Wait(events []Event) (n int, err error) {
 // Close closes the poll fd. The underlying file descriptor is closed by the
 // destroy method when there are no remaining references.
 func (p *Poll) Close() error {
+	if p.ring != nil {
+		return p.ring.close()
+	}
 	return syscall.Close(p.fd)
 }
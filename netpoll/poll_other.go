@@ -23,11 +23,21 @@ func Create() (*Poll, error) {
 	return nil, errors.New("system not supported")
 }
 
+// CreateIOUring returns ErrIOUring, io_uring is only supported on Linux.
+func CreateIOUring() (*Poll, error) {
+	return nil, ErrIOUring
+}
+
 // SetTimeout sets the wait timeout.
 func (p *Poll) SetTimeout(d time.Duration) (err error) {
 	return nil
 }
 
+// SetTrigger sets how the readiness of the file descriptors is reported.
+func (p *Poll) SetTrigger(trigger Trigger) (err error) {
+	return nil
+}
+
 // Register registers a file descriptor.
 func (p *Poll) Register(fd int) (err error) {
 	return
@@ -38,6 +48,11 @@ func (p *Poll) Write(fd int) (err error) {
 	return
 }
 
+// Rearm rearms the read event of a file descriptor.
+func (p *Poll) Rearm(fd int) (err error) {
+	return
+}
+
 // Unregister unregisters a file descriptor.
 func (p *Poll) Unregister(fd int) (err error) {
 	return
@@ -73,3 +73,55 @@ func TestPoll(t *testing.T) {
 	l.Close()
 	wg.Wait()
 }
+
+func TestPollTrigger(t *testing.T) {
+	for _, create := range []func() (*Poll, error){Create, CreateIOUring} {
+		testPollTrigger(t, create)
+	}
+}
+
+func testPollTrigger(t *testing.T, create func() (*Poll, error)) {
+	for _, trigger := range []Trigger{EDGE, ONESHOT} {
+		p, err := create()
+		if err == ErrIOUring {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.SetTrigger(Trigger(-1)); err != ErrTrigger {
+			t.Error(err)
+		}
+		if err := p.SetTrigger(trigger); err != nil {
+			t.Error(err)
+		}
+		p.SetTimeout(time.Millisecond * 10)
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Register(fds[0])
+		syscall.Write(fds[1], []byte("hello"))
+		events := make([]Event, 128)
+		if n, err := p.Wait(events); err != nil {
+			t.Error(err)
+		} else if n != 1 || events[0].Fd != fds[0] || events[0].Mode != READ {
+			t.Error(trigger, n, events[0])
+		}
+		// The data is left unread, it is not reported again.
+		if n, err := p.Wait(events); err != nil {
+			t.Error(err)
+		} else if n != 0 {
+			t.Error(trigger, n)
+		}
+		p.Rearm(fds[0])
+		if n, err := p.Wait(events); err != nil {
+			t.Error(err)
+		} else if n != 1 || events[0].Mode != READ {
+			t.Error(trigger, n, events[0])
+		}
+		p.Unregister(fds[0])
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		p.Close()
+	}
+}
@@ -0,0 +1,696 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	ioringOffSQRing = 0
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpNop         = 0
+	ioringOpPollAdd     = 6
+	ioringOpPollRemove  = 7
+	ioringOpAccept      = 13
+	ioringOpAsyncCancel = 14
+	ioringOpSend        = 26
+	ioringOpRecv        = 27
+	ioringPollAddMulti  = 1 << 0
+
+	ioringEnterGetEvents = 1 << 0
+	ioringEnterExtArg    = 1 << 3
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatExtArg     = 1 << 8
+	// ioringFeatRsrcTags came with multishot polls in Linux 5.13.
+	ioringFeatRsrcTags = 1 << 10
+
+	ioringCQEFMore = 1 << 1
+
+	uringEntries = 1024
+)
+
+// The kinds of requests, stored in the user data of the requests along with
+// the file descriptor and its registration generation, or the id of an I/O
+// request.
+const (
+	uringRead = iota
+	uringWrite
+	uringProbe
+	uringRemove
+	uringRecv
+	uringSend
+	uringAccept
+	uringNop
+)
+
+// uringGenMask masks the registration generations and the I/O request ids.
+const uringGenMask = 1<<29 - 1
+
+type uringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringGeteventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	minWait   uint32
+	ts        uint64
+}
+
+// uringFd is the state of a registered file descriptor.
+type uringFd struct {
+	gen   uint32
+	read  bool
+	write bool
+	probe bool
+	// reads is the number of recv and accept requests in flight, which
+	// report the events of the read poll, and reported the wait that
+	// reported the last one completed.
+	reads    int
+	reported uint32
+	// ops are the user data of its I/O requests in flight.
+	ops []uint64
+}
+
+// uringOp is an I/O request in flight.
+type uringOp struct {
+	op  *ioOp
+	fd  int
+	gen uint32
+}
+
+// uring polls file descriptors with io_uring poll requests.
+//
+// The requests are queued in the submission ring and submitted in a batch
+// by the next wait, unless a wait is blocked, in which case they are
+// submitted right away. Level triggered polls are oneshot requests that are
+// submitted again by the next wait, after the events have been served.
+//
+// The accept, recv and send requests complete with a READ, READ and WRITE
+// event of their file descriptor, their results are set on their ioOp.
+type uring struct {
+	fd      int
+	ring    []byte
+	sqeMem  []byte
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqSize  uint32
+	sqArray []uint32
+	sqes    []uringSQE
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    []uringCQE
+
+	lock    sync.Mutex
+	trigger Trigger
+	waiting bool
+	closed  bool
+	gen     uint32
+	fds     map[int]*uringFd
+	rearms  []int
+	id      uint32
+	ops     map[uint64]uringOp
+	waits   uint32
+	// woken are the I/O requests completed after their file descriptor was
+	// unregistered, woken once the lock is released.
+	woken []*ioOp
+	// ts and arg are passed to the kernel by address, they live on the heap.
+	ts  syscall.Timespec
+	arg uringGeteventsArg
+}
+
+var nativeBigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+// CreateIOUring creates a new poll backed by io_uring. It returns ErrIOUring
+// when the kernel does not support it.
+func CreateIOUring() (*Poll, error) {
+	r, err := newURing(uringEntries)
+	if err != nil {
+		return nil, err
+	}
+	return &Poll{fd: r.fd, ring: r, timeout: 1000}, nil
+}
+
+func newURing(entries uint32) (*uring, error) {
+	var params uringParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		switch errno {
+		case syscall.ENOSYS, syscall.EPERM, syscall.EINVAL:
+			return nil, ErrIOUring
+		}
+		return nil, errno
+	}
+	r := &uring{fd: int(fd), fds: make(map[int]*uringFd), ops: make(map[uint64]uringOp)}
+	const features = ioringFeatSingleMmap | ioringFeatExtArg | ioringFeatRsrcTags
+	if params.features&features != features {
+		syscall.Close(r.fd)
+		return nil, ErrIOUring
+	}
+	size := params.sqOff.array + params.sqEntries*4
+	if cqSize := params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})); cqSize > size {
+		size = cqSize
+	}
+	var err error
+	if r.ring, err = syscall.Mmap(r.fd, ioringOffSQRing, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		syscall.Close(r.fd)
+		return nil, err
+	}
+	sqeSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqeMem, err = syscall.Mmap(r.fd, ioringOffSQEs, sqeSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		syscall.Munmap(r.ring)
+		syscall.Close(r.fd)
+		return nil, err
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.ring[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.ring[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.ring[params.sqOff.ringMask]))
+	r.sqSize = *(*uint32)(unsafe.Pointer(&r.ring[params.sqOff.ringEntries]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.ring[params.sqOff.array])), params.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.ring[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.ring[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.ring[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.ring[params.cqOff.cqes])), params.cqEntries)
+	return r, nil
+}
+
+func uringData(fd int, kind int, gen uint32) uint64 {
+	return uint64(uint32(fd)) | uint64(kind)<<32 | uint64(gen)<<35
+}
+
+func pollEvents(events uint32) uint32 {
+	if nativeBigEndian {
+		return events<<16 | events>>16
+	}
+	return events
+}
+
+// queue adds a request to the submission ring, submitting the queued
+// requests first when it is full. It returns EBUSY when the ring is still
+// full.
+func (r *uring) queue(sqe uringSQE) error {
+	tail := atomic.LoadUint32(r.sqTail)
+	if tail-atomic.LoadUint32(r.sqHead) >= r.sqSize {
+		if err := r.submit(0, 0); err != nil {
+			return err
+		}
+		// The kernel may consume none of the requests while the completion
+		// ring overflows, their slots must not be overwritten.
+		if tail-atomic.LoadUint32(r.sqHead) >= r.sqSize {
+			return syscall.EBUSY
+		}
+	}
+	index := tail & r.sqMask
+	r.sqes[index] = sqe
+	r.sqArray[index] = index
+	atomic.StoreUint32(r.sqTail, tail+1)
+	return nil
+}
+
+// submit submits the queued requests and waits for minComplete completions.
+func (r *uring) submit(minComplete uint32, flags uint32) error {
+	toSubmit := atomic.LoadUint32(r.sqTail) - atomic.LoadUint32(r.sqHead)
+	var arg, size uintptr
+	if flags&ioringEnterExtArg != 0 {
+		arg, size = uintptr(unsafe.Pointer(&r.arg)), unsafe.Sizeof(r.arg)
+	}
+	for {
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), arg, size)
+		switch errno {
+		case 0, syscall.ETIME, syscall.EBUSY, syscall.EAGAIN:
+			return nil
+		case syscall.EINTR:
+			if minComplete > 0 {
+				return nil
+			}
+		default:
+			return errno
+		}
+	}
+}
+
+// flush submits the queued requests when a wait is blocked, otherwise the
+// next wait submits them.
+func (r *uring) flush() error {
+	if r.waiting {
+		return r.submit(0, 0)
+	}
+	return nil
+}
+
+func (r *uring) armRead(fd int, st *uringFd) error {
+	var flags uint32
+	if r.trigger == EDGE {
+		flags = ioringPollAddMulti
+	}
+	st.read = true
+	return r.poll(fd, syscall.EPOLLIN, flags, uringData(fd, uringRead, st.gen))
+}
+
+func (r *uring) poll(fd int, events, flags uint32, userData uint64) error {
+	return r.queue(uringSQE{opcode: ioringOpPollAdd, fd: int32(fd), len: flags, opFlags: pollEvents(events), userData: userData})
+}
+
+func (r *uring) remove(userData uint64) error {
+	return r.queue(uringSQE{opcode: ioringOpPollRemove, fd: -1, addr: userData, userData: uringData(0, uringRemove, 0)})
+}
+
+func (r *uring) cancel(userData uint64) error {
+	return r.queue(uringSQE{opcode: ioringOpAsyncCancel, fd: -1, addr: userData, userData: uringData(0, uringRemove, 0)})
+}
+
+func (r *uring) removeAll(fd int, st *uringFd) {
+	if st.read {
+		r.remove(uringData(fd, uringRead, st.gen))
+	}
+	if st.write {
+		r.remove(uringData(fd, uringWrite, st.gen))
+	}
+	if st.probe {
+		r.remove(uringData(fd, uringProbe, st.gen))
+	}
+	for _, userData := range st.ops {
+		r.cancel(userData)
+	}
+}
+
+func (r *uring) register(fd int) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	if st, ok := r.fds[fd]; ok {
+		r.removeAll(fd, st)
+	}
+	r.gen = (r.gen + 1) & uringGenMask
+	st := &uringFd{gen: r.gen}
+	r.fds[fd] = st
+	if err = r.armRead(fd, st); err != nil {
+		return
+	}
+	return r.flush()
+}
+
+func (r *uring) write(fd int) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	st, ok := r.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if !st.write {
+		st.write = true
+		if err = r.poll(fd, syscall.EPOLLOUT, 0, uringData(fd, uringWrite, st.gen)); err != nil {
+			return
+		}
+	}
+	// Like an epoll modification, the read event is rearmed along.
+	if err = r.rearmRead(fd, st); err != nil {
+		return
+	}
+	return r.flush()
+}
+
+func (r *uring) rearm(fd int) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	st, ok := r.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if err = r.rearmRead(fd, st); err != nil {
+		return
+	}
+	return r.flush()
+}
+
+// rearmRead arms the read poll of fd again with ONESHOT, and probes the data
+// left with EDGE.
+func (r *uring) rearmRead(fd int, st *uringFd) error {
+	switch r.trigger {
+	case ONESHOT:
+		if !st.read {
+			return r.armRead(fd, st)
+		}
+	case EDGE:
+		// The multishot poll only reports new data, probe the data left.
+		if !st.probe {
+			st.probe = true
+			return r.poll(fd, syscall.EPOLLIN, 0, uringData(fd, uringProbe, st.gen))
+		}
+	}
+	return nil
+}
+
+// unregister removes the poll requests right away, they hold a reference to
+// the file until they complete.
+func (r *uring) unregister(fd int) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	st, ok := r.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	delete(r.fds, fd)
+	r.removeAll(fd, st)
+	return r.submit(0, 0)
+}
+
+// io queues the I/O request op of the kind uringRecv, uringSend or
+// uringAccept. The read poll of fd is kept, its events are left to the recv
+// or accept in flight.
+func (r *uring) io(kind int, fd int, op *ioOp) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	st, ok := r.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	r.id = (r.id + 1) & uringGenMask
+	userData := uringData(fd, kind, r.id)
+	sqe := uringSQE{fd: int32(fd), userData: userData}
+	if len(op.buf) > 0 {
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&op.buf[0])))
+	}
+	switch kind {
+	case uringRecv:
+		sqe.opcode, sqe.len = ioringOpRecv, uint32(len(op.buf))
+	case uringSend:
+		sqe.opcode, sqe.len, sqe.opFlags = ioringOpSend, uint32(len(op.buf)), syscall.MSG_NOSIGNAL
+	case uringAccept:
+		op.addrlen = uint32(len(op.buf))
+		sqe.opcode, sqe.opFlags = ioringOpAccept, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC
+		sqe.off = uint64(uintptr(unsafe.Pointer(&op.addrlen)))
+	}
+	atomic.StoreInt32(&op.state, opBusy)
+	if err = r.queue(sqe); err != nil {
+		atomic.StoreInt32(&op.state, opIdle)
+		return
+	}
+	if kind != uringSend {
+		st.reads++
+	}
+	// The request keeps the buffer alive until it completes.
+	r.ops[userData] = uringOp{op: op, fd: fd, gen: st.gen}
+	st.ops = append(st.ops, userData)
+	return r.flush()
+}
+
+// notify reports a READ and WRITE event of fd with the next wait.
+func (r *uring) notify(fd int) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	st, ok := r.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+	if err = r.queue(uringSQE{opcode: ioringOpNop, fd: -1, userData: uringData(fd, uringNop, st.gen)}); err != nil {
+		return
+	}
+	return r.flush()
+}
+
+// inflight returns the number of I/O requests not completed yet.
+func (r *uring) inflight() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.ops)
+}
+
+// complete sets the result of the I/O request of the completion, and
+// reports whether its file descriptor is still registered.
+func (r *uring) complete(cqe uringCQE, kind int) bool {
+	o, ok := r.ops[cqe.userData]
+	if !ok {
+		return false
+	}
+	delete(r.ops, cqe.userData)
+	st, ok := r.fds[o.fd]
+	if ok = ok && st.gen == o.gen; ok {
+		for i, userData := range st.ops {
+			if userData == cqe.userData {
+				st.ops = append(st.ops[:i], st.ops[i+1:]...)
+				break
+			}
+		}
+		if kind != uringSend {
+			st.reads--
+			st.reported = r.waits
+		}
+	} else if kind == uringAccept && cqe.res >= 0 {
+		// The listener is closed.
+		syscall.Close(int(cqe.res))
+		cqe.res = -int32(syscall.ECANCELED)
+	}
+	o.op.res = cqe.res
+	atomic.StoreInt32(&o.op.state, opDone)
+	if !ok && o.op.wake != nil {
+		r.woken = append(r.woken, o.op)
+	}
+	return ok
+}
+
+func (r *uring) wait(events []Event, timeout int) (n int, err error) {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return 0, syscall.EBADF
+	}
+	for _, fd := range r.rearms {
+		if st, ok := r.fds[fd]; ok && !st.read {
+			r.armRead(fd, st)
+		}
+	}
+	r.rearms = r.rearms[:0]
+	r.waiting = true
+	r.waits++
+	r.lock.Unlock()
+
+	flags := uint32(ioringEnterGetEvents)
+	if timeout >= 0 {
+		r.ts = syscall.NsecToTimespec(int64(timeout) * 1e6)
+		r.arg.ts = uint64(uintptr(unsafe.Pointer(&r.ts)))
+		flags |= ioringEnterExtArg
+	}
+	err = r.submit(1, flags)
+
+	r.lock.Lock()
+	defer func() {
+		woken := r.woken
+		r.woken = nil
+		r.lock.Unlock()
+		for _, op := range woken {
+			op.wake()
+		}
+	}()
+	r.waiting = false
+	if r.closed {
+		// The ring was closed while waiting.
+		r.release()
+		return 0, syscall.EBADF
+	}
+	if err != nil {
+		return 0, err
+	}
+	head, tail := atomic.LoadUint32(r.cqHead), atomic.LoadUint32(r.cqTail)
+	for ; head != tail && n < len(events); head++ {
+		cqe := r.cqes[head&r.cqMask]
+		fd := int(int32(uint32(cqe.userData)))
+		kind := int(cqe.userData >> 32 & 7)
+		gen := uint32(cqe.userData >> 35)
+		more := cqe.flags&ioringCQEFMore != 0
+		switch kind {
+		case uringRemove:
+			continue
+		case uringRecv, uringAccept:
+			if r.complete(cqe, kind) {
+				events[n] = Event{Fd: fd, Mode: READ}
+				n++
+			}
+			continue
+		case uringSend:
+			if r.complete(cqe, kind) {
+				events[n] = Event{Fd: fd, Mode: WRITE}
+				n++
+			}
+			continue
+		}
+		st, ok := r.fds[fd]
+		if !ok || st.gen != gen {
+			// A stale multishot poll of a file descriptor registered again.
+			if more {
+				r.remove(cqe.userData)
+			}
+			continue
+		}
+		// A poll of the current registration is reported even when it was
+		// canceled, the reader gets EAGAIN at worst.
+		switch kind {
+		case uringRead:
+			if !more {
+				st.read = false
+				if r.trigger != ONESHOT && (cqe.res >= 0 || cqe.res == -int32(syscall.ECANCELED)) {
+					r.rearms = append(r.rearms, fd)
+				}
+			}
+			if st.reads > 0 || st.reported == r.waits {
+				continue
+			}
+			events[n] = Event{Fd: fd, Mode: READ}
+		case uringProbe:
+			if st.probe = false; st.reads > 0 || st.reported == r.waits {
+				continue
+			}
+			events[n] = Event{Fd: fd, Mode: READ}
+		case uringWrite:
+			st.write = false
+			events[n] = Event{Fd: fd, Mode: WRITE}
+		case uringNop:
+			events[n] = Event{Fd: fd, Mode: READ | WRITE}
+		}
+		n++
+	}
+	atomic.StoreUint32(r.cqHead, head)
+	return n, nil
+}
+
+// close closes the ring, or lets the blocked wait close it once it returns.
+func (r *uring) close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return syscall.EBADF
+	}
+	r.closed = true
+	if r.waiting {
+		return nil
+	}
+	return r.release()
+}
+
+func (r *uring) release() error {
+	syscall.Munmap(r.sqeMem)
+	syscall.Munmap(r.ring)
+	return syscall.Close(r.fd)
+}
+
+// sockaddr returns the peer address an accept stored in the buffer of op.
+func (op *ioOp) sockaddr() syscall.Sockaddr {
+	if op.addrlen < 2 || len(op.buf) < syscall.SizeofSockaddrAny {
+		return nil
+	}
+	rsa := (*syscall.RawSockaddrAny)(unsafe.Pointer(&op.buf[0]))
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		raw := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		return &syscall.SockaddrInet4{Port: int(port[0])<<8 | int(port[1]), Addr: raw.Addr}
+	case syscall.AF_INET6:
+		raw := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		return &syscall.SockaddrInet6{Port: int(port[0])<<8 | int(port[1]), ZoneId: raw.Scope_id, Addr: raw.Addr}
+	case syscall.AF_UNIX:
+		raw := (*syscall.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		n := int(op.addrlen) - int(unsafe.Offsetof(raw.Path))
+		if n > len(raw.Path) {
+			n = len(raw.Path)
+		}
+		name := make([]byte, 0, n)
+		for i := 0; i < n && raw.Path[i] != 0; i++ {
+			name = append(name, byte(raw.Path[i]))
+		}
+		return &syscall.SockaddrUnix{Name: string(name)}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests again with io_uring when the kernel supports it.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if p, err := CreateIOUring(); code == 0 && err == nil {
+		p.Close()
+		forceIOUring = true
+		code = m.Run()
+	}
+	os.Exit(code)
+}
+
+func TestPollIOUring(t *testing.T) {
+	p, err := CreateIOUring()
+	if err == ErrIOUring {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.SetTimeout(time.Millisecond * 10)
+	events := make([]Event, 128)
+	if n, err := p.Wait(events); err != nil {
+		t.Error(err)
+	} else if n != 0 {
+		t.Error(n)
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	p.Register(fds[0])
+	if err := p.Write(fds[0]); err != nil {
+		t.Error(err)
+	}
+	if n, err := p.Wait(events); err != nil {
+		t.Error(err)
+	} else if n != 1 || events[0].Fd != fds[0] || events[0].Mode != WRITE {
+		t.Error(n, events[0])
+	}
+	// Level triggered, the unread data is reported until it is read.
+	syscall.Write(fds[1], []byte("hello"))
+	for i := 0; i < 2; i++ {
+		if n, err := p.Wait(events); err != nil {
+			t.Error(err)
+		} else if n != 1 || events[0].Mode != READ {
+			t.Error(n, events[0])
+		}
+	}
+	syscall.Read(fds[0], make([]byte, 64))
+	if n, err := p.Wait(events); err != nil {
+		t.Error(err)
+	} else if n != 0 {
+		t.Error(n, events[0])
+	}
+	if err := p.Unregister(fds[0]); err != nil {
+		t.Error(err)
+	}
+	if err := p.Write(fds[0]); err != syscall.ENOENT {
+		t.Error(err)
+	}
+	// The socket is released once unregistered.
+	syscall.Close(fds[0])
+	if _, err := syscall.Read(fds[1], make([]byte, 64)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPollIOUringRequests(t *testing.T) {
+	p, err := CreateIOUring()
+	if err == ErrIOUring {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.SetTimeout(time.Millisecond * 10)
+	events := make([]Event, 128)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	p.Register(fds[0])
+	rop := &ioOp{buf: make([]byte, 64)}
+	if err := p.recv(fds[0], rop); err != nil {
+		t.Fatal(err)
+	}
+	// The read poll is left to the recv in flight.
+	syscall.Write(fds[1], []byte("hello"))
+	if n, err := p.Wait(events); err != nil {
+		t.Error(err)
+	} else if n != 1 || events[0].Fd != fds[0] || events[0].Mode != READ {
+		t.Error(n, events[:n])
+	}
+	if res, done := rop.take(); !done || string(rop.buf[:res]) != "hello" {
+		t.Error(res, done)
+	}
+	wop := &ioOp{buf: []byte("world")}
+	if err := p.send(fds[0], wop); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.Wait(events); err != nil {
+		t.Error(err)
+	} else if n != 1 || events[0].Mode != WRITE {
+		t.Error(n, events[:n])
+	}
+	if res, done := wop.take(); !done || res != 5 {
+		t.Error(res, done)
+	}
+	buf := make([]byte, 64)
+	if n, _ := syscall.Read(fds[1], buf); string(buf[:n]) != "world" {
+		t.Error(string(buf[:n]))
+	}
+	// A recv in flight is canceled once unregistered, it wakes its owner.
+	woken := make(chan struct{}, 1)
+	rop.wake = func() { woken <- struct{}{} }
+	if err := p.recv(fds[0], rop); err != nil {
+		t.Fatal(err)
+	}
+	p.Unregister(fds[0])
+	for i := 0; i < 10 && p.inflight() > 0; i++ {
+		p.Wait(events)
+	}
+	select {
+	case <-woken:
+	default:
+		t.Error("not woken")
+	}
+	if res, done := rop.take(); !done || res != -int32(syscall.ECANCELED) {
+		t.Error(res, done)
+	}
+}
+
+func TestPollIOUringFull(t *testing.T) {
+	r, err := newURing(4)
+	if err == ErrIOUring {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer r.release()
+	other, err := newURing(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.release()
+	nop := func(i int) uringSQE {
+		return uringSQE{opcode: ioringOpNop, fd: -1, userData: uringData(i, uringRemove, 0)}
+	}
+	for i := 0; i < int(r.sqSize); i++ {
+		if err := r.queue(nop(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The kernel consumes none of the requests, entering the other ring.
+	fd := r.fd
+	r.fd = other.fd
+	if err := r.queue(nop(int(r.sqSize))); err != syscall.EBUSY {
+		t.Error(err)
+	}
+	r.fd = fd
+	if err := r.submit(uint32(r.sqSize), ioringEnterGetEvents); err != nil {
+		t.Fatal(err)
+	}
+	// The requests queued are left as they are.
+	head, tail := atomic.LoadUint32(r.cqHead), atomic.LoadUint32(r.cqTail)
+	if tail-head != r.sqSize {
+		t.Error(tail - head)
+	}
+	for i := 0; head != tail; head, i = head+1, i+1 {
+		if cqe := r.cqes[head&r.cqMask]; cqe.userData != uringData(i, uringRemove, 0) {
+			t.Error(i, cqe.userData)
+		}
+	}
+	atomic.StoreUint32(r.cqHead, head)
+}
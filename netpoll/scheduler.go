@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Timer is a function scheduled by AfterFunc or Every.
+type Timer interface {
+	// Stop prevents the Timer from firing again. It reports whether the
+	// Timer was still scheduled.
+	Stop() bool
+}
+
+// Scheduler is implemented by the connections the Server passes to the
+// Handler Upgrade. It schedules functions on the worker loop serving the
+// connection, instead of spawning goroutines and timers for heartbeats,
+// retransmits or idle pings.
+//
+// The functions run on the worker loop, concurrently with Serve on the
+// shared workers unless NoAsync, and must not block. They fire at the
+// granularity of the worker timers, 10 milliseconds, never early, and are
+// stopped when the connection is closed.
+type Scheduler interface {
+	// AfterFunc runs f on the worker loop once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+	// Every runs f on the worker loop every period d, skipping the periods
+	// missed while f runs. It panics if d <= 0.
+	Every(d time.Duration, f func()) Timer
+}
+
+// AfterFunc runs f once d has elapsed, on the worker loop serving conn when
+// conn is a Scheduler, or in its own goroutine otherwise.
+func AfterFunc(conn net.Conn, d time.Duration, f func()) Timer {
+	if s, ok := conn.(Scheduler); ok {
+		return s.AfterFunc(d, f)
+	}
+	return time.AfterFunc(d, f)
+}
+
+// Every runs f every period d, on the worker loop serving conn when conn is
+// a Scheduler, or in its own goroutine otherwise. It panics if d <= 0.
+func Every(conn net.Conn, d time.Duration, f func()) Timer {
+	if s, ok := conn.(Scheduler); ok {
+		return s.Every(d, f)
+	}
+	return newTicker(d, f)
+}
+
+// ticker runs a function periodically in its own goroutine.
+type ticker struct {
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newTicker(d time.Duration, f func()) *ticker {
+	t := &ticker{ticker: time.NewTicker(d), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-t.ticker.C:
+				f()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop implements the Timer Stop method.
+func (t *ticker) Stop() (stopped bool) {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+		stopped = true
+	})
+	return
+}
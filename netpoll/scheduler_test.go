@@ -0,0 +1,40 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerFallback(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	if _, ok := conn.(Scheduler); ok {
+		t.Fatal("net.Pipe is a Scheduler")
+	}
+	done := make(chan struct{})
+	AfterFunc(conn, time.Millisecond, func() {
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("AfterFunc did not fire")
+	}
+	var ticks int32
+	ticker := Every(conn, time.Millisecond, func() {
+		atomic.AddInt32(&ticks, 1)
+	})
+	time.Sleep(time.Millisecond * 20)
+	if !ticker.Stop() || ticker.Stop() {
+		t.Error("Stop")
+	}
+	if atomic.LoadInt32(&ticks) == 0 {
+		t.Error("Every did not fire")
+	}
+}
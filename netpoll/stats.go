@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the statistics of a Server.
+type Stats struct {
+	// Accepted is the number of connections accepted.
+	Accepted uint64
+	// Dialed is the number of connections dialed by a Dialer, including
+	// the ones that failed to connect.
+	Dialed uint64
+	// Active is the number of connections open.
+	Active int64
+	// Closed is the number of connections closed.
+	Closed uint64
+	// BytesRead is the number of bytes read from the connections.
+	BytesRead uint64
+	// BytesWritten is the number of bytes written to the connections,
+	// including the bytes buffered and not flushed yet.
+	BytesWritten uint64
+	// Reschedules is the number of times the connections were rescheduled
+	// between the unshared and the shared workers.
+	Reschedules uint64
+	// Rescheduled is the number of connections moved by the reschedules.
+	Rescheduled uint64
+	// EAGAINs is the number of reads that found no data.
+	EAGAINs uint64
+	// Workers is the load of each worker.
+	Workers []WorkerStats
+}
+
+// WorkerStats is a snapshot of the load of a worker.
+type WorkerStats struct {
+	// Index is the index of the worker.
+	Index int
+	// Group is the index of the group of the worker.
+	Group int
+	// Shared reports whether the worker is a shared worker.
+	Shared bool
+	// Running reports whether the worker loop is running.
+	Running bool
+	// Conns is the number of connections of the worker.
+	Conns int64
+	// Events is the number of events the worker has polled.
+	Events uint64
+}
+
+// stats holds the counters of a Server.
+type stats struct {
+	accepted    atomic.Uint64
+	dialed      atomic.Uint64
+	closed      atomic.Uint64
+	read        atomic.Uint64
+	written     atomic.Uint64
+	reschedules atomic.Uint64
+	rescheduled atomic.Uint64
+	eagains     atomic.Uint64
+}
+
+// snapshot returns the Stats of the counters.
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Accepted:     s.accepted.Load(),
+		Dialed:       s.dialed.Load(),
+		Closed:       s.closed.Load(),
+		BytesRead:    s.read.Load(),
+		BytesWritten: s.written.Load(),
+		Reschedules:  s.reschedules.Load(),
+		Rescheduled:  s.rescheduled.Load(),
+		EAGAINs:      s.eagains.Load(),
+	}
+}
+
+// WritePrometheus writes the Stats to w in the Prometheus text format.
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	var buf bytes.Buffer
+	metric := func(name, typ, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP netpoll_%s %s\n# TYPE netpoll_%s %s\nnetpoll_%s %v\n", name, help, name, typ, name, value)
+	}
+	metric("connections_accepted_total", "counter", "Number of connections accepted.", s.Accepted)
+	metric("connections_dialed_total", "counter", "Number of connections dialed.", s.Dialed)
+	metric("connections_active", "gauge", "Number of connections open.", s.Active)
+	metric("connections_closed_total", "counter", "Number of connections closed.", s.Closed)
+	metric("read_bytes_total", "counter", "Number of bytes read from the connections.", s.BytesRead)
+	metric("written_bytes_total", "counter", "Number of bytes written to the connections.", s.BytesWritten)
+	metric("reschedules_total", "counter", "Number of reschedules between the unshared and the shared workers.", s.Reschedules)
+	metric("rescheduled_connections_total", "counter", "Number of connections moved by the reschedules.", s.Rescheduled)
+	metric("eagain_total", "counter", "Number of reads that found no data.", s.EAGAINs)
+	if len(s.Workers) > 0 {
+		buf.WriteString("# HELP netpoll_worker_connections Number of connections of the worker.\n# TYPE netpoll_worker_connections gauge\n")
+		for _, worker := range s.Workers {
+			fmt.Fprintf(&buf, "netpoll_worker_connections{worker=\"%d\",shared=\"%t\"} %d\n", worker.Index, worker.Shared, worker.Conns)
+		}
+		buf.WriteString("# HELP netpoll_worker_events_total Number of events the worker has polled.\n# TYPE netpoll_worker_events_total counter\n")
+		for _, worker := range s.Workers {
+			fmt.Fprintf(&buf, "netpoll_worker_events_total{worker=\"%d\",shared=\"%t\"} %d\n", worker.Index, worker.Shared, worker.Events)
+		}
+		buf.WriteString("# HELP netpoll_worker_running Whether the worker loop is running.\n# TYPE netpoll_worker_running gauge\n")
+		for _, worker := range s.Workers {
+			var running int
+			if worker.Running {
+				running = 1
+			}
+			fmt.Fprintf(&buf, "netpoll_worker_running{worker=\"%d\",shared=\"%t\"} %d\n", worker.Index, worker.Shared, running)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
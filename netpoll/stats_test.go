@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errors.New("write error") }
+
+func TestStatsWritePrometheus(t *testing.T) {
+	stats := &Stats{
+		Accepted:     3,
+		Dialed:       1,
+		Active:       2,
+		Closed:       2,
+		BytesRead:    1024,
+		BytesWritten: 2048,
+		Reschedules:  5,
+		Rescheduled:  4,
+		EAGAINs:      7,
+		Workers: []WorkerStats{
+			{Index: 0, Running: true, Conns: 2, Events: 9},
+			{Index: 1, Shared: true},
+		},
+	}
+	var buf bytes.Buffer
+	if err := stats.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE netpoll_connections_accepted_total counter\nnetpoll_connections_accepted_total 3\n",
+		"netpoll_connections_dialed_total 1\n",
+		"# TYPE netpoll_connections_active gauge\nnetpoll_connections_active 2\n",
+		"netpoll_connections_closed_total 2\n",
+		"netpoll_read_bytes_total 1024\n",
+		"netpoll_written_bytes_total 2048\n",
+		"netpoll_reschedules_total 5\n",
+		"netpoll_rescheduled_connections_total 4\n",
+		"netpoll_eagain_total 7\n",
+		"netpoll_worker_connections{worker=\"0\",shared=\"false\"} 2\n",
+		"netpoll_worker_events_total{worker=\"0\",shared=\"false\"} 9\n",
+		"netpoll_worker_running{worker=\"0\",shared=\"false\"} 1\n",
+		"netpoll_worker_running{worker=\"1\",shared=\"true\"} 0\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	if strings.Count(out, "# TYPE netpoll_worker_connections") != 1 {
+		t.Error(out)
+	}
+	if err := stats.WritePrometheus(errWriter{}); err == nil {
+		t.Error("Unexpected")
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	timerTick  = time.Millisecond * 10
+	timerSlots = 512
+)
+
+// timer is a function scheduled on a timerWheel.
+type timer struct {
+	tw    *timerWheel
+	when  int64
+	f     func()
+	slot  int
+	index int
+}
+
+// timerWheel is a hashed timing wheel. A timer is hashed into the slot of the
+// tick it expires in, so a slot holds the timers of every revolution and only
+// the expired ones fire when the wheel passes it. Timers fire at tick
+// granularity, never early.
+type timerWheel struct {
+	lock  sync.Mutex
+	tick  int64
+	slots [][]*timer
+	// now is the first tick that has not been processed yet.
+	now int64
+	// next is a tick no timer expires before. It is found again by timeout
+	// once the wheel passes it.
+	next  int64
+	count int
+}
+
+func newTimerWheel(tick time.Duration, slots int) *timerWheel {
+	return &timerWheel{
+		tick:  int64(tick),
+		slots: make([][]*timer, slots),
+		now:   time.Now().UnixNano() / int64(tick),
+	}
+}
+
+// add schedules f to run at when, in unix nanoseconds. It reports whether the
+// timer expires before the last timeout returned.
+func (tw *timerWheel) add(when int64, f func()) (t *timer, earlier bool) {
+	t = &timer{tw: tw, when: when, f: f}
+	tw.lock.Lock()
+	earlier = tw.schedule(t)
+	tw.lock.Unlock()
+	return
+}
+
+func (tw *timerWheel) schedule(t *timer) (earlier bool) {
+	tick := t.when / tw.tick
+	if tick < tw.now {
+		tick = tw.now
+	}
+	if tw.count == 0 || tick < tw.next {
+		earlier = true
+		tw.next = tick
+	}
+	t.slot = int(tick % int64(len(tw.slots)))
+	t.index = len(tw.slots[t.slot])
+	tw.slots[t.slot] = append(tw.slots[t.slot], t)
+	tw.count++
+	return
+}
+
+// stop cancels t, reporting whether it was still pending.
+func (t *timer) stop() bool {
+	return t.tw.stop(t)
+}
+
+func (tw *timerWheel) stop(t *timer) bool {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if t.slot < 0 {
+		return false
+	}
+	tw.remove(t)
+	return true
+}
+
+func (tw *timerWheel) remove(t *timer) {
+	slot := tw.slots[t.slot]
+	last := len(slot) - 1
+	slot[t.index] = slot[last]
+	slot[t.index].index = t.index
+	slot[last] = nil
+	tw.slots[t.slot] = slot[:last]
+	t.slot = -1
+	tw.count--
+}
+
+// pending returns the number of scheduled timers.
+func (tw *timerWheel) pending() int {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	return tw.count
+}
+
+// timeout returns the time from now to the next expiry, up to max.
+func (tw *timerWheel) timeout(now time.Time, max time.Duration) time.Duration {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.count == 0 {
+		return max
+	}
+	if tw.next < tw.now {
+		// Scans the slots up to max, at most one revolution.
+		last := (now.UnixNano() + int64(max)) / tw.tick
+		if end := tw.now + int64(len(tw.slots)) - 1; last > end {
+			last = end
+		}
+		for tw.next = tw.now; tw.next <= last; tw.next++ {
+			if tw.expires(tw.next) {
+				break
+			}
+		}
+	}
+	// The timers of a tick fire once the wheel passes it.
+	d := time.Duration((tw.next+1)*tw.tick - now.UnixNano())
+	if d > max {
+		return max
+	} else if d < time.Millisecond {
+		return time.Millisecond
+	}
+	// Rounds up to the millisecond precision of the poll timeout.
+	return (d + time.Millisecond - 1) / time.Millisecond * time.Millisecond
+}
+
+// expires reports whether a timer expires in the tick.
+func (tw *timerWheel) expires(tick int64) bool {
+	end := (tick + 1) * tw.tick
+	for _, t := range tw.slots[tick%int64(len(tw.slots))] {
+		if t.when < end {
+			return true
+		}
+	}
+	return false
+}
+
+// advance fires the timers of all the ticks elapsed by now. The functions run
+// on the calling goroutine, outside the lock.
+func (tw *timerWheel) advance(now time.Time) {
+	tw.lock.Lock()
+	target := now.UnixNano() / tw.tick
+	if tw.count == 0 || target <= tw.now {
+		if target > tw.now {
+			tw.now = target
+		}
+		tw.lock.Unlock()
+		return
+	}
+	// One revolution visits every slot.
+	if target-tw.now > int64(len(tw.slots)) {
+		tw.now = target - int64(len(tw.slots))
+	}
+	var fired []*timer
+	for ; tw.now < target; tw.now++ {
+		end := (tw.now + 1) * tw.tick
+		slot := tw.slots[tw.now%int64(len(tw.slots))]
+		for i := 0; i < len(slot); {
+			if t := slot[i]; t.when < end {
+				tw.remove(t)
+				slot = tw.slots[tw.now%int64(len(tw.slots))]
+				fired = append(fired, t)
+				continue
+			}
+			i++
+		}
+	}
+	tw.lock.Unlock()
+	for _, t := range fired {
+		t.f()
+	}
+}
+
+// connTimer is a Timer of a connection, scheduled on the timer wheel of the
+// worker serving the connection. A periodic connTimer is scheduled again on
+// the current worker each time it fires, so it follows the connection when
+// it is rescheduled.
+type connTimer struct {
+	c      *conn
+	f      func()
+	period int64
+	lock   sync.Mutex
+	when   int64
+	t      *timer
+	done   bool
+}
+
+// AfterFunc implements the Scheduler AfterFunc method.
+func (c *conn) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(d, 0, f)
+}
+
+// Every implements the Scheduler Every method.
+func (c *conn) Every(d time.Duration, f func()) Timer {
+	if d <= 0 {
+		panic("non-positive interval for Every")
+	}
+	return c.schedule(d, d, f)
+}
+
+func (c *conn) schedule(d, period time.Duration, f func()) Timer {
+	t := &connTimer{c: c, f: f, period: int64(period)}
+	c.timerLock.Lock()
+	if atomic.LoadInt32(&c.closed) != 0 {
+		c.timerLock.Unlock()
+		t.done = true
+		return t
+	}
+	if c.timers == nil {
+		c.timers = make(map[*connTimer]struct{})
+	}
+	c.timers[t] = struct{}{}
+	c.timerLock.Unlock()
+	t.arm(time.Now().UnixNano() + int64(d))
+	return t
+}
+
+// stopTimers stops the Timers of the connection once it is closed.
+func (c *conn) stopTimers() {
+	c.timerLock.Lock()
+	timers := c.timers
+	c.timers = nil
+	c.timerLock.Unlock()
+	for t := range timers {
+		t.Stop()
+	}
+}
+
+func (t *connTimer) arm(when int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return
+	}
+	w := t.c.worker()
+	if w == nil || w.timers == nil {
+		t.done = true
+		return
+	}
+	t.when = when
+	t.t = w.afterFunc(when, t.fire)
+}
+
+func (t *connTimer) fire() {
+	t.lock.Lock()
+	if t.done {
+		t.lock.Unlock()
+		return
+	}
+	t.t = nil
+	t.done = t.period == 0
+	when := t.when
+	t.lock.Unlock()
+	if t.period == 0 {
+		t.c.forget(t)
+	}
+	t.f()
+	if t.period > 0 {
+		when += t.period
+		if now := time.Now().UnixNano(); when <= now {
+			when += ((now-when)/t.period + 1) * t.period
+		}
+		t.arm(when)
+	}
+}
+
+// Stop implements the Timer Stop method.
+func (t *connTimer) Stop() bool {
+	t.lock.Lock()
+	if t.done {
+		t.lock.Unlock()
+		return false
+	}
+	t.done = true
+	if t.t != nil {
+		t.t.stop()
+		t.t = nil
+	}
+	t.lock.Unlock()
+	t.c.forget(t)
+	return true
+}
+
+// forget removes the Timer t from the connection.
+func (c *conn) forget(t *connTimer) {
+	c.timerLock.Lock()
+	delete(c.timers, t)
+	c.timerLock.Unlock()
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel(t *testing.T) {
+	tw := newTimerWheel(timerTick, 8)
+	start := time.Unix(0, tw.now*int64(timerTick))
+	var fired []int
+	add := func(i int, d time.Duration) *timer {
+		t, _ := tw.add(start.Add(d).UnixNano(), func() {
+			fired = append(fired, i)
+		})
+		return t
+	}
+	if _, earlier := tw.add(start.UnixNano(), func() {}); !earlier {
+		t.Error("expected earlier timer")
+	}
+	add(1, timerTick*3)
+	add(2, timerTick*11) // next revolution, same slot as 3
+	stopped := add(3, timerTick*3+1)
+	add(4, -time.Second)
+	if !stopped.stop() || stopped.stop() {
+		t.Error("stop")
+	}
+	if tw.pending() != 4 {
+		t.Error(tw.pending())
+	}
+	tw.advance(start.Add(timerTick))
+	if len(fired) != 1 || fired[0] != 4 {
+		t.Error(fired)
+	}
+	tw.advance(start.Add(timerTick * 4))
+	if len(fired) != 2 || fired[1] != 1 {
+		t.Error(fired)
+	}
+	tw.advance(start.Add(timerTick * 11))
+	if len(fired) != 2 {
+		t.Error(fired)
+	}
+	tw.advance(start.Add(timerTick * 100))
+	if len(fired) != 3 || fired[2] != 2 || tw.pending() != 0 {
+		t.Error(fired, tw.pending())
+	}
+}
+
+func TestTimerWheelTimeout(t *testing.T) {
+	tw := newTimerWheel(timerTick, 8)
+	start := time.Unix(0, tw.now*int64(timerTick))
+	if d := tw.timeout(start, time.Second); d != time.Second {
+		t.Error(d)
+	}
+	if _, earlier := tw.add(start.Add(timerTick*20).UnixNano(), func() {}); !earlier {
+		t.Error("expected earlier timer")
+	}
+	if d := tw.timeout(start, time.Second); d != timerTick*21 {
+		t.Error(d)
+	}
+	stopped, earlier := tw.add(start.Add(timerTick*2).UnixNano(), func() {})
+	if !earlier || !stopped.stop() {
+		t.Error("expected earlier timer")
+	}
+	if d := tw.timeout(start, timerTick*2); d != timerTick*2 {
+		t.Error(d)
+	}
+	tw.advance(start.Add(timerTick * 3))
+	// The timer is two revolutions ahead, the wheel is scanned once.
+	if d := tw.timeout(start.Add(timerTick*3), time.Second); d != timerTick*9 {
+		t.Error(d)
+	}
+	tw.advance(start.Add(timerTick * 16))
+	if d := tw.timeout(start.Add(timerTick*16+timerTick/2), time.Second); d != timerTick*4+timerTick/2 {
+		t.Error(d)
+	}
+	if _, earlier := tw.add(start.Add(timerTick*30).UnixNano(), func() {}); earlier {
+		t.Error("unexpected earlier timer")
+	}
+	if _, earlier := tw.add(start.Add(timerTick*17).UnixNano(), func() {}); !earlier {
+		t.Error("expected earlier timer")
+	}
+	if d := tw.timeout(start.Add(timerTick*16), time.Second); d != timerTick*2 {
+		t.Error(d)
+	}
+}
+
+func TestConnTimers(t *testing.T) {
+	var ticks, after int32
+	stopped := make(chan Timer, 1)
+	handler := NewHandler(func(conn net.Conn) (Context, error) {
+		AfterFunc(conn, timerTick*3, func() {
+			atomic.AddInt32(&after, 1)
+		})
+		stop := AfterFunc(conn, time.Hour, func() {
+			t.Error("stopped timer fired")
+		})
+		if !stop.Stop() || stop.Stop() {
+			t.Error("Stop")
+		}
+		stopped <- Every(conn, timerTick*2, func() {
+			atomic.AddInt32(&ticks, 1)
+		})
+		return conn, nil
+	}, func(ctx Context) error {
+		buf := make([]byte, 64)
+		n, err := ctx.(net.Conn).Read(buf)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.(net.Conn).Write(buf[:n])
+		return err
+	})
+	server := &Server{Handler: handler}
+	l, _ := net.Listen("tcp", ":9999")
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.Serve(l)
+	}()
+	conn, err := net.Dial("tcp", ":9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	every := <-stopped
+	time.Sleep(timerTick * 20)
+	if n := atomic.LoadInt32(&after); n != 1 {
+		t.Error(n)
+	}
+	if n := atomic.LoadInt32(&ticks); n < 3 || n > 10 {
+		t.Error(n)
+	}
+	if !every.Stop() || every.Stop() {
+		t.Error("Stop")
+	}
+	n := atomic.LoadInt32(&ticks)
+	time.Sleep(timerTick * 5)
+	if atomic.LoadInt32(&ticks) != n {
+		t.Error(atomic.LoadInt32(&ticks), n)
+	}
+	// The timers of a connection are stopped when it is closed.
+	conn2, err := net.Dial("tcp", ":9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	every = <-stopped
+	conn2.Close()
+	time.Sleep(timerTick * 5)
+	if every.Stop() {
+		t.Error("Stop after Close")
+	}
+	conn.Close()
+	server.Close()
+	wg.Wait()
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTLSHandshakeTimeout is the default Server.TLSHandshakeTimeout.
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	// certCheckInterval is how often a CertReloader checks its files.
+	certCheckInterval = time.Second
+)
+
+// ListenAndServeTLS listens on the network address and then calls
+// Serve with handler to handle requests on incoming TLS connections.
+// The certificate is loaded from certFile and keyFile, and reloaded
+// when they are modified.
+//
+// The handler must be not nil.
+//
+// ListenAndServeTLS always returns a non-nil error.
+func ListenAndServeTLS(network, address, certFile, keyFile string, handler Handler) error {
+	server := &Server{Network: network, Address: address, Handler: handler}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ListenAndServeTLS acts like ListenAndServe, but terminates TLS on the
+// connections. The certificate is loaded from certFile and keyFile, and
+// reloaded when they are modified. The files may be empty when the
+// TLSConfig has the certificates.
+//
+// ListenAndServeTLS always returns a non-nil error.
+// After Close the returned error is ErrServerClosed.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		reloader, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		defer reloader.Close()
+		config.Certificates = nil
+		config.GetCertificate = reloader.GetCertificate
+	}
+	s.TLSConfig = config
+	return s.ListenAndServe()
+}
+
+// tlsConn is a TLS connection, that schedules the Timers on the connection
+// below.
+type tlsConn struct {
+	*tls.Conn
+	scheduler Scheduler
+}
+
+// AfterFunc implements the Scheduler AfterFunc method.
+func (c *tlsConn) AfterFunc(d time.Duration, f func()) Timer {
+	return c.scheduler.AfterFunc(d, f)
+}
+
+// Every implements the Scheduler Every method.
+func (c *tlsConn) Every(d time.Duration, f func()) Timer {
+	return c.scheduler.Every(d, f)
+}
+
+// CertReloader loads a certificate from a pair of PEM encoded files, and
+// reloads it when the files are modified. The files are checked in the
+// background once a second, until the CertReloader is closed.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	done     chan struct{}
+	closed   sync.Once
+}
+
+// NewCertReloader returns a new CertReloader with the certificate loaded
+// from certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, done: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch(certCheckInterval)
+	return r, nil
+}
+
+// Close stops checking the files. The certificate loaded is still returned.
+func (r *CertReloader) Close() error {
+	r.closed.Do(func() { close(r.done) })
+	return nil
+}
+
+// watch checks the files every interval until the CertReloader is closed.
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// check reloads the certificate if the files have been modified since it
+// was loaded. A pair half written is loaded on a later check.
+func (r *CertReloader) check() {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	modified := !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+	r.lock.Unlock()
+	if modified {
+		r.Reload()
+	}
+}
+
+// Reload loads the certificate from the files. The previous certificate is
+// kept when it fails.
+func (r *CertReloader) Reload() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	r.lock.Unlock()
+	return nil
+}
+
+// GetCertificate returns the certificate last loaded, without touching the
+// files, so it does not block a handshake. It is meant for the tls.Config
+// GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return
+	}
+	certMod = info.ModTime()
+	if info, err = os.Stat(r.keyFile); err != nil {
+		return
+	}
+	keyMod = info.ModTime()
+	return
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oxtoacart/bpool"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 to the files,
+// and returns it.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "netpoll"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := writeCert(t, certFile, keyFile, 1)
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	handler.SetUpgrade(func(conn net.Conn) (net.Conn, error) {
+		if _, ok := conn.(*tlsConn); !ok {
+			t.Errorf("%T", conn)
+		}
+		if _, ok := conn.(Scheduler); !ok {
+			t.Errorf("%T is not a Scheduler", conn)
+		}
+		return conn, nil
+	})
+	server := &Server{
+		Network:             "tcp",
+		Address:             "127.0.0.1:9997",
+		Handler:             handler,
+		TLSHandshakeTimeout: time.Millisecond * 100,
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	conn, err := tls.Dial("tcp", "127.0.0.1:9997", &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range [][]byte{[]byte("Hello World"), bytes.Repeat([]byte("netpoll"), 0x2000)} {
+		for i := 0; i < 3; i++ {
+			if _, err := conn.Write(msg); err != nil {
+				t.Error(err)
+			}
+			buf := make([]byte, len(msg))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(buf, msg) {
+				t.Error(len(buf))
+			}
+		}
+	}
+	conn.Close()
+	// A client that does not complete the handshake is closed.
+	raw, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := raw.Read(make([]byte, 1)); err != io.EOF {
+		t.Error(err)
+	}
+	raw.Close()
+	server.Close()
+	wg.Wait()
+}
+
+// trickleConn writes in small pieces, for the handshake to be read over many
+// events.
+type trickleConn struct {
+	net.Conn
+}
+
+func (c trickleConn) Write(b []byte) (n int, err error) {
+	for n < len(b) {
+		end := n + 16
+		if end > len(b) {
+			end = len(b)
+		}
+		written, err := c.Conn.Write(b[n:end])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return n, nil
+}
+
+func TestTLSHandshakeTrickle(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := writeCert(t, certFile, keyFile, 1)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler = &DataHandler{
+		Pool: bpool.NewBytePool(1024, 12*1024),
+		HandlerFunc: func(req []byte) (res []byte) {
+			res = req
+			return
+		},
+	}
+	server := &Server{
+		Network:   "tcp",
+		Address:   "127.0.0.1:9997",
+		Handler:   handler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		raw, err := net.Dial("tcp", "127.0.0.1:9997")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := tls.Client(trickleConn{raw}, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", MaxVersion: version})
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		msg := []byte("Hello World")
+		if _, err := conn.Write(msg); err != nil {
+			t.Error(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(buf, msg) {
+			t.Error(string(buf))
+		}
+		if state := conn.ConnectionState(); state.Version != version {
+			t.Error(state.Version)
+		}
+		conn.Close()
+	}
+	server.Close()
+	wg.Wait()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Error("loaded missing files")
+	}
+	writeCert(t, certFile, keyFile, 1)
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The files are checked by the test only.
+	r.Close()
+	serial := func() int64 {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	modify := func() {
+		later := time.Now().Add(time.Hour)
+		os.Chtimes(certFile, later, later)
+		os.Chtimes(keyFile, later, later)
+		r.check()
+	}
+	if serial() != 1 {
+		t.Error("first certificate")
+	}
+	writeCert(t, certFile, keyFile, 2)
+	if serial() != 1 {
+		t.Error("reloaded before a check")
+	}
+	modify()
+	if serial() != 2 {
+		t.Error("not reloaded")
+	}
+	os.WriteFile(keyFile, []byte("half written"), 0600)
+	modify()
+	if serial() != 2 {
+		t.Error("the previous certificate is not kept")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// handshake drives the TLS handshake of an accepted connection with the
+// events of its worker. crypto/tls cannot resume a handshake once a read
+// fails, so the handshake runs on the upgrade goroutine over the
+// non-blocking socket and parks on EAGAIN. The worker only marks it ready
+// when the socket is polled ready, so neither the handshake nor the
+// certificate and verification callbacks run on the worker loop, and no
+// thread is blocked on the socket.
+type handshake struct {
+	lock sync.Mutex
+	cond sync.Cond
+	// ready reports whether the socket was polled ready since the handshake
+	// parked, err why it was aborted, both guarded by lock.
+	ready bool
+	err   error
+	done  int32
+}
+
+func newHandshake() *handshake {
+	h := &handshake{}
+	h.cond.L = &h.lock
+	return h
+}
+
+// park waits for the worker to poll the socket ready, and returns the error
+// the handshake was aborted with.
+func (h *handshake) park() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for !h.ready && h.err == nil {
+		h.cond.Wait()
+	}
+	h.ready = false
+	return h.err
+}
+
+// resume marks the socket ready on an event of the worker, without waiting
+// for the handshake. It reports false once the handshake is done.
+func (h *handshake) resume() bool {
+	if atomic.LoadInt32(&h.done) != 0 {
+		return false
+	}
+	h.lock.Lock()
+	h.ready = true
+	h.cond.Signal()
+	h.lock.Unlock()
+	return true
+}
+
+// abort makes the handshake fail with err.
+func (h *handshake) abort(err error) {
+	h.lock.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.cond.Broadcast()
+	h.lock.Unlock()
+}
+
+// finish marks the handshake done, the events are served by the connection
+// from then on.
+func (h *handshake) finish() {
+	atomic.StoreInt32(&h.done, 1)
+}
+
+// handshake completes the TLS handshake of the connection without blocking
+// on the socket. A Read of the returned connection returns EAGAIN once the
+// connection is upgraded, and is served again on the next event.
+func (c *conn) handshake(config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	defer c.hs.finish()
+	if err := syscall.SetNonblock(c.fd, true); err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultTLSHandshakeTimeout
+	}
+	t := c.AfterFunc(timeout, func() { c.hs.abort(os.ErrDeadlineExceeded) })
+	defer t.Stop()
+	tc := tls.Server(handshakeConn{c}, config)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return &tlsConn{Conn: tc, scheduler: c}, nil
+}
+
+// wait parks the handshake until the worker polls the socket readable, or
+// writable when write is set.
+func (c *conn) wait(write bool) error {
+	c.lock.Lock()
+	if c.w != nil {
+		if write {
+			c.w.poll.Write(c.fd)
+		} else if c.w.server.Trigger == ONESHOT {
+			c.w.poll.Rearm(c.fd)
+		}
+	}
+	c.lock.Unlock()
+	return c.hs.park()
+}
+
+// handshakeConn is the connection below the tls.Conn of an accepted
+// connection. Until the handshake is done, Read and Write park it on EAGAIN.
+type handshakeConn struct {
+	*conn
+}
+
+// Read reads data from the connection.
+func (c handshakeConn) Read(b []byte) (n int, err error) {
+	if atomic.LoadInt32(&c.hs.done) != 0 {
+		return c.conn.Read(b)
+	}
+	for {
+		n, err = syscall.Read(c.fd, b)
+		switch {
+		case n > 0:
+			if c.stats != nil {
+				c.stats.read.Add(uint64(n))
+			}
+			if c.idleTimeout > 0 {
+				c.touch()
+			}
+			return n, nil
+		case err == syscall.EINTR:
+		case err != syscall.EAGAIN:
+			return 0, EOF
+		default:
+			if c.stats != nil {
+				c.stats.eagains.Add(1)
+			}
+			if err = c.wait(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// Write writes data to the connection.
+func (c handshakeConn) Write(b []byte) (n int, err error) {
+	if atomic.LoadInt32(&c.hs.done) != 0 {
+		return c.conn.Write(b)
+	}
+	waited := false
+	for n < len(b) {
+		written, err := syscall.Write(c.fd, b[n:])
+		if written > 0 {
+			n += written
+			continue
+		}
+		if err == syscall.EINTR {
+			continue
+		} else if err != syscall.EAGAIN {
+			c.wrote(int64(n))
+			return n, EOF
+		}
+		if err = c.wait(true); err != nil {
+			c.wrote(int64(n))
+			return n, err
+		}
+		waited = true
+	}
+	if w := c.worker(); waited && !c.uring && w != nil && w.server.Trigger == LEVEL {
+		// A level triggered write event is reported until it is removed.
+		c.rearm()
+	}
+	c.wrote(int64(n))
+	return n, nil
+}
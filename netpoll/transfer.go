@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// ErrTransferCanceled is the error when a Transfer is canceled.
+var ErrTransferCanceled = errors.New("transfer canceled")
+
+// Sender is implemented by the connections the Server passes to the Handler
+// Upgrade. It queues zero-copy transfers that the worker loop sends with
+// sendfile and splice as the socket becomes writable, in order with the
+// data written to the connection, instead of blocking a goroutine in
+// io.Copy.
+type Sender interface {
+	// SendFile sends n bytes of the file f from the offset off, or up to
+	// the end of the file if n <= 0. The file must stay open until the
+	// Transfer is done.
+	SendFile(f *os.File, off, n int64, progress func(written int64)) (*Transfer, error)
+	// Splice sends n bytes read from src, or up to the end of src if
+	// n <= 0. src is read in its own goroutine and must not be served by
+	// a Handler.
+	Splice(src net.Conn, n int64, progress func(written int64)) (*Transfer, error)
+}
+
+// SendFile sends n bytes of the file f from the offset off to conn, or up
+// to the end of the file if n <= 0. It is queued on the worker loop serving
+// conn when conn is a Sender, or copied in its own goroutine otherwise.
+//
+// The progress func is called with the number of bytes written so far each
+// time the transfer advances, if not nil.
+func SendFile(conn net.Conn, f *os.File, off, n int64, progress func(written int64)) (*Transfer, error) {
+	if s, ok := conn.(Sender); ok {
+		return s.SendFile(f, off, n, progress)
+	}
+	r, err := fileReader(f, off, n)
+	if err != nil {
+		return nil, err
+	}
+	t := newTransfer(progress)
+	go t.copy(conn, r, r.Size())
+	return t, nil
+}
+
+// Splice sends n bytes read from src to conn, or up to the end of src if
+// n <= 0. It is queued on the worker loop serving conn when conn is a
+// Sender, or copied in its own goroutine otherwise.
+//
+// The progress func is called with the number of bytes written so far each
+// time the transfer advances, if not nil.
+func Splice(conn net.Conn, src net.Conn, n int64, progress func(written int64)) (*Transfer, error) {
+	if s, ok := conn.(Sender); ok {
+		return s.Splice(src, n, progress)
+	}
+	t := newTransfer(progress)
+	go t.copy(conn, limitReader(src, n), n)
+	return t, nil
+}
+
+// Transfer is a transfer queued by SendFile or Splice.
+//
+// A Transfer that fails or is canceled may be resumed by a new transfer
+// skipping the Written bytes.
+type Transfer struct {
+	written  int64
+	canceled int32
+	progress func(written int64)
+	// pos is the number of buffered bytes written to the connection before
+	// the transfer, guarded by the connection wlock.
+	pos    int
+	source source
+	// cancel removes the transfer from the queue of its connection.
+	cancel func(t *Transfer)
+	err    error
+	done   chan struct{}
+}
+
+// source is the data of a Transfer sent on a worker loop.
+type source interface {
+	// send sends the data to the socket fd, until it returns EAGAIN when
+	// the socket is not writable, errWaiting when no data is ready, or
+	// completes with a nil error.
+	send(fd int) (n int64, err error)
+	close()
+}
+
+// errWaiting is returned by a source with no data ready to send.
+var errWaiting = errors.New("waiting for data")
+
+func newTransfer(progress func(written int64)) *Transfer {
+	return &Transfer{progress: progress, done: make(chan struct{})}
+}
+
+// Written returns the number of bytes written so far.
+func (t *Transfer) Written() int64 {
+	return atomic.LoadInt64(&t.written)
+}
+
+// Done returns a channel that is closed when the transfer completes, fails
+// or is canceled.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error of the transfer once it is done, nil if it
+// completed.
+func (t *Transfer) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits for the transfer and returns the number of bytes written and
+// its error.
+func (t *Transfer) Wait() (int64, error) {
+	<-t.done
+	return t.Written(), t.err
+}
+
+// Cancel cancels the transfer. The transfer is done with
+// ErrTransferCanceled unless it completed already.
+func (t *Transfer) Cancel() {
+	if atomic.CompareAndSwapInt32(&t.canceled, 0, 1) && t.cancel != nil {
+		t.cancel(t)
+	}
+}
+
+// send sends the data of the source.
+func (t *Transfer) send(fd int) (n int64, err error) {
+	if atomic.LoadInt32(&t.canceled) != 0 {
+		return 0, ErrTransferCanceled
+	}
+	n, err = t.source.send(fd)
+	atomic.AddInt64(&t.written, n)
+	return
+}
+
+func (t *Transfer) report() {
+	if t.progress != nil {
+		t.progress(t.Written())
+	}
+}
+
+func (t *Transfer) finish(err error) {
+	if t.source != nil {
+		t.source.close()
+	}
+	t.err = err
+	close(t.done)
+}
+
+// copy copies the data of r to w, expecting n bytes if n > 0.
+func (t *Transfer) copy(w io.Writer, r io.Reader, n int64) {
+	buf := make([]byte, bufferSize)
+	var err error
+	for atomic.LoadInt32(&t.canceled) == 0 {
+		var nr, nw int
+		nr, err = r.Read(buf)
+		if nr > 0 {
+			nw, err = w.Write(buf[:nr])
+			if nw > 0 {
+				atomic.AddInt64(&t.written, int64(nw))
+				t.report()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if atomic.LoadInt32(&t.canceled) != 0 {
+		err = ErrTransferCanceled
+	} else if err == io.EOF {
+		err = nil
+		if n > 0 && t.Written() < n {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	t.finish(err)
+}
+
+// fileReader returns a reader of n bytes of f from the offset off, or up to
+// the end of f if n <= 0.
+func fileReader(f *os.File, off, n int64) (*io.SectionReader, error) {
+	if n <= 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if n = info.Size() - off; n < 0 {
+			n = 0
+		}
+	}
+	return io.NewSectionReader(f, off, n), nil
+}
+
+func limitReader(r io.Reader, n int64) io.Reader {
+	if n > 0 {
+		return io.LimitReader(r, n)
+	}
+	return r
+}
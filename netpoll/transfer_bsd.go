@@ -0,0 +1,16 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"net"
+)
+
+// newPipe returns a pipe in user space, without splice.
+func newPipe(src net.Conn) (pipe, error) {
+	return &bufferPipe{}, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+const (
+	spliceMove     = 0x1
+	spliceNonblock = 0x2
+)
+
+// kernelPipe is a pipe the data is moved through with splice.
+type kernelPipe struct {
+	raw syscall.RawConn
+	fds [2]int
+}
+
+// newPipe returns a kernel pipe for a TCP connection, which is waited for
+// on the runtime poller, or a pipe in user space otherwise.
+func newPipe(src net.Conn) (pipe, error) {
+	tcp, ok := src.(*net.TCPConn)
+	if !ok {
+		return &bufferPipe{}, nil
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	p := &kernelPipe{raw: raw}
+	syscall.ForkLock.RLock()
+	err = syscall.Pipe2(p.fds[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK)
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *kernelPipe) fill(src net.Conn, n int) (piped int, err error) {
+	rerr := p.raw.Read(func(fd uintptr) bool {
+		m, errno := syscall.Splice(int(fd), nil, p.fds[1], nil, n, spliceMove|spliceNonblock)
+		if errno == syscall.EAGAIN {
+			// The runtime poller waits for src to be readable.
+			return false
+		}
+		piped, err = int(m), errno
+		return true
+	})
+	if rerr != nil {
+		return 0, rerr
+	} else if err == nil && piped <= 0 {
+		return 0, io.EOF
+	}
+	return piped, err
+}
+
+func (p *kernelPipe) drain(fd int, n int) (int, error) {
+	written, err := syscall.Splice(p.fds[0], nil, fd, nil, n, spliceMove|spliceNonblock)
+	return int(written), err
+}
+
+func (p *kernelPipe) close() {
+	syscall.Close(p.fds[0])
+	syscall.Close(p.fds[1])
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, size int) (*os.File, []byte) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, data
+}
+
+// testTransferServer serves the connections with serve, called with the
+// byte each client writes.
+func testTransferServer(t *testing.T, serve func(conn net.Conn, b byte)) (done func()) {
+	handler := NewHandler(func(conn net.Conn) (Context, error) {
+		return conn, nil
+	}, func(ctx Context) error {
+		conn := ctx.(net.Conn)
+		buf := make([]byte, 1)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			serve(conn, buf[0])
+		}
+		return nil
+	})
+	server := &Server{Network: "tcp", Address: "127.0.0.1:9997", Handler: handler}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	return func() {
+		server.Close()
+		wg.Wait()
+	}
+}
+
+func TestConnSendFile(t *testing.T) {
+	f, data := writeTestFile(t, 8<<20)
+	transfers := make(chan *Transfer, 4)
+	var progress int64
+	done := testTransferServer(t, func(conn net.Conn, b byte) {
+		switch b {
+		case 'f':
+			conn.Write([]byte("head:"))
+			tr, err := SendFile(conn, f, 10, 0, func(written int64) {
+				if written < atomic.LoadInt64(&progress) {
+					t.Error(written)
+				}
+				atomic.StoreInt64(&progress, written)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			conn.Write([]byte(":tail"))
+			transfers <- tr
+		case 'n':
+			tr, _ := SendFile(conn, f, 3, 5, nil)
+			transfers <- tr
+		}
+	})
+	defer done()
+	conn, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("f"))
+	// The transfer waits for the socket to be writable.
+	time.Sleep(time.Millisecond * 50)
+	want := append(append([]byte("head:"), data[10:]...), ":tail"...)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("mismatch")
+	}
+	tr := <-transfers
+	if n, err := tr.Wait(); n != int64(len(data)-10) || err != nil {
+		t.Error(n, err)
+	}
+	if atomic.LoadInt64(&progress) != int64(len(data)-10) {
+		t.Error(atomic.LoadInt64(&progress))
+	}
+	conn.Write([]byte("n"))
+	got = got[:5]
+	io.ReadFull(conn, got)
+	if !bytes.Equal(got, data[3:8]) {
+		t.Error(got)
+	}
+	if n, err := (<-transfers).Wait(); n != 5 || err != nil {
+		t.Error(n, err)
+	}
+}
+
+func TestConnSendFileCancel(t *testing.T) {
+	f, data := writeTestFile(t, 32<<20)
+	transfers := make(chan *Transfer, 2)
+	var resumed int64
+	done := testTransferServer(t, func(conn net.Conn, b byte) {
+		switch b {
+		case 's':
+			tr, _ := SendFile(conn, f, 0, 0, nil)
+			transfers <- tr
+		case 'r':
+			// Resumes the canceled transfer.
+			off := atomic.LoadInt64(&resumed)
+			tr, _ := SendFile(conn, f, off, 0, nil)
+			transfers <- tr
+		case 'c':
+			tr, _ := SendFile(conn, f, 0, 0, nil)
+			transfers <- tr
+			conn.Close()
+		}
+	})
+	defer done()
+	conn, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("s"))
+	tr := <-transfers
+	// The client reads nothing, the transfer stops once the socket buffers
+	// are full.
+	time.Sleep(time.Millisecond * 100)
+	tr.Cancel()
+	n, err := tr.Wait()
+	if err != ErrTransferCanceled || n <= 0 || n >= int64(len(data)) {
+		t.Fatal(n, err)
+	}
+	atomic.StoreInt64(&resumed, n)
+	conn.Write([]byte("r"))
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("mismatch")
+	}
+	if m, err := (<-transfers).Wait(); m != int64(len(data))-n || err != nil {
+		t.Error(m, err)
+	}
+
+	conn.Write([]byte("c"))
+	if _, err := (<-transfers).Wait(); err != EOF {
+		t.Error(err)
+	}
+}
+
+func TestConnSplice(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	go func() {
+		for {
+			src, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			src.Write(data)
+			src.Close()
+		}
+	}()
+	transfers := make(chan *Transfer, 2)
+	done := testTransferServer(t, func(conn net.Conn, b byte) {
+		src, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		n := int64(0)
+		if b == 'u' {
+			n = int64(len(data)) + 1
+		}
+		tr, err := Splice(conn, src, n, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		go func() {
+			tr.Wait()
+			src.Close()
+		}()
+		conn.Write([]byte("end"))
+		transfers <- tr
+	})
+	defer done()
+	conn, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("s"))
+	want := append(append([]byte{}, data...), "end"...)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("mismatch")
+	}
+	if n, err := (<-transfers).Wait(); n != int64(len(data)) || err != nil {
+		t.Error(n, err)
+	}
+	conn.Write([]byte("u"))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := (<-transfers).Wait(); n != int64(len(data)) || err != io.ErrUnexpectedEOF {
+		t.Error(n, err)
+	}
+}
+
+func TestTransferFallback(t *testing.T) {
+	f, data := writeTestFile(t, 1<<20)
+	r, w := net.Pipe()
+	defer r.Close()
+	var progress int64
+	tr, err := SendFile(w, f, 0, 0, func(written int64) {
+		atomic.StoreInt64(&progress, written)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := tr.Wait(); n != int64(len(data)) || err != nil || !bytes.Equal(got, data) {
+		t.Error(n, err)
+	}
+	if atomic.LoadInt64(&progress) != int64(len(data)) {
+		t.Error(progress)
+	}
+	src, dst := net.Pipe()
+	go func() {
+		dst.Write([]byte("spliced"))
+		dst.Close()
+	}()
+	tr, _ = Splice(w, src, 0, nil)
+	got = got[:7]
+	io.ReadFull(r, got)
+	if n, err := tr.Wait(); n != 7 || err != nil || string(got) != "spliced" {
+		t.Error(n, err, string(got))
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/hslam/buffer"
+)
+
+// maxSendfileSize is the largest chunk sent by a sendfile call.
+const maxSendfileSize = 4 << 20
+
+// SendFile implements the Sender SendFile method. The file is sent with
+// sendfile as the socket becomes writable, resuming the partial sends.
+func (c *conn) SendFile(f *os.File, off, n int64, progress func(written int64)) (*Transfer, error) {
+	r, err := fileReader(f, off, n)
+	if err != nil {
+		return nil, err
+	}
+	t := newTransfer(progress)
+	if atomic.LoadInt32(&c.ready) == 0 {
+		// The connection is blocking until it is upgraded.
+		go t.copy(c, r, r.Size())
+		return t, nil
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	raw.Control(func(s uintptr) {
+		fd = int(s)
+	})
+	t.source = &fileSource{fd: fd, off: off, remain: r.Size()}
+	return t, c.transfer(t)
+}
+
+// Splice implements the Sender Splice method. The data read from src is
+// moved through a pipe, with splice when src is a *net.TCPConn on Linux, and
+// sent as the socket becomes writable. src is not read again until the data
+// read is sent.
+func (c *conn) Splice(src net.Conn, n int64, progress func(written int64)) (*Transfer, error) {
+	t := newTransfer(progress)
+	if atomic.LoadInt32(&c.ready) == 0 {
+		// The connection is blocking until it is upgraded.
+		go t.copy(c, limitReader(src, n), n)
+		return t, nil
+	}
+	s, err := newSplicer(src, n, c.pollWrite)
+	if err != nil {
+		return nil, err
+	}
+	t.source = s
+	if err = c.transfer(t); err != nil {
+		s.close()
+	}
+	return t, err
+}
+
+// transfer queues the transfer after the data buffered for writing.
+func (c *conn) transfer(t *Transfer) error {
+	// Close fails the queued transfers once it sees transferring.
+	atomic.AddInt32(&c.transferring, 1)
+	c.wlock.Lock()
+	if atomic.LoadInt32(&c.closed) != 0 {
+		c.wlock.Unlock()
+		atomic.AddInt32(&c.transferring, -1)
+		return EOF
+	}
+	t.pos, t.cancel = len(c.wbuf), c.cancelTransfer
+	c.transfers = append(c.transfers, t)
+	c.wlock.Unlock()
+	c.pollWrite()
+	return nil
+}
+
+// sendTransfers sends the transfers queued, along with the data buffered
+// before them. It returns the number of bytes of the buffer written and
+// whether the socket is not writable. c.wlock must be held.
+func (c *conn) sendTransfers() (written int, blocked bool, sent, finished []*Transfer) {
+	for {
+		end := len(c.wbuf)
+		if len(c.transfers) > 0 {
+			end = c.transfers[0].pos
+		}
+		for written < end {
+			var n int
+			if c.wop != nil {
+				n = c.sent(end - written)
+			} else {
+				n, _ = syscall.Write(c.fd, c.wbuf[written:end])
+			}
+			if n <= 0 {
+				break
+			}
+			written += n
+		}
+		if written < end {
+			return written, true, sent, finished
+		} else if len(c.transfers) == 0 {
+			return written, false, sent, finished
+		}
+		t := c.transfers[0]
+		t.pos = written
+		n, err := t.send(c.fd)
+		if n > 0 {
+			sent = append(sent, t)
+		}
+		if err == syscall.EAGAIN {
+			return written, true, sent, finished
+		} else if err == errWaiting {
+			return written, false, sent, finished
+		}
+		t.err = err
+		finished = append(finished, t)
+		if c.transfers = c.transfers[1:]; len(c.transfers) == 0 {
+			c.transfers = nil
+		}
+		atomic.AddInt32(&c.transferring, -1)
+	}
+}
+
+// cancelTransfer removes a canceled transfer from the queue, without
+// waiting for the socket to be writable.
+func (c *conn) cancelTransfer(t *Transfer) {
+	c.wlock.Lock()
+	for i := range c.transfers {
+		if c.transfers[i] == t {
+			c.transfers = append(c.transfers[:i], c.transfers[i+1:]...)
+			atomic.AddInt32(&c.transferring, -1)
+			c.wlock.Unlock()
+			t.finish(ErrTransferCanceled)
+			// The data buffered after the transfer is flushed.
+			c.pollWrite()
+			return
+		}
+	}
+	c.wlock.Unlock()
+}
+
+// closeTransfers fails the transfers queued when the socket is closed.
+func (c *conn) closeTransfers() {
+	c.wlock.Lock()
+	transfers := c.transfers
+	c.transfers = nil
+	atomic.AddInt32(&c.transferring, -int32(len(transfers)))
+	c.wlock.Unlock()
+	for _, t := range transfers {
+		t.finish(EOF)
+	}
+}
+
+// finishTransfers reports the progress of the transfers sent, and finishes
+// the transfers done, outside the wlock.
+func finishTransfers(sent, finished []*Transfer) {
+	for _, t := range sent {
+		t.report()
+	}
+	for _, t := range finished {
+		t.finish(t.err)
+	}
+}
+
+// pollWrite makes the worker flush once the socket is writable.
+func (c *conn) pollWrite() {
+	c.lock.Lock()
+	if c.w != nil {
+		c.w.poll.Write(c.fd)
+	}
+	c.lock.Unlock()
+}
+
+// fileSource is a file sent with sendfile.
+type fileSource struct {
+	fd     int
+	off    int64
+	remain int64
+}
+
+// send implements the source send method.
+func (s *fileSource) send(fd int) (written int64, err error) {
+	for s.remain > 0 {
+		size := maxSendfileSize
+		if s.remain < int64(size) {
+			size = int(s.remain)
+		}
+		pos := s.off
+		n, err := syscall.Sendfile(fd, s.fd, &pos, size)
+		if err == syscall.ENOSYS || err == syscall.EINVAL || err == syscall.EOPNOTSUPP {
+			// The file is read into a buffer without sendfile.
+			n, err = s.write(fd, size)
+		}
+		if n > 0 {
+			s.off += int64(n)
+			s.remain -= int64(n)
+			written += int64(n)
+			continue
+		}
+		if err == syscall.EINTR {
+			continue
+		} else if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return written, err
+	}
+	return written, nil
+}
+
+// write writes the file from a buffer, which is read again if the socket
+// does not accept all of it.
+func (s *fileSource) write(fd int, size int) (int, error) {
+	if size > bufferSize {
+		size = bufferSize
+	}
+	buf := buffer.GetBuffer(size)
+	defer buffer.PutBuffer(buf)
+	n, err := syscall.Pread(s.fd, buf[:size], s.off)
+	if n <= 0 {
+		return 0, err
+	}
+	return syscall.Write(fd, buf[:n])
+}
+
+// close implements the source close method.
+func (s *fileSource) close() {}
+
+// splicer fills a pipe with the data read from a connection in its own
+// goroutine, while the worker loop drains it to the socket.
+type splicer struct {
+	lock    sync.Mutex
+	pipe    pipe
+	src     net.Conn
+	remain  int64
+	piped   int
+	filling bool
+	eof     bool
+	err     error
+	closed  bool
+	refs    int
+	// fill is signaled when the pipe is drained.
+	fill chan struct{}
+	wake func()
+}
+
+// pipe holds the data read from a connection until it is sent.
+type pipe interface {
+	fill(src net.Conn, n int) (int, error)
+	drain(fd int, n int) (int, error)
+	close()
+}
+
+func newSplicer(src net.Conn, n int64, wake func()) (*splicer, error) {
+	p, err := newPipe(src)
+	if err != nil {
+		return nil, err
+	}
+	s := &splicer{pipe: p, src: src, remain: n, filling: true, refs: 2, fill: make(chan struct{}, 1), wake: wake}
+	s.fill <- struct{}{}
+	go s.run()
+	return s, nil
+}
+
+// run fills the pipe each time it is drained, until the end of src.
+func (s *splicer) run() {
+	defer s.release()
+	for range s.fill {
+		size := bufferSize
+		if s.remain > 0 && s.remain < int64(size) {
+			size = int(s.remain)
+		}
+		n, err := s.pipe.fill(s.src, size)
+		s.lock.Lock()
+		if n > 0 {
+			s.piped = n
+			if s.remain > 0 {
+				if s.remain -= int64(n); s.remain == 0 {
+					s.eof = true
+				}
+			}
+		}
+		if err != nil && !s.eof {
+			s.eof = true
+			if err != io.EOF {
+				s.err = err
+			} else if s.remain > 0 {
+				s.err = io.ErrUnexpectedEOF
+			}
+		}
+		s.filling = false
+		eof, closed := s.eof, s.closed
+		s.lock.Unlock()
+		if closed {
+			return
+		}
+		s.wake()
+		if eof {
+			return
+		}
+	}
+}
+
+// send implements the source send method.
+func (s *splicer) send(fd int) (written int64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.piped > 0 {
+		n, err := s.pipe.drain(fd, s.piped)
+		if n > 0 {
+			s.piped -= n
+			written += int64(n)
+			continue
+		}
+		if err == syscall.EINTR {
+			continue
+		} else if err == nil {
+			err = io.ErrShortWrite
+		}
+		return written, err
+	}
+	if s.eof {
+		return written, s.err
+	} else if !s.filling {
+		s.filling = true
+		s.fill <- struct{}{}
+	}
+	return written, errWaiting
+}
+
+// close implements the source close method.
+func (s *splicer) close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.fill)
+	s.lock.Unlock()
+	s.release()
+}
+
+// release closes the pipe once both the goroutine filling it and the
+// Transfer are done with it.
+func (s *splicer) release() {
+	s.lock.Lock()
+	s.refs--
+	refs := s.refs
+	s.lock.Unlock()
+	if refs == 0 {
+		s.pipe.close()
+	}
+}
+
+// bufferPipe is a pipe in user space.
+type bufferPipe struct {
+	buf []byte
+	pos int
+}
+
+func (p *bufferPipe) fill(src net.Conn, n int) (int, error) {
+	if p.buf == nil {
+		p.buf = buffer.GetBuffer(bufferSize)
+	}
+	p.pos = 0
+	return src.Read(p.buf[:n])
+}
+
+func (p *bufferPipe) drain(fd int, n int) (int, error) {
+	written, err := syscall.Write(fd, p.buf[p.pos:p.pos+n])
+	if written > 0 {
+		p.pos += written
+	}
+	return written, err
+}
+
+func (p *bufferPipe) close() {
+	if p.buf != nil {
+		buffer.PutBuffer(p.buf)
+		p.buf = nil
+	}
+}
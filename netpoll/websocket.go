@@ -0,0 +1,512 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hslam/buffer"
+)
+
+const (
+	// DefaultMaxMessageSize is the default WebSocketHandler MaxMessageSize.
+	DefaultMaxMessageSize = 0x400000
+	// defaultWebSocketHandshakeTimeout is the default
+	// WebSocketHandler HandshakeTimeout.
+	defaultWebSocketHandshakeTimeout = 10 * time.Second
+	// maxControlPayload is the max payload size of a control frame.
+	maxControlPayload = 125
+	websocketGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// ErrOnMessage is the error when the OnMessage func is nil
+var ErrOnMessage = errors.New("OnMessage function must be not nil")
+
+// ErrWebSocketClosed is the error when a message is written to a closed
+// WebSocket connection.
+var ErrWebSocketClosed = errors.New("WebSocket closed")
+
+// ErrMessageType is the error when the type of a message written is
+// neither TEXT nor BINARY.
+var ErrMessageType = errors.New("invalid WebSocket message type")
+
+// ErrHandshake is the error when the WebSocket opening handshake fails.
+var ErrHandshake = errors.New("bad WebSocket handshake")
+
+// MessageType is the type of a WebSocket message.
+type MessageType int
+
+const (
+	// TEXT is a message of UTF-8 encoded text.
+	TEXT MessageType = 1
+	// BINARY is a message of binary data.
+	BINARY MessageType = 2
+)
+
+// The close codes defined by RFC 6455.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseAbnormalClosure  = 1006
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// The opcodes of the frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// closeError closes a WebSocket connection with a close code.
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("WebSocket close %d %s", e.code, e.reason)
+}
+
+// WebSocketHandler implements the Handler interface, serving RFC 6455
+// WebSocket connections.
+//
+// The opening handshake completes in Upgrade, on the upgrade goroutine.
+// Then the frames are parsed as they arrive, into the buffers of the Pool,
+// and the complete messages are dispatched to OnMessage on the worker loop.
+// Pings are answered with pongs and the close handshake is completed. An
+// idle connection holds no buffer.
+type WebSocketHandler struct {
+	// Pool provides the buffers the frames are read into. The buffers of
+	// a package pool are used if nil.
+	Pool BytePool
+	// CheckOrigin accepts the handshake request. The requests are accepted
+	// if nil.
+	CheckOrigin func(r *http.Request) bool
+	// Subprotocols are the subprotocols supported, in order of preference.
+	Subprotocols []string
+	// HandshakeTimeout is the maximum amount of time the opening handshake
+	// may take. Zero means 10 seconds.
+	HandshakeTimeout time.Duration
+	// MaxMessageSize is the max size of a message, DefaultMaxMessageSize
+	// by default.
+	MaxMessageSize int
+	// OnOpen is called when the handshake completes, if not nil.
+	OnOpen func(conn *WebSocketConn)
+	// OnMessage is called with each complete message. The data is only
+	// valid until OnMessage returns.
+	OnMessage func(conn *WebSocketConn, messageType MessageType, data []byte)
+	// OnPong is called with the payload of each pong, if not nil.
+	OnPong func(conn *WebSocketConn, data []byte)
+	// OnClose is called once with the close code and reason received, or
+	// the close code sent when the connection fails, if not nil.
+	OnClose func(conn *WebSocketConn, code int, reason string)
+}
+
+// WebSocketConn is a WebSocket connection.
+type WebSocketConn struct {
+	handler *WebSocketHandler
+	conn    net.Conn
+	request *http.Request
+	// Subprotocol is the subprotocol selected by the handshake.
+	Subprotocol string
+	// reading guards the partial frame and message.
+	reading sync.Mutex
+	buffer  []byte
+	// message holds the fragments of a message, whose type is messageType.
+	message     []byte
+	messageType MessageType
+	fragmented  bool
+	// done is set once OnClose is called.
+	done bool
+	// writing guards the writes, closing is set once a close frame is sent.
+	writing sync.Mutex
+	closing bool
+}
+
+// Upgrade implements the Handler Upgrade method, completing the opening
+// handshake.
+func (h *WebSocketHandler) Upgrade(conn net.Conn) (Context, error) {
+	if h.OnMessage == nil {
+		return nil, ErrOnMessage
+	}
+	timeout := h.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultWebSocketHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &WebSocketConn{handler: h, conn: conn, request: req}
+	if err := c.handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	if h.OnOpen != nil {
+		h.OnOpen(c)
+	}
+	if n := r.Buffered(); n > 0 {
+		// The frames sent along with the handshake request are served
+		// before the connection is registered.
+		data, _ := r.Peek(n)
+		if err := c.parse(append([]byte{}, data...)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// handshake validates the handshake request and writes the response.
+func (c *WebSocketConn) handshake() error {
+	req, h := c.request, c.handler
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || !req.ProtoAtLeast(1, 1) ||
+		!hasToken(req.Header.Get("Connection"), "upgrade") ||
+		!hasToken(req.Header.Get("Upgrade"), "websocket") || key == "" {
+		c.reject(http.StatusBadRequest, "")
+		return ErrHandshake
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.reject(http.StatusUpgradeRequired, "Sec-WebSocket-Version: 13\r\n")
+		return ErrHandshake
+	}
+	if h.CheckOrigin != nil && !h.CheckOrigin(req) {
+		c.reject(http.StatusForbidden, "")
+		return ErrHandshake
+	}
+	for _, protocol := range h.Subprotocols {
+		if hasToken(strings.Join(req.Header.Values("Sec-WebSocket-Protocol"), ","), protocol) {
+			c.Subprotocol = protocol
+			break
+		}
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	res := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if c.Subprotocol != "" {
+		res += "Sec-WebSocket-Protocol: " + c.Subprotocol + "\r\n"
+	}
+	_, err := c.conn.Write([]byte(res + "\r\n"))
+	return err
+}
+
+// reject writes the response to a handshake request that fails.
+func (c *WebSocketConn) reject(code int, header string) {
+	text := http.StatusText(code)
+	fmt.Fprintf(c.conn, "HTTP/1.1 %d %s\r\n%sContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		code, text, header, len(text), text)
+}
+
+// Request returns the handshake request.
+func (c *WebSocketConn) Request() *http.Request {
+	return c.request
+}
+
+// NetConn returns the connection below.
+func (c *WebSocketConn) NetConn() net.Conn {
+	return c.conn
+}
+
+// RemoteAddr returns the remote network address.
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// WriteMessage writes a message. It is safe for concurrent use.
+func (c *WebSocketConn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TEXT && messageType != BINARY {
+		return ErrMessageType
+	}
+	return c.writeFrame(byte(messageType), data)
+}
+
+// Ping writes a ping with the payload data.
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrFrameTooLarge
+	}
+	return c.writeFrame(opPing, data)
+}
+
+// CloseWithCode starts the close handshake with the close code and reason.
+// The connection is closed when the peer replies.
+func (c *WebSocketConn) CloseWithCode(code int, reason string) error {
+	return c.writeClose(code, reason)
+}
+
+// Close closes the connection without the close handshake.
+func (c *WebSocketConn) Close() error {
+	c.writing.Lock()
+	c.closing = true
+	c.writing.Unlock()
+	return c.conn.Close()
+}
+
+func (c *WebSocketConn) writeClose(code int, reason string) error {
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(opClose, payload)
+}
+
+// writeFrame writes an unmasked final frame.
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	if c.closing {
+		return ErrWebSocketClosed
+	}
+	c.closing = opcode == opClose
+	buf := buffer.GetBuffer(len(payload) + 10)[:0]
+	defer buffer.PutBuffer(buf)
+	buf = append(buf, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	buf = append(buf, payload...)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Serve implements the Handler Serve method.
+func (h *WebSocketHandler) Serve(ctx Context) error {
+	c := ctx.(*WebSocketConn)
+	c.reading.Lock()
+	defer c.reading.Unlock()
+	var buf []byte
+	if h.Pool != nil {
+		buf = h.Pool.Get()
+		defer h.Pool.Put(buf)
+	} else {
+		buf = buffer.GetBuffer(bufferSize)
+		defer buffer.PutBuffer(buf)
+	}
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return c.fail(err)
+	}
+	return c.parse(buf[:n])
+}
+
+// parse serves the complete frames of the data, and keeps the rest until
+// more data arrives.
+func (c *WebSocketConn) parse(data []byte) error {
+	if len(c.buffer) > 0 {
+		c.buffer = append(c.buffer, data...)
+		data = c.buffer
+	}
+	for len(data) > 0 {
+		advance, err := c.readFrame(data)
+		if err != nil {
+			c.buffer = nil
+			return c.fail(err)
+		} else if advance == 0 {
+			break
+		}
+		data = data[advance:]
+	}
+	if len(data) == 0 {
+		// An idle connection holds no buffer.
+		c.buffer = nil
+	} else {
+		c.buffer = append(c.buffer[:0], data...)
+	}
+	return nil
+}
+
+// readFrame serves the frame at the start of the data, and returns the
+// number of bytes it takes, zero when the data is not complete.
+func (c *WebSocketConn) readFrame(data []byte) (advance int, err error) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+	fin, opcode := data[0]&0x80 != 0, data[0]&0x0f
+	if data[0]&0x70 != 0 {
+		return 0, &closeError{CloseProtocolError, "reserved bits set"}
+	} else if data[1]&0x80 == 0 {
+		return 0, &closeError{CloseProtocolError, "unmasked frame"}
+	}
+	size, pos := uint64(data[1]&0x7f), 2
+	switch size {
+	case 126:
+		if len(data) < 4 {
+			return 0, nil
+		}
+		size, pos = uint64(binary.BigEndian.Uint16(data[2:])), 4
+	case 127:
+		if len(data) < 10 {
+			return 0, nil
+		}
+		size, pos = binary.BigEndian.Uint64(data[2:]), 10
+	}
+	max := c.handler.MaxMessageSize
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	if opcode >= opClose && (!fin || size > maxControlPayload) {
+		return 0, &closeError{CloseProtocolError, "invalid control frame"}
+	} else if size > uint64(max-len(c.message)) {
+		return 0, &closeError{CloseMessageTooBig, "message too big"}
+	}
+	if len(data) < pos+4+int(size) {
+		return 0, nil
+	}
+	mask := data[pos : pos+4]
+	payload := data[pos+4 : pos+4+int(size)]
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return pos + 4 + int(size), c.serveFrame(fin, opcode, payload)
+}
+
+// serveFrame serves the unmasked payload of a frame.
+func (c *WebSocketConn) serveFrame(fin bool, opcode byte, payload []byte) error {
+	h := c.handler
+	switch opcode {
+	case opPing:
+		c.writeFrame(opPong, payload)
+		return nil
+	case opPong:
+		if h.OnPong != nil {
+			h.OnPong(c, payload)
+		}
+		return nil
+	case opClose:
+		code, reason := CloseNoStatusReceived, ""
+		if len(payload) == 1 {
+			return &closeError{CloseProtocolError, "invalid close payload"}
+		} else if len(payload) >= 2 {
+			code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			if !validCloseCode(code) {
+				return &closeError{CloseProtocolError, "invalid close code"}
+			} else if !utf8.ValidString(reason) {
+				return &closeError{CloseInvalidPayload, "invalid close reason"}
+			}
+		}
+		c.writeClose(code, "")
+		c.done = true
+		if h.OnClose != nil {
+			h.OnClose(c, code, reason)
+		}
+		return EOF
+	case opText, opBinary:
+		if c.fragmented {
+			return &closeError{CloseProtocolError, "unfinished fragmented message"}
+		}
+		if !fin {
+			c.fragmented, c.messageType = true, MessageType(opcode)
+			c.message = c.getBuffer(len(payload))
+			c.message = append(c.message, payload...)
+			return nil
+		}
+		return c.dispatch(MessageType(opcode), payload)
+	case opContinuation:
+		if !c.fragmented {
+			return &closeError{CloseProtocolError, "unexpected continuation frame"}
+		}
+		c.message = append(c.message, payload...)
+		if !fin {
+			return nil
+		}
+		message := c.message
+		c.fragmented, c.message = false, nil
+		err := c.dispatch(c.messageType, message)
+		c.putBuffer(message)
+		return err
+	}
+	return &closeError{CloseProtocolError, "unknown opcode"}
+}
+
+// dispatch calls OnMessage with a complete message.
+func (c *WebSocketConn) dispatch(messageType MessageType, data []byte) error {
+	if messageType == TEXT && !utf8.Valid(data) {
+		return &closeError{CloseInvalidPayload, "invalid UTF-8 text"}
+	}
+	c.handler.OnMessage(c, messageType, data)
+	return nil
+}
+
+// fail closes the connection with the close code of the error. A connection
+// closed without the close handshake is reported with CloseAbnormalClosure.
+func (c *WebSocketConn) fail(err error) error {
+	if err == EAGAIN {
+		return err
+	}
+	if c.fragmented {
+		c.putBuffer(c.message)
+		c.fragmented, c.message = false, nil
+	}
+	if err == EOF && c.done {
+		return EOF
+	}
+	code, reason := CloseAbnormalClosure, ""
+	if e, ok := err.(*closeError); ok {
+		code, reason = e.code, e.reason
+		c.writeClose(code, reason)
+	}
+	c.done = true
+	if h := c.handler; h.OnClose != nil {
+		h.OnClose(c, code, reason)
+	}
+	return EOF
+}
+
+func (c *WebSocketConn) getBuffer(size int) []byte {
+	if c.handler.Pool != nil && size <= c.handler.Pool.Width() {
+		return c.handler.Pool.Get()[:0]
+	}
+	return buffer.GetBuffer(size)[:0]
+}
+
+func (c *WebSocketConn) putBuffer(buf []byte) {
+	if c.handler.Pool != nil && cap(buf) == c.handler.Pool.Width() {
+		c.handler.Pool.Put(buf[:cap(buf)])
+		return
+	}
+	buffer.PutBuffer(buf)
+}
+
+// validCloseCode reports whether the close code may be received.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
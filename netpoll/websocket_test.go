@@ -0,0 +1,280 @@
+// Copyright (c) 2020 Meng Huang (mhboy@outlook.com)
+// This package is licensed under a MIT license that can be found in the LICENSE file.
+
+package netpoll
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oxtoacart/bpool"
+)
+
+func testWebSocketServer(t *testing.T, handler *WebSocketHandler) (done func()) {
+	server := &Server{
+		Network: "tcp",
+		Address: "127.0.0.1:9997",
+		Handler: handler,
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.ListenAndServe(); err != ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 20)
+	return func() {
+		server.Close()
+		wg.Wait()
+	}
+}
+
+// dialWebSocket completes the opening handshake, writing the frames along
+// with the handshake request.
+func dialWebSocket(t *testing.T, header string, frames ...[]byte) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", "127.0.0.1:9997")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	req := "GET /push HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + header + "\r\n"
+	conn.Write(append([]byte(req), bytes.Join(frames, nil)...))
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, res
+}
+
+// maskedFrame returns a frame a client sends.
+func maskedFrame(b0 byte, payload []byte) []byte {
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+	return frame
+}
+
+func closePayload(code int, reason string) []byte {
+	return append([]byte{byte(code >> 8), byte(code)}, reason...)
+}
+
+func readFrame(t *testing.T, r *bufio.Reader) (b0 byte, payload []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("masked server frame")
+	}
+	size := int(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		size = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0], payload
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	type closed struct {
+		code   int
+		reason string
+	}
+	closes := make(chan closed, 8)
+	pongs := make(chan string, 8)
+	handler := &WebSocketHandler{
+		Pool:           bpool.NewBytePool(64, 1024),
+		Subprotocols:   []string{"chat", "push"},
+		MaxMessageSize: 0x20000,
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") != "http://evil.example"
+		},
+		OnMessage: func(conn *WebSocketConn, messageType MessageType, data []byte) {
+			conn.WriteMessage(messageType, data)
+		},
+		OnPong: func(conn *WebSocketConn, data []byte) {
+			pongs <- string(data)
+		},
+		OnClose: func(conn *WebSocketConn, code int, reason string) {
+			closes <- closed{code, reason}
+		},
+	}
+	done := testWebSocketServer(t, handler)
+	defer done()
+
+	conn, r, res := dialWebSocket(t, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: push, chat\r\n",
+		maskedFrame(0x81, []byte("early")))
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		res.Header.Get("Sec-WebSocket-Protocol") != "chat" {
+		t.Fatal(res.Status, res.Header)
+	}
+	if b0, payload := readFrame(t, r); b0 != 0x81 || string(payload) != "early" {
+		t.Error(b0, string(payload))
+	}
+	// A message split across frames and writes.
+	big := bytes.Repeat([]byte("0123456789"), 0x1000)
+	frame := maskedFrame(0x82, big)
+	conn.Write(frame[:100])
+	time.Sleep(time.Millisecond * 10)
+	conn.Write(frame[100:])
+	if b0, payload := readFrame(t, r); b0 != 0x82 || !bytes.Equal(payload, big) {
+		t.Error(b0, len(payload))
+	}
+	// A fragmented message with a ping in between.
+	conn.Write(bytes.Join([][]byte{
+		maskedFrame(0x01, []byte("Hello ")),
+		maskedFrame(0x89, []byte("ping")),
+		maskedFrame(0x00, big),
+		maskedFrame(0x80, []byte(" World")),
+	}, nil))
+	if b0, payload := readFrame(t, r); b0 != 0x8a || string(payload) != "ping" {
+		t.Error(b0, string(payload))
+	}
+	want := "Hello " + string(big) + " World"
+	if b0, payload := readFrame(t, r); b0 != 0x81 || string(payload) != want {
+		t.Error(b0, len(payload))
+	}
+	conn.Write(maskedFrame(0x8a, []byte("pong")))
+	if pong := <-pongs; pong != "pong" {
+		t.Error(pong)
+	}
+	conn.Write(maskedFrame(0x88, closePayload(CloseGoingAway, "bye")))
+	if b0, payload := readFrame(t, r); b0 != 0x88 || !bytes.Equal(payload, closePayload(CloseGoingAway, "")) {
+		t.Error(b0, payload)
+	}
+	if c := <-closes; c.code != CloseGoingAway || c.reason != "bye" {
+		t.Error(c)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Error(err)
+	}
+	conn.Close()
+
+	var protocolErrors = []struct {
+		frame []byte
+		code  int
+	}{
+		{[]byte{0x81, 0x01, 'a'}, CloseProtocolError},
+		{maskedFrame(0xc1, []byte("rsv")), CloseProtocolError},
+		{maskedFrame(0x09, []byte("ping")), CloseProtocolError},
+		{maskedFrame(0x80, []byte("cont")), CloseProtocolError},
+		{maskedFrame(0x83, nil), CloseProtocolError},
+		{maskedFrame(0x88, closePayload(1004, "")), CloseProtocolError},
+		{maskedFrame(0x81, []byte{0xff, 0xfe}), CloseInvalidPayload},
+		{maskedFrame(0x82, make([]byte, 0x20001)), CloseMessageTooBig},
+	}
+	for i, e := range protocolErrors {
+		conn, r, _ := dialWebSocket(t, "Sec-WebSocket-Version: 13\r\n")
+		conn.Write(e.frame)
+		if b0, payload := readFrame(t, r); b0 != 0x88 || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != e.code {
+			t.Error(i, b0, payload)
+		}
+		if c := <-closes; c.code != e.code {
+			t.Error(i, c)
+		}
+		// The frame left unread may reset the connection.
+		if _, err := r.ReadByte(); err == nil {
+			t.Error(i)
+		}
+		conn.Close()
+	}
+
+	// A connection closed without the close handshake.
+	conn, _, _ = dialWebSocket(t, "Sec-WebSocket-Version: 13\r\n")
+	conn.Close()
+	if c := <-closes; c.code != CloseAbnormalClosure {
+		t.Error(c)
+	}
+
+	var rejects = []struct {
+		header string
+		status int
+	}{
+		{"Sec-WebSocket-Version: 8\r\n", http.StatusUpgradeRequired},
+		{"Sec-WebSocket-Version: 13\r\nOrigin: http://evil.example\r\n", http.StatusForbidden},
+	}
+	for _, e := range rejects {
+		conn, _, res := dialWebSocket(t, e.header)
+		if res.StatusCode != e.status {
+			t.Error(res.Status)
+		}
+		if e.status == http.StatusUpgradeRequired && res.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Error(res.Header)
+		}
+		conn.Close()
+	}
+}
+
+func TestWebSocketConnWrite(t *testing.T) {
+	opened := make(chan *WebSocketConn, 1)
+	handler := &WebSocketHandler{
+		OnOpen: func(conn *WebSocketConn) {
+			opened <- conn
+		},
+		OnMessage: func(conn *WebSocketConn, messageType MessageType, data []byte) {},
+	}
+	done := testWebSocketServer(t, handler)
+	defer done()
+	conn, r, _ := dialWebSocket(t, "Sec-WebSocket-Version: 13\r\n")
+	defer conn.Close()
+	c := <-opened
+	if c.Request().URL.Path != "/push" {
+		t.Error(c.Request().URL)
+	}
+	if err := c.WriteMessage(MessageType(opPing), nil); err != ErrMessageType {
+		t.Error(err)
+	}
+	if err := c.Ping(make([]byte, 126)); err != ErrFrameTooLarge {
+		t.Error(err)
+	}
+	c.Ping([]byte("hi"))
+	if b0, payload := readFrame(t, r); b0 != 0x89 || string(payload) != "hi" {
+		t.Error(b0, payload)
+	}
+	c.CloseWithCode(CloseNormalClosure, "done")
+	if b0, payload := readFrame(t, r); b0 != 0x88 || !bytes.Equal(payload, closePayload(CloseNormalClosure, "done")) {
+		t.Error(b0, payload)
+	}
+	if err := c.WriteMessage(TEXT, []byte("late")); err != ErrWebSocketClosed {
+		t.Error(err)
+	}
+}
+
+func TestWebSocketHandlerOnMessage(t *testing.T) {
+	if _, err := (&WebSocketHandler{}).Upgrade(nil); err != ErrOnMessage {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+//------------------------------------------------------------------------------
+// Binary format
+//------------------------------------------------------------------------------
+//
+// A serialized trie is laid out so that it can be searched in place, see
+// MappedTrie:
+//
+//	header   "PTRI" version
+//	nodes    every node, children before their parent
+//	footer   root offset (u32), item count (u32), CRC-32C of all the above (u32)
+//
+// A node is encoded as
+//
+//	flags    byte, flagItem when the node holds an item
+//	prefix   uvarint length + bytes
+//	item     uvarint length + bytes, only with flagItem
+//	children uvarint count + count * (label byte, offset u32)
+//
+// The children table is sorted by label, which is the first byte of the child
+// prefix, so lookups are a binary search. All integers are little-endian.
+
+const (
+	binaryVersion = 1
+
+	flagItem = 1 << 0
+
+	headerSize     = 5
+	footerSize     = 12
+	childEntrySize = 5
+)
+
+var binaryMagic = []byte("PTRI")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ItemEncoder turns an item into the bytes stored in a serialized trie.
+type ItemEncoder[T any] func(item T) ([]byte, error)
+
+// MarshalBinary encodes the trie using the format read by MappedTrie. Items
+// are encoded as by WriteTo.
+func (trie *TrieOf[T]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := trie.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes the trie to w using the format read by MappedTrie. Items of
+// type []byte and string are stored as is, items implementing
+// encoding.BinaryMarshaler are stored as marshaled. Use WriteToFunc for other
+// item types.
+func (trie *TrieOf[T]) WriteTo(w io.Writer) (int64, error) {
+	return trie.WriteToFunc(w, encodeItem[T])
+}
+
+// WriteToFunc works much like WriteTo, but encodes the items using encode.
+func (trie *TrieOf[T]) WriteToFunc(w io.Writer, encode ItemEncoder[T]) (int64, error) {
+	enc := &trieEncoder[T]{
+		w:      bufio.NewWriter(w),
+		crc:    crc32.New(crcTable),
+		encode: encode,
+	}
+
+	enc.write(binaryMagic)
+	enc.write([]byte{binaryVersion})
+	root := enc.node(trie)
+
+	var footer [footerSize - 4]byte
+	binary.LittleEndian.PutUint32(footer[0:], root)
+	binary.LittleEndian.PutUint32(footer[4:], enc.items)
+	enc.write(footer[:])
+	if enc.err == nil {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], enc.crc.Sum32())
+		enc.write(sum[:])
+	}
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	return enc.n, enc.err
+}
+
+func encodeItem[T any](item T) ([]byte, error) {
+	switch v := any(item).(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	}
+	return nil, fmt.Errorf("Cannot encode item of type %T", item)
+}
+
+type trieEncoder[T any] struct {
+	w      *bufio.Writer
+	crc    hash.Hash32
+	encode ItemEncoder[T]
+	n      int64
+	items  uint32
+	err    error
+	tmp    [binary.MaxVarintLen64]byte
+}
+
+func (enc *trieEncoder[T]) write(p []byte) {
+	if enc.err != nil {
+		return
+	}
+	if enc.n+int64(len(p)) > math.MaxUint32 {
+		enc.err = ErrTrieTooLarge
+		return
+	}
+	enc.crc.Write(p)
+	n, err := enc.w.Write(p)
+	enc.n += int64(n)
+	enc.err = err
+}
+
+func (enc *trieEncoder[T]) bytes(p []byte) {
+	n := binary.PutUvarint(enc.tmp[:], uint64(len(p)))
+	enc.write(enc.tmp[:n])
+	enc.write(p)
+}
+
+// node writes the children of trie and then trie itself, returning its offset.
+func (enc *trieEncoder[T]) node(trie *TrieOf[T]) uint32 {
+	children := trie.sortedChildren()
+	table := make([]byte, 0, len(children)*childEntrySize)
+	for _, child := range children {
+		off := enc.node(child)
+		table = append(table, child.prefix[0])
+		table = binary.LittleEndian.AppendUint32(table, off)
+	}
+	if enc.err != nil {
+		return 0
+	}
+
+	off := uint32(enc.n)
+	var item []byte
+	flags := byte(0)
+	if trie.hasItem {
+		item, enc.err = enc.encode(trie.item)
+		if enc.err != nil {
+			return 0
+		}
+		flags |= flagItem
+		enc.items++
+	}
+
+	enc.write([]byte{flags})
+	enc.bytes(trie.prefix)
+	if flags&flagItem != 0 {
+		enc.bytes(item)
+	}
+	n := binary.PutUvarint(enc.tmp[:], uint64(len(children)))
+	enc.write(enc.tmp[:n])
+	enc.write(table)
+	return off
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrTrieTooLarge     = errors.New("Serialized trie exceeds 4 GiB")
+	ErrInvalidFormat    = errors.New("Invalid serialized trie")
+	ErrChecksumMismatch = errors.New("Serialized trie checksum mismatch")
+)
@@ -11,53 +11,72 @@ import (
 	"sort"
 )
 
-type childList interface {
+type childList[T any] interface {
 	length() int
-	head() *Trie
-	add(child *Trie) childList
+	head() *TrieOf[T]
+	add(child *TrieOf[T]) childList[T]
 	remove(b byte)
-	replace(b byte, child *Trie)
-	next(b byte) *Trie
-	walk(prefix *Prefix, visitor VisitorFunc) error
+	replace(b byte, child *TrieOf[T])
+	next(b byte) *TrieOf[T]
+	walk(prefix *Prefix, visitor VisitorFuncOf[T]) error
 	print(w io.Writer, indent int)
-	clone() childList
+	clone() childList[T]
+	copy() childList[T]
 	total() int
 }
 
-type tries []*Trie
+type tries[T any] []*TrieOf[T]
 
-func (t tries) Len() int {
+func (t tries[T]) Len() int {
 	return len(t)
 }
 
-func (t tries) Less(i, j int) bool {
+func (t tries[T]) Less(i, j int) bool {
 	strings := sort.StringSlice{string(t[i].prefix), string(t[j].prefix)}
 	return strings.Less(0, 1)
 }
 
-func (t tries) Swap(i, j int) {
+func (t tries[T]) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
 
-type sparseChildList struct {
-	children tries
+// sortedChildren returns the children of trie sorted by prefix, without
+// touching the child list itself.
+func (trie *TrieOf[T]) sortedChildren() tries[T] {
+	var children tries[T]
+	switch list := trie.children.(type) {
+	case *sparseChildList[T]:
+		children = append(children, list.children...)
+		sort.Sort(children)
+	case *denseChildList[T]:
+		for _, child := range list.children {
+			if child != nil {
+				children = append(children, child)
+			}
+		}
+	}
+	return children
+}
+
+type sparseChildList[T any] struct {
+	children tries[T]
 }
 
-func newSparseChildList(maxChildrenPerSparseNode int) childList {
-	return &sparseChildList{
-		children: make(tries, 0, maxChildrenPerSparseNode),
+func newSparseChildList[T any](maxChildrenPerSparseNode int) childList[T] {
+	return &sparseChildList[T]{
+		children: make(tries[T], 0, maxChildrenPerSparseNode),
 	}
 }
 
-func (list *sparseChildList) length() int {
+func (list *sparseChildList[T]) length() int {
 	return len(list.children)
 }
 
-func (list *sparseChildList) head() *Trie {
+func (list *sparseChildList[T]) head() *TrieOf[T] {
 	return list.children[0]
 }
 
-func (list *sparseChildList) add(child *Trie) childList {
+func (list *sparseChildList[T]) add(child *TrieOf[T]) childList[T] {
 	// Search for an empty spot and insert the child if possible.
 	if len(list.children) != cap(list.children) {
 		list.children = append(list.children, child)
@@ -65,10 +84,10 @@ func (list *sparseChildList) add(child *Trie) childList {
 	}
 
 	// Otherwise we have to transform to the dense list type.
-	return newDenseChildList(list, child)
+	return newDenseChildList[T](list, child)
 }
 
-func (list *sparseChildList) remove(b byte) {
+func (list *sparseChildList[T]) remove(b byte) {
 	for i, node := range list.children {
 		if node.prefix[0] == b {
 			list.children[i] = list.children[len(list.children)-1]
@@ -82,7 +101,7 @@ func (list *sparseChildList) remove(b byte) {
 	panic("removing non-existent child")
 }
 
-func (list *sparseChildList) replace(b byte, child *Trie) {
+func (list *sparseChildList[T]) replace(b byte, child *TrieOf[T]) {
 	// Make a consistency check.
 	if p0 := child.prefix[0]; p0 != b {
 		panic(fmt.Errorf("child prefix mismatch: %v != %v", p0, b))
@@ -97,7 +116,7 @@ func (list *sparseChildList) replace(b byte, child *Trie) {
 	}
 }
 
-func (list *sparseChildList) next(b byte) *Trie {
+func (list *sparseChildList[T]) next(b byte) *TrieOf[T] {
 	for _, child := range list.children {
 		if child.prefix[0] == b {
 			return child
@@ -106,13 +125,16 @@ func (list *sparseChildList) next(b byte) *Trie {
 	return nil
 }
 
-func (list *sparseChildList) walk(prefix *Prefix, visitor VisitorFunc) error {
+func (list *sparseChildList[T]) walk(prefix *Prefix, visitor VisitorFuncOf[T]) error {
 
-	sort.Sort(list.children)
+	// Lists of a SyncTrieOf are kept sorted, they must not be written here.
+	if !sort.IsSorted(list.children) {
+		sort.Sort(list.children)
+	}
 
 	for _, child := range list.children {
 		*prefix = append(*prefix, child.prefix...)
-		if child.item != nil {
+		if child.hasItem {
 			err := visitor(*prefix, child.item)
 			if err != nil {
 				if err == SkipSubtree {
@@ -134,7 +156,7 @@ func (list *sparseChildList) walk(prefix *Prefix, visitor VisitorFunc) error {
 	return nil
 }
 
-func (list *sparseChildList) total() int {
+func (list *sparseChildList[T]) total() int {
 	tot := 0
 	for _, child := range list.children {
 		if child != nil {
@@ -144,18 +166,27 @@ func (list *sparseChildList) total() int {
 	return tot
 }
 
-func (list *sparseChildList) clone() childList {
-	clones := make(tries, len(list.children), cap(list.children))
+func (list *sparseChildList[T]) clone() childList[T] {
+	clones := make(tries[T], len(list.children), cap(list.children))
 	for i, child := range list.children {
 		clones[i] = child.Clone()
 	}
 
-	return &sparseChildList{
+	return &sparseChildList[T]{
 		children: clones,
 	}
 }
 
-func (list *sparseChildList) print(w io.Writer, indent int) {
+// copy makes a copy of the list, the children are shared.
+func (list *sparseChildList[T]) copy() childList[T] {
+	children := make(tries[T], len(list.children), cap(list.children))
+	copy(children, list.children)
+	return &sparseChildList[T]{
+		children: children,
+	}
+}
+
+func (list *sparseChildList[T]) print(w io.Writer, indent int) {
 	for _, child := range list.children {
 		if child != nil {
 			child.print(w, indent)
@@ -163,15 +194,15 @@ func (list *sparseChildList) print(w io.Writer, indent int) {
 	}
 }
 
-type denseChildList struct {
+type denseChildList[T any] struct {
 	min         int
 	max         int
 	numChildren int
 	headIndex   int
-	children    []*Trie
+	children    []*TrieOf[T]
 }
 
-func newDenseChildList(list *sparseChildList, child *Trie) childList {
+func newDenseChildList[T any](list *sparseChildList[T], child *TrieOf[T]) childList[T] {
 	var (
 		min int = 255
 		max int = 0
@@ -194,13 +225,13 @@ func newDenseChildList(list *sparseChildList, child *Trie) childList {
 		max = b
 	}
 
-	children := make([]*Trie, max-min+1)
+	children := make([]*TrieOf[T], max-min+1)
 	for _, child := range list.children {
 		children[int(child.prefix[0])-min] = child
 	}
 	children[int(child.prefix[0])-min] = child
 
-	return &denseChildList{
+	return &denseChildList[T]{
 		min:         min,
 		max:         max,
 		numChildren: list.length() + 1,
@@ -209,15 +240,15 @@ func newDenseChildList(list *sparseChildList, child *Trie) childList {
 	}
 }
 
-func (list *denseChildList) length() int {
+func (list *denseChildList[T]) length() int {
 	return list.numChildren
 }
 
-func (list *denseChildList) head() *Trie {
+func (list *denseChildList[T]) head() *TrieOf[T] {
 	return list.children[list.headIndex]
 }
 
-func (list *denseChildList) add(child *Trie) childList {
+func (list *denseChildList[T]) add(child *TrieOf[T]) childList[T] {
 	b := int(child.prefix[0])
 	var i int
 
@@ -230,7 +261,7 @@ func (list *denseChildList) add(child *Trie) childList {
 		list.children[i] = child
 
 	case b < list.min:
-		children := make([]*Trie, list.max-b+1)
+		children := make([]*TrieOf[T], list.max-b+1)
 		i = 0
 		children[i] = child
 		copy(children[list.min-b:], list.children)
@@ -238,7 +269,7 @@ func (list *denseChildList) add(child *Trie) childList {
 		list.min = b
 
 	default: // b > list.max
-		children := make([]*Trie, b-list.min+1)
+		children := make([]*TrieOf[T], b-list.min+1)
 		i = b - list.min
 		children[i] = child
 		copy(children, list.children)
@@ -253,7 +284,7 @@ func (list *denseChildList) add(child *Trie) childList {
 	return list
 }
 
-func (list *denseChildList) remove(b byte) {
+func (list *denseChildList[T]) remove(b byte) {
 	i := int(b) - list.min
 	if list.children[i] == nil {
 		// This is not supposed to be reached.
@@ -273,7 +304,7 @@ func (list *denseChildList) remove(b byte) {
 	}
 }
 
-func (list *denseChildList) replace(b byte, child *Trie) {
+func (list *denseChildList[T]) replace(b byte, child *TrieOf[T]) {
 	// Make a consistency check.
 	if p0 := child.prefix[0]; p0 != b {
 		panic(fmt.Errorf("child prefix mismatch: %v != %v", p0, b))
@@ -283,7 +314,7 @@ func (list *denseChildList) replace(b byte, child *Trie) {
 	list.children[int(b)-list.min] = child
 }
 
-func (list *denseChildList) next(b byte) *Trie {
+func (list *denseChildList[T]) next(b byte) *TrieOf[T] {
 	i := int(b)
 	if i < list.min || list.max < i {
 		return nil
@@ -291,13 +322,13 @@ func (list *denseChildList) next(b byte) *Trie {
 	return list.children[i-list.min]
 }
 
-func (list *denseChildList) walk(prefix *Prefix, visitor VisitorFunc) error {
+func (list *denseChildList[T]) walk(prefix *Prefix, visitor VisitorFuncOf[T]) error {
 	for _, child := range list.children {
 		if child == nil {
 			continue
 		}
 		*prefix = append(*prefix, child.prefix...)
-		if child.item != nil {
+		if child.hasItem {
 			if err := visitor(*prefix, child.item); err != nil {
 				if err == SkipSubtree {
 					*prefix = (*prefix)[:len(*prefix)-len(child.prefix)]
@@ -318,7 +349,7 @@ func (list *denseChildList) walk(prefix *Prefix, visitor VisitorFunc) error {
 	return nil
 }
 
-func (list *denseChildList) print(w io.Writer, indent int) {
+func (list *denseChildList[T]) print(w io.Writer, indent int) {
 	for _, child := range list.children {
 		if child != nil {
 			child.print(w, indent)
@@ -326,8 +357,8 @@ func (list *denseChildList) print(w io.Writer, indent int) {
 	}
 }
 
-func (list *denseChildList) clone() childList {
-	clones := make(tries, cap(list.children))
+func (list *denseChildList[T]) clone() childList[T] {
+	clones := make(tries[T], cap(list.children))
 
 	if list.numChildren != 0 {
 		clonedCount := 0
@@ -343,7 +374,7 @@ func (list *denseChildList) clone() childList {
 		}
 	}
 
-	return &denseChildList{
+	return &denseChildList[T]{
 		min:         list.min,
 		max:         list.max,
 		numChildren: list.numChildren,
@@ -352,7 +383,15 @@ func (list *denseChildList) clone() childList {
 	}
 }
 
-func (list *denseChildList) total() int {
+// copy makes a copy of the list, the children are shared.
+func (list *denseChildList[T]) copy() childList[T] {
+	clone := *list
+	clone.children = make([]*TrieOf[T], len(list.children))
+	copy(clone.children, list.children)
+	return &clone
+}
+
+func (list *denseChildList[T]) total() int {
 	tot := 0
 	for _, child := range list.children {
 		if child != nil {
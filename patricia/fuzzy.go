@@ -0,0 +1,138 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"sort"
+)
+
+//------------------------------------------------------------------------------
+// Fuzzy search
+//------------------------------------------------------------------------------
+
+type (
+	FuzzyVisitorFuncOf[T any] func(prefix Prefix, item T, distance int) error
+	FuzzyVisitorFunc          = FuzzyVisitorFuncOf[Item]
+)
+
+// VisitFuzzy calls visitor on every item whose key is within maxDistance of
+// query, measured as the Levenshtein distance between the byte strings. The
+// items are visited by increasing distance, items at the same distance in
+// alphabetical order.
+//
+// The trie is walked with one row of the edit distance matrix per key byte,
+// and a branch is abandoned as soon as every entry of the row exceeds
+// maxDistance, so only a small part of the trie is searched for small values
+// of maxDistance.
+//
+// If an error is returned from visitor, the function stops visiting and
+// returns that error. Returning SkipSubtree makes no sense here.
+func (trie *TrieOf[T]) VisitFuzzy(query Prefix, maxDistance int, visitor FuzzyVisitorFuncOf[T]) error {
+	// Nil query not allowed.
+	if query == nil {
+		panic(ErrNilPrefix)
+	}
+
+	// Empty trie must be handled explicitly.
+	if trie.prefix == nil || maxDistance < 0 {
+		return nil
+	}
+
+	first := make([]int, len(query)+1)
+	for i := range first {
+		first[i] = i
+	}
+	search := &fuzzySearch[T]{
+		query:       query,
+		maxDistance: maxDistance,
+		rows:        [][]int{first},
+	}
+	search.visit(trie)
+
+	// The trie is walked in alphabetical order, keep it for equal distances.
+	sort.SliceStable(search.matches, func(i, j int) bool {
+		return search.matches[i].distance < search.matches[j].distance
+	})
+	for _, m := range search.matches {
+		if err := visitor(m.key, m.item, m.distance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fuzzyMatch[T any] struct {
+	key      Prefix
+	item     T
+	distance int
+}
+
+type fuzzySearch[T any] struct {
+	query       Prefix
+	maxDistance int
+	// rows[i] is the edit distance row after the first i bytes of key.
+	rows    [][]int
+	key     Prefix
+	matches []fuzzyMatch[T]
+}
+
+func (s *fuzzySearch[T]) visit(node *TrieOf[T]) {
+	depth := len(s.key)
+
+	// Consume the node prefix, pruning the branch as soon as possible.
+	for _, b := range node.prefix {
+		if !s.push(b) {
+			s.key = s.key[:depth]
+			return
+		}
+	}
+
+	if node.hasItem {
+		if distance := s.rows[len(s.key)][len(s.query)]; distance <= s.maxDistance {
+			s.matches = append(s.matches, fuzzyMatch[T]{
+				key:      append(Prefix(nil), s.key...),
+				item:     node.item,
+				distance: distance,
+			})
+		}
+	}
+
+	for _, child := range node.sortedChildren() {
+		s.visit(child)
+	}
+	s.key = s.key[:depth]
+}
+
+// push appends b to the key and computes the next row, returning false when
+// no extension of the key can be within maxDistance.
+func (s *fuzzySearch[T]) push(b byte) bool {
+	depth := len(s.key)
+	if len(s.rows) == depth+1 {
+		s.rows = append(s.rows, make([]int, len(s.query)+1))
+	}
+	prev, row := s.rows[depth], s.rows[depth+1]
+	s.key = append(s.key, b)
+
+	row[0] = prev[0] + 1
+	best := row[0]
+	for i, q := range s.query {
+		cost := prev[i]
+		if q != b {
+			cost++
+		}
+		if v := prev[i+1] + 1; v < cost {
+			cost = v
+		}
+		if v := row[i] + 1; v < cost {
+			cost = v
+		}
+		row[i+1] = cost
+		if cost < best {
+			best = cost
+		}
+	}
+	return best <= s.maxDistance
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Tests -----------------------------------------------------------------------
+
+func TestTrie_VisitFuzzy(t *testing.T) {
+	trie := NewTrieOf[int]()
+	for i, k := range []string{"book", "books", "boo", "boon", "cook", "cake", "back", "bo", "look", "bookkeeper"} {
+		trie.Insert(Prefix(k), i)
+	}
+
+	var got []string
+	var distances []int
+	err := trie.VisitFuzzy(Prefix("book"), 1, func(prefix Prefix, item int, distance int) error {
+		got = append(got, string(prefix))
+		distances = append(distances, distance)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"book", "boo", "books", "boon", "cook", "look"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Unexpected matches, expected=%v, got=%v", expected, got)
+	}
+	if expected := []int{0, 1, 1, 1, 1, 1}; !reflect.DeepEqual(expected, distances) {
+		t.Errorf("Unexpected distances, expected=%v, got=%v", expected, distances)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = trie.VisitFuzzy(Prefix("book"), 2, func(prefix Prefix, item int, distance int) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Unexpected result after stopping, err=%v, visited=%v", err, n)
+	}
+
+	if err := NewTrie().VisitFuzzy(Prefix("x"), 3, func(Prefix, Item, int) error {
+		t.Errorf("Unexpected visit in an empty trie")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrie_VisitFuzzyBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	randomKey := func() string {
+		b := make([]byte, 1+r.Intn(8))
+		for i := range b {
+			b[i] = "abcd"[r.Intn(4)]
+		}
+		return string(b)
+	}
+
+	trie := NewTrieOf[string](MaxPrefixPerNode(3), MaxChildrenPerSparseNode(2))
+	keys := map[string]bool{}
+	for i := 0; i < 500; i++ {
+		k := randomKey()
+		trie.Insert(Prefix(k), k)
+		keys[k] = true
+	}
+
+	for i := 0; i < 50; i++ {
+		query := randomKey()
+		maxDistance := r.Intn(3)
+
+		type match struct {
+			key      string
+			distance int
+		}
+		var expected, got []match
+		for k := range keys {
+			if d := levenshtein(query, k); d <= maxDistance {
+				expected = append(expected, match{k, d})
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			if expected[i].distance != expected[j].distance {
+				return expected[i].distance < expected[j].distance
+			}
+			return expected[i].key < expected[j].key
+		})
+
+		trie.VisitFuzzy(Prefix(query), maxDistance, func(prefix Prefix, item string, distance int) error {
+			if string(prefix) != item {
+				t.Errorf("Unexpected item, key=%s, item=%v", prefix, item)
+			}
+			got = append(got, match{string(prefix), distance})
+			return nil
+		})
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Unexpected matches for %q within %v, expected=%v, got=%v", query, maxDistance, expected, got)
+		}
+	}
+}
+
+func levenshtein(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur := row[j]
+			cost := prev
+			if a[i-1] != b[j-1] {
+				cost++
+			}
+			if row[j]+1 < cost {
+				cost = row[j] + 1
+			}
+			if row[j-1]+1 < cost {
+				cost = row[j-1] + 1
+			}
+			row[j] = cost
+			prev = cur
+		}
+	}
+	return row[len(b)]
+}
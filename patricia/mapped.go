@@ -0,0 +1,372 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+)
+
+//------------------------------------------------------------------------------
+// MappedTrie
+//------------------------------------------------------------------------------
+
+// MappedTrie is a read-only trie served directly from its serialized form,
+// usually a memory-mapped file written by TrieOf.WriteTo. Nodes are decoded
+// on the fly while searching, nothing is rebuilt on the heap.
+//
+// Items are returned as byte slices pointing into the mapping, they must not
+// be modified and must not be used after Close.
+//
+// MappedTrie is safe for concurrent use.
+type MappedTrie struct {
+	data  []byte
+	root  uint32
+	items int
+	unmap func([]byte) error
+}
+
+// OpenMappedTrie maps the file at path into memory and validates it.
+func OpenMappedTrie(path string) (*MappedTrie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < headerSize+footerSize {
+		return nil, ErrInvalidFormat
+	}
+
+	data, err := mmapFile(f, int(stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+	trie, err := NewMappedTrie(data)
+	if err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+	trie.unmap = munmapFile
+	return trie, nil
+}
+
+// NewMappedTrie serves a trie from data as returned by TrieOf.MarshalBinary.
+// data is used in place and must not be modified afterwards.
+func NewMappedTrie(data []byte) (*MappedTrie, error) {
+	if len(data) < headerSize+footerSize || !bytes.Equal(data[:len(binaryMagic)], binaryMagic) {
+		return nil, ErrInvalidFormat
+	}
+	if data[len(binaryMagic)] != binaryVersion {
+		return nil, ErrInvalidFormat
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	footer := data[len(data)-footerSize:]
+	trie := &MappedTrie{
+		data: data[:len(data)-footerSize],
+		root: binary.LittleEndian.Uint32(footer[0:]),
+	}
+
+	// Check the structure once, so that searching does not need to.
+	v := &mappedValidator{trie: trie, budget: len(data) / 3}
+	if !v.validate(trie.root, uint32(len(trie.data))) || uint32(v.items) != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, ErrInvalidFormat
+	}
+	trie.items = v.items
+	return trie, nil
+}
+
+// Close releases the mapping. The trie and the items it returned must not be
+// used afterwards.
+func (trie *MappedTrie) Close() error {
+	data := trie.data
+	trie.data = nil
+	if trie.unmap == nil || data == nil {
+		return nil
+	}
+	// The mapping covers the footer as well.
+	return trie.unmap(data[:len(data)+footerSize])
+}
+
+// Len returns the number of items in the trie.
+func (trie *MappedTrie) Len() int {
+	return trie.items
+}
+
+// Get returns the item located at key, or nil.
+func (trie *MappedTrie) Get(key Prefix) []byte {
+	item, _ := trie.Lookup(key)
+	return item
+}
+
+// Lookup returns the item located at key and whether there is one.
+func (trie *MappedTrie) Lookup(key Prefix) ([]byte, bool) {
+	node, found, leftover := trie.findSubtree(key)
+	if !found || leftover != 0 || !node.hasItem {
+		return nil, false
+	}
+	return node.item, true
+}
+
+// Match returns true when there is an item stored at prefix.
+func (trie *MappedTrie) Match(prefix Prefix) (matchedExactly bool) {
+	_, matchedExactly = trie.Lookup(prefix)
+	return
+}
+
+// MatchSubtree returns true when there is a subtree representing extensions
+// to key, that is if there are any keys in the tree which have key as prefix.
+func (trie *MappedTrie) MatchSubtree(key Prefix) (matched bool) {
+	_, matched, _ = trie.findSubtree(key)
+	return
+}
+
+// VisitPrefixes visits only nodes that represent prefixes of key.
+func (trie *MappedTrie) VisitPrefixes(key Prefix, visitor VisitorFuncOf[[]byte]) error {
+	// Nil key not allowed.
+	if key == nil {
+		panic(ErrNilPrefix)
+	}
+
+	node := trie.node(trie.root)
+	prefix := key
+	offset := 0
+	for {
+		// Compute what part of prefix matches.
+		common := longestCommonPrefixLength(node.prefix, key)
+		key = key[common:]
+		offset += common
+
+		// Partial match means that there is no subtree matching prefix.
+		if common < len(node.prefix) {
+			return nil
+		}
+
+		// Call the visitor.
+		if node.hasItem {
+			if err := visitor(prefix[:offset], node.item); err != nil {
+				return err
+			}
+		}
+
+		if len(key) == 0 {
+			// This node represents key, we are finished.
+			return nil
+		}
+
+		// There is some key suffix left, move to the children.
+		child, ok := node.next(key[0])
+		if !ok {
+			// There is nowhere to continue, return.
+			return nil
+		}
+		node = trie.node(child)
+	}
+}
+
+// VisitSubtree calls visitor on every item with the given prefix in
+// alphabetical order, handling SkipSubtree like TrieOf.Visit.
+func (trie *MappedTrie) VisitSubtree(prefix Prefix, visitor VisitorFuncOf[[]byte]) error {
+	// Nil prefix not allowed.
+	if prefix == nil {
+		panic(ErrNilPrefix)
+	}
+
+	node, found, leftover := trie.findSubtree(prefix)
+	if !found {
+		return nil
+	}
+	buf := make(Prefix, 0, len(prefix)+leftover+32)
+	buf = append(buf, prefix...)
+	buf = append(buf, node.prefix[len(node.prefix)-leftover:]...)
+	err := trie.walk(node, &buf, visitor)
+	if err == SkipSubtree {
+		return nil
+	}
+	return err
+}
+
+// Visit calls visitor on every item in alphabetical order.
+func (trie *MappedTrie) Visit(visitor VisitorFuncOf[[]byte]) error {
+	return trie.VisitSubtree(Prefix{}, visitor)
+}
+
+func (trie *MappedTrie) walk(node mappedNode, prefix *Prefix, visitor VisitorFuncOf[[]byte]) error {
+	if node.hasItem {
+		if err := visitor(*prefix, node.item); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < node.numChildren; i++ {
+		child := trie.node(node.child(i))
+		*prefix = append(*prefix, child.prefix...)
+		err := trie.walk(child, prefix, visitor)
+		*prefix = (*prefix)[:len(*prefix)-len(child.prefix)]
+		if err != nil && err != SkipSubtree {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSubtree returns the node matching prefix and how many bytes of its own
+// prefix extend beyond the searched one.
+func (trie *MappedTrie) findSubtree(prefix Prefix) (node mappedNode, found bool, leftover int) {
+	node = trie.node(trie.root)
+	for {
+		// Compute what part of prefix matches.
+		common := longestCommonPrefixLength(node.prefix, prefix)
+		prefix = prefix[common:]
+
+		// We used up the whole prefix, subtree found.
+		if len(prefix) == 0 {
+			return node, true, len(node.prefix) - common
+		}
+
+		// Partial match means that there is no subtree matching prefix.
+		if common < len(node.prefix) {
+			return node, false, 0
+		}
+
+		// There is some prefix left, move to the children.
+		child, ok := node.next(prefix[0])
+		if !ok {
+			return node, false, 0
+		}
+		node = trie.node(child)
+	}
+}
+
+// mappedNode is a node decoded in place. The offsets were checked when the
+// trie was opened, so decoding does not check bounds again.
+type mappedNode struct {
+	prefix      []byte
+	item        []byte
+	hasItem     bool
+	numChildren int
+	table       []byte
+}
+
+func (trie *MappedTrie) node(off uint32) mappedNode {
+	n, _ := decodeMappedNode(trie.data[off:])
+	return n
+}
+
+// decodeMappedNode decodes the node at the start of data, returning false if
+// it does not fit.
+func decodeMappedNode(data []byte) (n mappedNode, ok bool) {
+	if len(data) == 0 {
+		return n, false
+	}
+	flags := data[0]
+	data = data[1:]
+
+	readBytes := func() ([]byte, bool) {
+		l, k := binary.Uvarint(data)
+		if k <= 0 || l > uint64(len(data)-k) {
+			return nil, false
+		}
+		p := data[k : k+int(l)]
+		data = data[k+int(l):]
+		return p, true
+	}
+
+	if n.prefix, ok = readBytes(); !ok {
+		return n, false
+	}
+	if flags&flagItem != 0 {
+		if n.item, ok = readBytes(); !ok {
+			return n, false
+		}
+		n.hasItem = true
+	}
+	count, k := binary.Uvarint(data)
+	if k <= 0 || count > 256 || count*childEntrySize > uint64(len(data)-k) {
+		return n, false
+	}
+	n.numChildren = int(count)
+	n.table = data[k : k+int(count)*childEntrySize]
+	return n, flags&^flagItem == 0
+}
+
+func (n *mappedNode) label(i int) byte {
+	return n.table[i*childEntrySize]
+}
+
+func (n *mappedNode) child(i int) uint32 {
+	return binary.LittleEndian.Uint32(n.table[i*childEntrySize+1:])
+}
+
+func (n *mappedNode) next(b byte) (uint32, bool) {
+	lo, hi := 0, n.numChildren
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if n.label(mid) < b {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < n.numChildren && n.label(lo) == b {
+		return n.child(lo), true
+	}
+	return 0, false
+}
+
+// mappedValidator walks the whole trie once when it is opened. Children are
+// always written before their parent, so requiring child offsets to be lower
+// rules out cycles, and the budget rules out exponential walks over shared
+// nodes.
+type mappedValidator struct {
+	trie   *MappedTrie
+	budget int
+	items  int
+}
+
+func (v *mappedValidator) validate(off, limit uint32) bool {
+	v.budget--
+	if v.budget < 0 || off < headerSize || off >= limit {
+		return false
+	}
+	n, ok := decodeMappedNode(v.trie.data[off:limit])
+	if !ok {
+		return false
+	}
+	if n.hasItem {
+		v.items++
+	}
+	for i := 0; i < n.numChildren; i++ {
+		if i > 0 && n.label(i-1) >= n.label(i) {
+			return false
+		}
+		child := n.child(i)
+		if !v.validate(child, off) {
+			return false
+		}
+		c, _ := decodeMappedNode(v.trie.data[child:off])
+		if len(c.prefix) == 0 || c.prefix[0] != n.label(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func longestCommonPrefixLength(a, b []byte) (i int) {
+	for ; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+	}
+	return
+}
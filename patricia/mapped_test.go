@@ -0,0 +1,216 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Tests -----------------------------------------------------------------------
+
+func buildMappedTestTrie(t *testing.T) (*TrieOf[string], []string) {
+	trie := NewTrieOf[string]()
+	keys := []string{
+		"Pepan", "Pepin", "Pepanek", "Honza", "Honzik", "Jenik", "Karel",
+		"a", "ab", "abc", "abcdefghijklmnopqrstuvwxyz",
+	}
+	// Enough children under "x" to turn it into a dense node.
+	for c := 'a'; c <= 'z'; c++ {
+		keys = append(keys, "x"+string(c)+"yz")
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		keys = append(keys, strconv.FormatUint(r.Uint64(), 36))
+	}
+	for _, k := range keys {
+		trie.Insert(Prefix(k), "item:"+k)
+	}
+	return trie, keys
+}
+
+func TestMappedTrie_MatchesTrie(t *testing.T) {
+	trie, keys := buildMappedTestTrie(t)
+
+	path := filepath.Join(t.TempDir(), "trie.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trie.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	mapped, err := OpenMappedTrie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+
+	if mapped.Len() != trie.size() {
+		t.Errorf("Unexpected length, expected=%v, got=%v", trie.size(), mapped.Len())
+	}
+
+	probes := append([]string{"", "P", "Pep", "Pepa", "Pepanekk", "x", "xq", "xqy", "zzz", "abcd"}, keys...)
+	for _, k := range probes {
+		key := Prefix(k)
+		item, ok := trie.Lookup(key)
+		mitem, mok := mapped.Lookup(key)
+		if ok != mok || item != string(mitem) {
+			t.Errorf("Unexpected lookup %q, expected=%q %v, got=%q %v", k, item, ok, mitem, mok)
+		}
+		if trie.Match(key) != mapped.Match(key) {
+			t.Errorf("Unexpected match %q", k)
+		}
+		if trie.MatchSubtree(key) != mapped.MatchSubtree(key) {
+			t.Errorf("Unexpected subtree match %q", k)
+		}
+
+		var expected, got []string
+		trie.VisitPrefixes(key, func(prefix Prefix, item string) error {
+			expected = append(expected, string(prefix)+"="+item)
+			return nil
+		})
+		mapped.VisitPrefixes(key, func(prefix Prefix, item []byte) error {
+			got = append(got, string(prefix)+"="+string(item))
+			return nil
+		})
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Unexpected prefixes of %q, expected=%v, got=%v", k, expected, got)
+		}
+
+		expected, got = nil, nil
+		trie.VisitSubtree(Prefix(k), func(prefix Prefix, item string) error {
+			expected = append(expected, string(prefix)+"="+item)
+			return nil
+		})
+		mapped.VisitSubtree(Prefix(k), func(prefix Prefix, item []byte) error {
+			got = append(got, string(prefix)+"="+string(item))
+			return nil
+		})
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Unexpected subtree of %q, expected=%v, got=%v", k, expected, got)
+		}
+	}
+}
+
+func TestMappedTrie_SkipSubtree(t *testing.T) {
+	trie, _ := buildMappedTestTrie(t)
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := NewMappedTrie(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	mapped.VisitSubtree(Prefix("Pep"), func(prefix Prefix, item []byte) error {
+		got = append(got, string(prefix))
+		if string(prefix) == "Pepan" {
+			return SkipSubtree
+		}
+		return nil
+	})
+	if expected := []string{"Pepan", "Pepin"}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Unexpected visit, expected=%v, got=%v", expected, got)
+	}
+}
+
+func TestMappedTrie_WriteToFunc(t *testing.T) {
+	trie := NewTrieOf[uint32]()
+	trie.Insert(Prefix("10.0.0.0/8"), 1)
+	trie.Insert(Prefix("10.1.0.0/16"), 2)
+	trie.Insert(Prefix("192.168.0.0/16"), 0)
+
+	if _, err := trie.MarshalBinary(); err == nil {
+		t.Errorf("Expected an error encoding uint32 items")
+	}
+
+	var buf []byte
+	w := writerFunc(func(p []byte) (int, error) {
+		buf = append(buf, p...)
+		return len(p), nil
+	})
+	_, err := trie.WriteToFunc(w, func(item uint32) ([]byte, error) {
+		return binary.BigEndian.AppendUint32(nil, item), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped, err := NewMappedTrie(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := mapped.Get(Prefix("192.168.0.0/16")); binary.BigEndian.Uint32(item) != 0 {
+		t.Errorf("Unexpected item, got=%v", item)
+	}
+	if !mapped.Match(Prefix("10.1.0.0/16")) || mapped.Match(Prefix("10.")) {
+		t.Errorf("Unexpected match result")
+	}
+	if !mapped.MatchSubtree(Prefix("10.")) {
+		t.Errorf("Unexpected subtree match result")
+	}
+}
+
+func TestMappedTrie_Empty(t *testing.T) {
+	data, err := NewTrie().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := NewMappedTrie(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped.Len() != 0 || mapped.Match(Prefix("a")) {
+		t.Errorf("Unexpected content in an empty trie")
+	}
+	mapped.Visit(func(prefix Prefix, item []byte) error {
+		t.Errorf("Unexpected visit of %q", prefix)
+		return nil
+	})
+}
+
+func TestMappedTrie_Corrupted(t *testing.T) {
+	trie, _ := buildMappedTestTrie(t)
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)/2] ^= 0x40
+	if _, err := NewMappedTrie(flipped); err != ErrChecksumMismatch {
+		t.Errorf("Unexpected error, expected=%v, got=%v", ErrChecksumMismatch, err)
+	}
+	if _, err := NewMappedTrie(data[:10]); err != ErrInvalidFormat {
+		t.Errorf("Unexpected error, expected=%v, got=%v", ErrInvalidFormat, err)
+	}
+
+	// A bogus root offset with a valid checksum is still rejected.
+	bogus := append([]byte(nil), data...)
+	footer := bogus[len(bogus)-footerSize:]
+	binary.LittleEndian.PutUint32(footer, uint32(len(bogus)))
+	binary.LittleEndian.PutUint32(footer[8:], crc32.Checksum(bogus[:len(bogus)-4], crcTable))
+	if _, err := NewMappedTrie(bogus); err != ErrInvalidFormat {
+		t.Errorf("Unexpected error, expected=%v, got=%v", ErrInvalidFormat, err)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package patricia
+
+import (
+	"io"
+	"os"
+)
+
+// Systems without mmap fall back to reading the file into memory.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
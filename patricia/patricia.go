@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -23,86 +24,111 @@ const (
 )
 
 type (
-	Prefix      []byte
-	Item        interface{}
-	VisitorFunc func(prefix Prefix, item Item) error
+	Prefix               []byte
+	Item                 interface{}
+	VisitorFuncOf[T any] func(prefix Prefix, item T) error
+	VisitorFunc          = VisitorFuncOf[Item]
 )
 
-// Trie is a generic patricia trie that allows fast retrieval of items by prefix.
+// TrieOf is a generic patricia trie that allows fast retrieval of items by prefix.
 // and other funky stuff.
 //
+// Storing a nil interface value is the same as storing no item at all, so
+// for interface types the nil value cannot be told apart from a missing key.
+//
+// TrieOf is not thread-safe, see SyncTrieOf for a concurrent variant.
+type TrieOf[T any] struct {
+	prefix  Prefix
+	item    T
+	hasItem bool
+
+	options
+
+	children childList[T]
+}
+
+// Trie is a patricia trie holding untyped items.
+//
 // Trie is not thread-safe.
-type Trie struct {
-	prefix Prefix
-	item   Item
+type Trie = TrieOf[Item]
 
+// options are shared by all the nodes of a trie.
+type options struct {
 	maxPrefixPerNode         int
 	maxChildrenPerSparseNode int
-
-	children childList
 }
 
 // Public API ------------------------------------------------------------------
 
-type Option func(*Trie)
+type Option func(*options)
 
 // Trie constructor.
 func NewTrie(options ...Option) *Trie {
-	trie := &Trie{}
+	return NewTrieOf[Item](options...)
+}
 
-	for _, opt := range options {
-		opt(trie)
+// TrieOf constructor.
+func NewTrieOf[T any](opts ...Option) *TrieOf[T] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	if trie.maxPrefixPerNode <= 0 {
-		trie.maxPrefixPerNode = DefaultMaxPrefixPerNode
+	if o.maxPrefixPerNode <= 0 {
+		o.maxPrefixPerNode = DefaultMaxPrefixPerNode
 	}
-	if trie.maxChildrenPerSparseNode <= 0 {
-		trie.maxChildrenPerSparseNode = DefaultMaxChildrenPerSparseNode
+	if o.maxChildrenPerSparseNode <= 0 {
+		o.maxChildrenPerSparseNode = DefaultMaxChildrenPerSparseNode
 	}
 
-	trie.children = newSparseChildList(trie.maxChildrenPerSparseNode)
-	return trie
+	return newNode[T](o)
+}
+
+func newNode[T any](o options) *TrieOf[T] {
+	return &TrieOf[T]{
+		options:  o,
+		children: newSparseChildList[T](o.maxChildrenPerSparseNode),
+	}
 }
 
 func MaxPrefixPerNode(value int) Option {
-	return func(trie *Trie) {
-		trie.maxPrefixPerNode = value
+	return func(o *options) {
+		o.maxPrefixPerNode = value
 	}
 }
 
 func MaxChildrenPerSparseNode(value int) Option {
-	return func(trie *Trie) {
-		trie.maxChildrenPerSparseNode = value
+	return func(o *options) {
+		o.maxChildrenPerSparseNode = value
 	}
 }
 
 // Clone makes a copy of an existing trie.
 // Items stored in both tries become shared, obviously.
-func (trie *Trie) Clone() *Trie {
-	return &Trie{
-		prefix:                   append(Prefix(nil), trie.prefix...),
-		item:                     trie.item,
-		maxPrefixPerNode:         trie.maxPrefixPerNode,
-		maxChildrenPerSparseNode: trie.maxChildrenPerSparseNode,
-		children:                 trie.children.clone(),
+func (trie *TrieOf[T]) Clone() *TrieOf[T] {
+	return &TrieOf[T]{
+		prefix:   append(Prefix(nil), trie.prefix...),
+		item:     trie.item,
+		hasItem:  trie.hasItem,
+		options:  trie.options,
+		children: trie.children.clone(),
 	}
 }
 
 // Item returns the item stored in the root of this trie.
-func (trie *Trie) Item() Item {
+func (trie *TrieOf[T]) Item() T {
 	return trie.item
 }
 
 // Insert inserts a new item into the trie using the given prefix. Insert does
 // not replace existing items. It returns false if an item was already in place.
-func (trie *Trie) Insert(key Prefix, item Item) (inserted bool) {
+func (trie *TrieOf[T]) Insert(key Prefix, item T) (inserted bool) {
 	return trie.put(key, item, false)
 }
 
 // Set works much like Insert, but it always sets the item, possibly replacing
 // the item previously inserted.
-func (trie *Trie) Set(key Prefix, item Item) {
+func (trie *TrieOf[T]) Set(key Prefix, item T) {
 	trie.put(key, item, true)
 }
 
@@ -114,42 +140,50 @@ func (trie *Trie) Set(key Prefix, item Item) {
 // into the tree by the user or not. A possible workaround for this is not to use
 // nil interface as a valid value, even using zero value of any type is enough
 // to prevent this bad behaviour.
-func (trie *Trie) Get(key Prefix) (item Item) {
+func (trie *TrieOf[T]) Get(key Prefix) (item T) {
+	item, _ = trie.Lookup(key)
+	return
+}
+
+// Lookup returns the item located at key and whether there is one. Unlike Get,
+// it can tell a missing key from a key holding the zero value of T.
+func (trie *TrieOf[T]) Lookup(key Prefix) (item T, found bool) {
 	_, node, found, leftover := trie.findSubtree(key)
-	if !found || len(leftover) != 0 {
-		return nil
+	if !found || len(leftover) != 0 || !node.hasItem {
+		return item, false
 	}
-	return node.item
+	return node.item, true
 }
 
-// Match returns what Get(prefix) != nil would return. The same warning as for
-// Get applies here as well.
-func (trie *Trie) Match(prefix Prefix) (matchedExactly bool) {
-	return trie.Get(prefix) != nil
+// Match returns true when there is an item stored at prefix, which for Trie
+// is what Get(prefix) != nil would return.
+func (trie *TrieOf[T]) Match(prefix Prefix) (matchedExactly bool) {
+	_, matchedExactly = trie.Lookup(prefix)
+	return
 }
 
 // MatchSubtree returns true when there is a subtree representing extensions
 // to key, that is if there are any keys in the tree which have key as prefix.
-func (trie *Trie) MatchSubtree(key Prefix) (matched bool) {
+func (trie *TrieOf[T]) MatchSubtree(key Prefix) (matched bool) {
 	_, _, matched, _ = trie.findSubtree(key)
 	return
 }
 
-// Visit calls visitor on every node containing a non-nil item
+// Visit calls visitor on every node containing an item
 // in alphabetical order.
 //
 // If an error is returned from visitor, the function stops visiting the tree
 // and returns that error, unless it is a special error - SkipSubtree. In that
 // case Visit skips the subtree represented by the current node and continues
 // elsewhere.
-func (trie *Trie) Visit(visitor VisitorFunc) error {
+func (trie *TrieOf[T]) Visit(visitor VisitorFuncOf[T]) error {
 	return trie.walk(nil, visitor)
 }
 
-func (trie *Trie) size() int {
+func (trie *TrieOf[T]) size() int {
 	n := 0
 
-	trie.walk(nil, func(prefix Prefix, item Item) error {
+	trie.walk(nil, func(prefix Prefix, item T) error {
 		n++
 		return nil
 	})
@@ -157,12 +191,12 @@ func (trie *Trie) size() int {
 	return n
 }
 
-func (trie *Trie) total() int {
+func (trie *TrieOf[T]) total() int {
 	return 1 + trie.children.total()
 }
 
 // VisitSubtree works much like Visit, but it only visits nodes matching prefix.
-func (trie *Trie) VisitSubtree(prefix Prefix, visitor VisitorFunc) error {
+func (trie *TrieOf[T]) VisitSubtree(prefix Prefix, visitor VisitorFuncOf[T]) error {
 	// Nil prefix not allowed.
 	if prefix == nil {
 		panic(ErrNilPrefix)
@@ -186,7 +220,7 @@ func (trie *Trie) VisitSubtree(prefix Prefix, visitor VisitorFunc) error {
 
 // VisitPrefixes visits only nodes that represent prefixes of key.
 // To say the obvious, returning SkipSubtree from visitor makes no sense here.
-func (trie *Trie) VisitPrefixes(key Prefix, visitor VisitorFunc) error {
+func (trie *TrieOf[T]) VisitPrefixes(key Prefix, visitor VisitorFuncOf[T]) error {
 	// Nil key not allowed.
 	if key == nil {
 		panic(ErrNilPrefix)
@@ -213,8 +247,8 @@ func (trie *Trie) VisitPrefixes(key Prefix, visitor VisitorFunc) error {
 		}
 
 		// Call the visitor.
-		if item := node.item; item != nil {
-			if err := visitor(prefix[:offset], item); err != nil {
+		if node.hasItem {
+			if err := visitor(prefix[:offset], node.item); err != nil {
 				return err
 			}
 		}
@@ -238,7 +272,7 @@ func (trie *Trie) VisitPrefixes(key Prefix, visitor VisitorFunc) error {
 // Delete deletes the item represented by the given prefix.
 //
 // True is returned if the matching node was found and deleted.
-func (trie *Trie) Delete(key Prefix) (deleted bool) {
+func (trie *TrieOf[T]) Delete(key Prefix) (deleted bool) {
 	// Nil prefix not allowed.
 	if key == nil {
 		panic(ErrNilPrefix)
@@ -256,18 +290,20 @@ func (trie *Trie) Delete(key Prefix) (deleted bool) {
 	}
 
 	node := path[len(path)-1]
-	var parent *Trie
+	var parent *TrieOf[T]
 	if len(path) != 1 {
 		parent = path[len(path)-2]
 	}
 
-	// If the item is already set to nil, there is nothing to do.
-	if node.item == nil {
+	// If the item is already unset, there is nothing to do.
+	if !node.hasItem {
 		return false
 	}
 
 	// Delete the item.
-	node.item = nil
+	var zero T
+	node.item = zero
+	node.hasItem = false
 
 	// Initialise i before goto.
 	// Will be used later in a loop.
@@ -289,7 +325,7 @@ func (trie *Trie) Delete(key Prefix) (deleted bool) {
 	// Find the first ancestor that has its value set or it has 2 or more child nodes.
 	// That will be the node where to drop the subtree at.
 	for ; i >= 0; i-- {
-		if current := path[i]; current.item != nil || current.children.length() >= 2 {
+		if current := path[i]; current.hasItem || current.children.length() >= 2 {
 			break
 		}
 	}
@@ -310,7 +346,7 @@ func (trie *Trie) Delete(key Prefix) (deleted bool) {
 	}
 	// i+1 is always a valid index since i is never pointing to the last node.
 	// The loop above skips at least the last node since we are sure that the item
-	// is set to nil and it has no children, othewise we would be compacting instead.
+	// is unset and it has no children, othewise we would be compacting instead.
 	node.children.remove(path[i+1].prefix[0])
 
 Compact:
@@ -331,7 +367,7 @@ Compact:
 // DeleteSubtree finds the subtree exactly matching prefix and deletes it.
 //
 // True is returned if the subtree was found and deleted.
-func (trie *Trie) DeleteSubtree(prefix Prefix) (deleted bool) {
+func (trie *TrieOf[T]) DeleteSubtree(prefix Prefix) (deleted bool) {
 	// Nil prefix not allowed.
 	if prefix == nil {
 		panic(ErrNilPrefix)
@@ -361,16 +397,16 @@ func (trie *Trie) DeleteSubtree(prefix Prefix) (deleted bool) {
 
 // Internal helper methods -----------------------------------------------------
 
-func (trie *Trie) empty() bool {
-	return trie.item == nil && trie.children.length() == 0
+func (trie *TrieOf[T]) empty() bool {
+	return !trie.hasItem && trie.children.length() == 0
 }
 
-func (trie *Trie) reset() {
+func (trie *TrieOf[T]) reset() {
 	trie.prefix = nil
-	trie.children = newSparseChildList(trie.maxPrefixPerNode)
+	trie.children = newSparseChildList[T](trie.maxPrefixPerNode)
 }
 
-func (trie *Trie) put(key Prefix, item Item, replace bool) (inserted bool) {
+func (trie *TrieOf[T]) put(key Prefix, item T, replace bool) (inserted bool) {
 	// Nil prefix not allowed.
 	if key == nil {
 		panic(ErrNilPrefix)
@@ -378,8 +414,8 @@ func (trie *Trie) put(key Prefix, item Item, replace bool) (inserted bool) {
 
 	var (
 		common int
-		node   *Trie = trie
-		child  *Trie
+		node   *TrieOf[T] = trie
+		child  *TrieOf[T]
 	)
 
 	if node.prefix == nil {
@@ -419,9 +455,9 @@ func (trie *Trie) put(key Prefix, item Item, replace bool) (inserted bool) {
 
 SplitPrefix:
 	// Split the prefix if necessary.
-	child = new(Trie)
+	child = new(TrieOf[T])
 	*child = *node
-	*node = *NewTrie()
+	*node = *newNode[T](trie.options)
 	node.prefix = child.prefix[:common]
 	child.prefix = child.prefix[common:]
 	child = child.compact()
@@ -431,7 +467,7 @@ AppendChild:
 	// Keep appending children until whole prefix is inserted.
 	// This loop starts with empty node.prefix that needs to be filled.
 	for len(key) != 0 {
-		child := NewTrie()
+		child := newNode[T](trie.options)
 		if len(key) <= trie.maxPrefixPerNode {
 			child.prefix = key
 			node.children = node.children.add(child)
@@ -447,14 +483,15 @@ AppendChild:
 
 InsertItem:
 	// Try to insert the item if possible.
-	if replace || node.item == nil {
+	if replace || !node.hasItem {
 		node.item = item
+		node.hasItem = !isNil(item)
 		return true
 	}
 	return false
 }
 
-func (trie *Trie) compact() *Trie {
+func (trie *TrieOf[T]) compact() *TrieOf[T] {
 	// Only a node with a single child can be compacted.
 	if trie.children.length() != 1 {
 		return trie
@@ -465,7 +502,7 @@ func (trie *Trie) compact() *Trie {
 	// If any item is set, we cannot compact since we want to retain
 	// the ability to do searching by key. This makes compaction less usable,
 	// but that simply cannot be avoided.
-	if trie.item != nil || child.item != nil {
+	if trie.hasItem || child.hasItem {
 		return trie
 	}
 
@@ -474,16 +511,70 @@ func (trie *Trie) compact() *Trie {
 		return trie
 	}
 
-	// Concatenate the prefixes, move the items.
-	child.prefix = append(trie.prefix, child.prefix...)
-	if trie.item != nil {
-		child.item = trie.item
+	// Concatenate the prefixes, move the items. The child is copied rather
+	// than modified, it may still be shared with a SyncTrieOf snapshot.
+	compacted := *child
+	compacted.prefix = make(Prefix, 0, len(trie.prefix)+len(child.prefix))
+	compacted.prefix = append(append(compacted.prefix, trie.prefix...), child.prefix...)
+	if trie.hasItem {
+		compacted.item = trie.item
+		compacted.hasItem = true
+	}
+
+	return &compacted
+}
+
+// copyPath returns a copy of the trie where the nodes along key are copied
+// as well, so that they can be modified without affecting the original.
+// All the other nodes are shared.
+func (trie *TrieOf[T]) copyPath(key Prefix) *TrieOf[T] {
+	root := trie.copyNode()
+	node := root
+	for {
+		common := node.longestCommonPrefixLength(key)
+		key = key[common:]
+		if common < len(node.prefix) || len(key) == 0 {
+			return root
+		}
+
+		child := node.children.next(key[0])
+		if child == nil {
+			return root
+		}
+		child = child.copyNode()
+		node.children.replace(key[0], child)
+		node = child
 	}
+}
 
-	return child
+func (trie *TrieOf[T]) copyNode() *TrieOf[T] {
+	node := *trie
+	node.children = trie.children.copy()
+	return &node
 }
 
-func (trie *Trie) findSubtree(prefix Prefix) (parent *Trie, root *Trie, found bool, leftover Prefix) {
+// sortPath sorts the sparse child lists along key, so that walking the trie
+// does not need to.
+func (trie *TrieOf[T]) sortPath(key Prefix) {
+	node := trie
+	for {
+		if list, ok := node.children.(*sparseChildList[T]); ok && !sort.IsSorted(list.children) {
+			sort.Sort(list.children)
+		}
+
+		common := node.longestCommonPrefixLength(key)
+		key = key[common:]
+		if common < len(node.prefix) || len(key) == 0 {
+			return
+		}
+
+		if node = node.children.next(key[0]); node == nil {
+			return
+		}
+	}
+}
+
+func (trie *TrieOf[T]) findSubtree(prefix Prefix) (parent *TrieOf[T], root *TrieOf[T], found bool, leftover Prefix) {
 	// Find the subtree matching prefix.
 	root = trie
 	for {
@@ -516,10 +607,10 @@ func (trie *Trie) findSubtree(prefix Prefix) (parent *Trie, root *Trie, found bo
 	}
 }
 
-func (trie *Trie) findSubtreePath(prefix Prefix) (path []*Trie, found bool, leftover Prefix) {
+func (trie *TrieOf[T]) findSubtreePath(prefix Prefix) (path []*TrieOf[T], found bool, leftover Prefix) {
 	// Find the subtree matching prefix.
 	root := trie
-	var subtreePath []*Trie
+	var subtreePath []*TrieOf[T]
 	for {
 		// Append the current root to the path.
 		subtreePath = append(subtreePath, root)
@@ -553,7 +644,7 @@ func (trie *Trie) findSubtreePath(prefix Prefix) (path []*Trie, found bool, left
 	}
 }
 
-func (trie *Trie) walk(actualRootPrefix Prefix, visitor VisitorFunc) error {
+func (trie *TrieOf[T]) walk(actualRootPrefix Prefix, visitor VisitorFuncOf[T]) error {
 	var prefix Prefix
 	// Allocate a bit more space for prefix at the beginning.
 	if actualRootPrefix == nil {
@@ -567,8 +658,8 @@ func (trie *Trie) walk(actualRootPrefix Prefix, visitor VisitorFunc) error {
 	}
 
 	// Visit the root first. Not that this works for empty trie as well since
-	// in that case item == nil && len(children) == 0.
-	if trie.item != nil {
+	// in that case !hasItem && len(children) == 0.
+	if trie.hasItem {
 		if err := visitor(prefix, trie.item); err != nil {
 			if err == SkipSubtree {
 				return nil
@@ -581,23 +672,28 @@ func (trie *Trie) walk(actualRootPrefix Prefix, visitor VisitorFunc) error {
 	return trie.children.walk(&prefix, visitor)
 }
 
-func (trie *Trie) longestCommonPrefixLength(prefix Prefix) (i int) {
+func (trie *TrieOf[T]) longestCommonPrefixLength(prefix Prefix) (i int) {
 	for ; i < len(prefix) && i < len(trie.prefix) && prefix[i] == trie.prefix[i]; i++ {
 	}
 	return
 }
 
-func (trie *Trie) dump() string {
+func (trie *TrieOf[T]) dump() string {
 	writer := &bytes.Buffer{}
 	trie.print(writer, 0)
 	return writer.String()
 }
 
-func (trie *Trie) print(writer io.Writer, indent int) {
+func (trie *TrieOf[T]) print(writer io.Writer, indent int) {
 	fmt.Fprintf(writer, "%s%s %v\n", strings.Repeat(" ", indent), string(trie.prefix), trie.item)
 	trie.children.print(writer, indent+2)
 }
 
+// isNil reports whether item is a nil interface value, which is never stored.
+func isNil[T any](item T) bool {
+	return any(item) == nil
+}
+
 // Errors ----------------------------------------------------------------------
 
 var (
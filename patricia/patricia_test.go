@@ -129,3 +129,91 @@ func TestTrie_DeleteAbsentPrefix(t *testing.T) {
 		t.Errorf("Unexpected item, expected=%v, got=%v", v.value, i)
 	}
 }
+
+func TestTrieOf_ZeroValues(t *testing.T) {
+	trie := NewTrieOf[int](MaxPrefixPerNode(4), MaxChildrenPerSparseNode(2))
+
+	data := []struct {
+		key   string
+		value int
+	}{
+		{"Pepan", 0},
+		{"Pepin", 1},
+		{"Honza", 2},
+		{"Honzik", 0},
+		{"Jenik", 4},
+		{"Karel", 5},
+	}
+
+	for _, v := range data {
+		if ok := trie.Insert(Prefix(v.key), v.value); !ok {
+			t.Errorf("Unexpected return value, expected=true, got=%v", ok)
+		}
+	}
+
+	for _, v := range data {
+		item, ok := trie.Lookup(Prefix(v.key))
+		if !ok || item != v.value {
+			t.Errorf("Unexpected item, expected=%v, got=%v (%v)", v.value, item, ok)
+		}
+		if !trie.Match(Prefix(v.key)) {
+			t.Errorf("Expected %v to match", v.key)
+		}
+	}
+
+	// Internal nodes do not hold items.
+	if item, ok := trie.Lookup(Prefix("Pep")); ok {
+		t.Errorf("Unexpected item, expected=none, got=%v", item)
+	}
+	if trie.Match(Prefix("Honz")) {
+		t.Errorf("Unexpected match for an internal node")
+	}
+
+	// Zero values are visited like any other.
+	var visited []string
+	trie.Visit(func(prefix Prefix, item int) error {
+		visited = append(visited, string(prefix))
+		return nil
+	})
+	expected := []string{"Honza", "Honzik", "Jenik", "Karel", "Pepan", "Pepin"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Unexpected visit order, expected=%v, got=%v", expected, visited)
+	}
+
+	// Insert does not replace an existing zero value.
+	if trie.Insert(Prefix("Pepan"), 9) {
+		t.Errorf("Unexpected insert over an existing zero value")
+	}
+	trie.Set(Prefix("Pepan"), 9)
+	if item := trie.Get(Prefix("Pepan")); item != 9 {
+		t.Errorf("Unexpected item, expected=9, got=%v", item)
+	}
+	if !trie.Delete(Prefix("Honzik")) || trie.Delete(Prefix("Honzik")) {
+		t.Errorf("Unexpected delete result")
+	}
+	if _, ok := trie.Lookup(Prefix("Honzik")); ok {
+		t.Errorf("Unexpected item after delete")
+	}
+
+	visited = visited[:0]
+	trie.VisitPrefixes(Prefix("Pepanek"), func(prefix Prefix, item int) error {
+		visited = append(visited, string(prefix))
+		return nil
+	})
+	if !reflect.DeepEqual(visited, []string{"Pepan"}) {
+		t.Errorf("Unexpected prefixes, got=%v", visited)
+	}
+}
+
+func TestTrie_SetNilClearsItem(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert(Prefix("Pepan"), "Pepan Zdepan")
+	trie.Set(Prefix("Pepan"), nil)
+
+	if trie.Match(Prefix("Pepan")) {
+		t.Errorf("Unexpected match after storing nil")
+	}
+	if !trie.Insert(Prefix("Pepan"), "Pepan Dupan") {
+		t.Errorf("Unexpected insert failure after storing nil")
+	}
+}
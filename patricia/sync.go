@@ -0,0 +1,147 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//------------------------------------------------------------------------------
+// SyncTrie
+//------------------------------------------------------------------------------
+
+// SyncTrieOf is a TrieOf that is safe for concurrent use.
+//
+// Readers never block. Every modification copies the nodes along the modified
+// key and atomically publishes a new root, while readers keep using the root
+// they started with, RCU style. Writers are serialised.
+//
+// As a consequence the visiting methods see the trie as it was when they were
+// called, and visitors may freely modify the trie.
+type SyncTrieOf[T any] struct {
+	mu   sync.Mutex
+	root atomic.Pointer[TrieOf[T]]
+}
+
+// SyncTrie is a concurrent patricia trie holding untyped items.
+type SyncTrie = SyncTrieOf[Item]
+
+// Public API ------------------------------------------------------------------
+
+// SyncTrie constructor.
+func NewSyncTrie(options ...Option) *SyncTrie {
+	return NewSyncTrieOf[Item](options...)
+}
+
+// SyncTrieOf constructor.
+func NewSyncTrieOf[T any](opts ...Option) *SyncTrieOf[T] {
+	trie := &SyncTrieOf[T]{}
+	trie.root.Store(NewTrieOf[T](opts...))
+	return trie
+}
+
+// Snapshot returns the current version of the trie. It is not affected by
+// later modifications of the SyncTrieOf, and it must not be modified itself,
+// Clone it first when needed.
+func (trie *SyncTrieOf[T]) Snapshot() *TrieOf[T] {
+	return trie.root.Load()
+}
+
+// Insert inserts a new item into the trie using the given prefix. Insert does
+// not replace existing items. It returns false if an item was already in place.
+func (trie *SyncTrieOf[T]) Insert(key Prefix, item T) (inserted bool) {
+	return trie.update(key, func(root *TrieOf[T]) bool {
+		return root.Insert(key, item)
+	})
+}
+
+// Set works much like Insert, but it always sets the item, possibly replacing
+// the item previously inserted.
+func (trie *SyncTrieOf[T]) Set(key Prefix, item T) {
+	trie.update(key, func(root *TrieOf[T]) bool {
+		root.Set(key, item)
+		return true
+	})
+}
+
+// Delete deletes the item represented by the given prefix.
+//
+// True is returned if the matching node was found and deleted.
+func (trie *SyncTrieOf[T]) Delete(key Prefix) (deleted bool) {
+	return trie.update(key, func(root *TrieOf[T]) bool {
+		return root.Delete(key)
+	})
+}
+
+// DeleteSubtree finds the subtree exactly matching prefix and deletes it.
+//
+// True is returned if the subtree was found and deleted.
+func (trie *SyncTrieOf[T]) DeleteSubtree(prefix Prefix) (deleted bool) {
+	return trie.update(prefix, func(root *TrieOf[T]) bool {
+		return root.DeleteSubtree(prefix)
+	})
+}
+
+// Get returns the item located at key, see TrieOf.Get.
+func (trie *SyncTrieOf[T]) Get(key Prefix) (item T) {
+	return trie.root.Load().Get(key)
+}
+
+// Lookup returns the item located at key and whether there is one.
+func (trie *SyncTrieOf[T]) Lookup(key Prefix) (item T, found bool) {
+	return trie.root.Load().Lookup(key)
+}
+
+// Match returns true when there is an item stored at prefix.
+func (trie *SyncTrieOf[T]) Match(prefix Prefix) (matchedExactly bool) {
+	return trie.root.Load().Match(prefix)
+}
+
+// MatchSubtree returns true when there is a subtree representing extensions
+// to key, that is if there are any keys in the tree which have key as prefix.
+func (trie *SyncTrieOf[T]) MatchSubtree(key Prefix) (matched bool) {
+	return trie.root.Load().MatchSubtree(key)
+}
+
+// Visit calls visitor on every node containing an item in alphabetical order,
+// see TrieOf.Visit.
+func (trie *SyncTrieOf[T]) Visit(visitor VisitorFuncOf[T]) error {
+	return trie.root.Load().Visit(visitor)
+}
+
+// VisitSubtree works much like Visit, but it only visits nodes matching prefix.
+func (trie *SyncTrieOf[T]) VisitSubtree(prefix Prefix, visitor VisitorFuncOf[T]) error {
+	return trie.root.Load().VisitSubtree(prefix, visitor)
+}
+
+// VisitPrefixes visits only nodes that represent prefixes of key.
+func (trie *SyncTrieOf[T]) VisitPrefixes(key Prefix, visitor VisitorFuncOf[T]) error {
+	return trie.root.Load().VisitPrefixes(key, visitor)
+}
+
+// VisitFuzzy visits the items within maxDistance of query, see
+// TrieOf.VisitFuzzy.
+func (trie *SyncTrieOf[T]) VisitFuzzy(query Prefix, maxDistance int, visitor FuzzyVisitorFuncOf[T]) error {
+	return trie.root.Load().VisitFuzzy(query, maxDistance, visitor)
+}
+
+// Internal helper methods -----------------------------------------------------
+
+// update applies modify to a copy of the path along key and publishes the
+// result, unless modify reports that nothing changed.
+func (trie *SyncTrieOf[T]) update(key Prefix, modify func(root *TrieOf[T]) bool) bool {
+	trie.mu.Lock()
+	defer trie.mu.Unlock()
+
+	root := trie.root.Load().copyPath(key)
+	if !modify(root) {
+		return false
+	}
+	root.sortPath(key)
+	trie.root.Store(root)
+	return true
+}
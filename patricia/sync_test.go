@@ -0,0 +1,168 @@
+// Copyright (c) 2014 The go-patricia AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package patricia
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Tests -----------------------------------------------------------------------
+
+func TestSyncTrie_Basic(t *testing.T) {
+	trie := NewSyncTrieOf[int](MaxChildrenPerSparseNode(2))
+
+	keys := []string{"Pepa", "Pepan", "Pepin", "Honza", "Honzik", "Jenik", "Karel", "Zdenek"}
+	for i, k := range keys {
+		if !trie.Insert(Prefix(k), i) {
+			t.Errorf("Unexpected insert failure, key=%v", k)
+		}
+	}
+	if trie.Insert(Prefix("Pepa"), 100) {
+		t.Errorf("Unexpected insert success, key=Pepa")
+	}
+	trie.Set(Prefix("Pepa"), 100)
+
+	if item, ok := trie.Lookup(Prefix("Pepa")); !ok || item != 100 {
+		t.Errorf("Unexpected item, expected=100, got=%v", item)
+	}
+	if !trie.MatchSubtree(Prefix("Hon")) || trie.Match(Prefix("Hon")) {
+		t.Errorf("Unexpected match result for Hon")
+	}
+
+	// The visitor sees the trie as it was, even when modifying it.
+	var visited []string
+	err := trie.Visit(func(prefix Prefix, item int) error {
+		visited = append(visited, string(prefix))
+		trie.Delete(prefix)
+		trie.Insert(append(Prefix("X"), prefix...), item)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Honza", "Honzik", "Jenik", "Karel", "Pepa", "Pepan", "Pepin", "Zdenek"}
+	if !reflect.DeepEqual(expected, visited) {
+		t.Errorf("Unexpected visit, expected=%v, got=%v", expected, visited)
+	}
+
+	visited = visited[:0]
+	trie.VisitSubtree(Prefix("X"), func(prefix Prefix, item int) error {
+		visited = append(visited, string(prefix))
+		return nil
+	})
+	if len(visited) != len(keys) {
+		t.Errorf("Unexpected visit, got=%v", visited)
+	}
+
+	visited = visited[:0]
+	trie.VisitPrefixes(Prefix("XPepan"), func(prefix Prefix, item int) error {
+		visited = append(visited, string(prefix))
+		return nil
+	})
+	if expected := []string{"XPepa", "XPepan"}; !reflect.DeepEqual(expected, visited) {
+		t.Errorf("Unexpected prefixes, expected=%v, got=%v", expected, visited)
+	}
+
+	if !trie.DeleteSubtree(Prefix("X")) || trie.MatchSubtree(Prefix("X")) {
+		t.Errorf("Unexpected subtree left after delete")
+	}
+}
+
+func TestSyncTrie_SnapshotUnchanged(t *testing.T) {
+	trie := NewSyncTrie(MaxPrefixPerNode(4), MaxChildrenPerSparseNode(3))
+
+	for i := 0; i < 200; i++ {
+		trie.Insert(Prefix("key"+strconv.Itoa(i)), i)
+	}
+	snapshot := trie.Snapshot()
+	before := snapshot.dump()
+
+	for i := 0; i < 200; i += 2 {
+		trie.Delete(Prefix("key" + strconv.Itoa(i)))
+	}
+	for i := 0; i < 100; i++ {
+		trie.Insert(Prefix("kez"+strconv.Itoa(i)), i)
+	}
+	trie.DeleteSubtree(Prefix("key1"))
+
+	if after := snapshot.dump(); before != after {
+		t.Errorf("Unexpected snapshot modification, expected=\n%v\ngot=\n%v", before, after)
+	}
+	if n := snapshot.size(); n != 200 {
+		t.Errorf("Unexpected snapshot size, expected=200, got=%v", n)
+	}
+	if item := snapshot.Get(Prefix("key10")); item != 10 {
+		t.Errorf("Unexpected snapshot item, expected=10, got=%v", item)
+	}
+	if trie.Match(Prefix("key10")) || !trie.Match(Prefix("key21")) || !trie.Match(Prefix("kez5")) {
+		t.Errorf("Unexpected trie content:\n%v", trie.Snapshot().dump())
+	}
+}
+
+func TestSyncTrie_Concurrent(t *testing.T) {
+	trie := NewSyncTrieOf[int](MaxPrefixPerNode(3), MaxChildrenPerSparseNode(4))
+
+	const writers, count = 4, 500
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				key := Prefix(strconv.Itoa(i) + "/" + strconv.Itoa(j))
+				trie.Insert(key, j)
+				if j%3 == 0 {
+					trie.Delete(key)
+				}
+			}
+		}(i)
+	}
+
+	var readers sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			prefix := Prefix(strconv.Itoa(i) + "/")
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				last := -1
+				trie.VisitSubtree(prefix, func(key Prefix, item int) error {
+					if string(key) != string(prefix)+strconv.Itoa(item) {
+						t.Errorf("Unexpected item, key=%s, item=%v", key, item)
+					}
+					last = item
+					return nil
+				})
+				trie.Get(append(prefix, strconv.Itoa(last)...))
+				trie.VisitPrefixes(Prefix(strconv.Itoa(i)+"/123"), func(key Prefix, item int) error {
+					return nil
+				})
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	n := 0
+	trie.Visit(func(key Prefix, item int) error {
+		n++
+		return nil
+	})
+	if expected := writers * (count - (count+2)/3); n != expected {
+		t.Errorf("Unexpected item count, expected=%v, got=%v", expected, n)
+	}
+}
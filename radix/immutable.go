@@ -0,0 +1,537 @@
+package radix
+
+import (
+	"iter"
+	"sort"
+	"strings"
+)
+
+// ImmutableTree is a persistent radix tree. It is never modified
+// in place: changes are made through a Txn, which copies only the
+// nodes on the modified paths and shares everything else with the
+// previous version. Any number of goroutines may read an
+// ImmutableTree while a writer builds the next version, no locking
+// is needed. Publish new versions with an atomic.Pointer if readers
+// need to pick up the latest one.
+//
+// Every node and leaf carries a channel that is closed when a
+// committed transaction replaces it, see GetWatch and WatchPrefix.
+type ImmutableTree[V any] struct {
+	root *node[V]
+	size int
+}
+
+// NewImmutableTree returns an empty ImmutableTree
+func NewImmutableTree[V any]() *ImmutableTree[V] {
+	return &ImmutableTree[V]{
+		root: &node[V]{mutateCh: make(chan struct{})},
+	}
+}
+
+// view exposes the tree through the read-only methods of TreeOf,
+// which never touch the mutation channels.
+func (t *ImmutableTree[V]) view() *TreeOf[V] {
+	return &TreeOf[V]{root: t.root, size: t.size}
+}
+
+// Len is used to return the number of elements in the tree
+func (t *ImmutableTree[V]) Len() int {
+	return t.size
+}
+
+// Txn starts a new transaction based on this version of the tree
+func (t *ImmutableTree[V]) Txn() *Txn[V] {
+	return &Txn[V]{
+		root:     t.root,
+		size:     t.size,
+		writable: make(map[*node[V]]struct{}),
+		notify:   make(map[chan struct{}]struct{}),
+	}
+}
+
+// Insert returns a new tree with the key set to v, along with
+// the previous value and if it was updated
+func (t *ImmutableTree[V]) Insert(k string, v V) (*ImmutableTree[V], V, bool) {
+	txn := t.Txn()
+	old, ok := txn.Insert(k, v)
+	return txn.Commit(), old, ok
+}
+
+// Delete returns a new tree without the key, along with the
+// previous value and if it was deleted
+func (t *ImmutableTree[V]) Delete(k string) (*ImmutableTree[V], V, bool) {
+	txn := t.Txn()
+	old, ok := txn.Delete(k)
+	return txn.Commit(), old, ok
+}
+
+// DeletePrefix returns a new tree without the subtree under a
+// prefix, along with how many entries were deleted
+func (t *ImmutableTree[V]) DeletePrefix(prefix string) (*ImmutableTree[V], int) {
+	txn := t.Txn()
+	num := txn.DeletePrefix(prefix)
+	return txn.Commit(), num
+}
+
+// Get is used to lookup a specific key, returning
+// the value and if it was found
+func (t *ImmutableTree[V]) Get(k string) (V, bool) {
+	return t.view().Get(k)
+}
+
+// GetWatch is like Get, but also returns a channel that is closed
+// when the key is modified. If the key is missing, the channel is
+// closed when the deepest node on its path changes, which includes
+// the key being inserted.
+func (t *ImmutableTree[V]) GetWatch(k string) (<-chan struct{}, V, bool) {
+	var zero V
+	n := t.root
+	watch := n.mutateCh
+	search := k
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			if n.isLeaf() {
+				return n.leaf.mutateCh, n.leaf.val, true
+			}
+			break
+		}
+
+		// Look for an edge
+		_, child := n.getEdgeIndex(search[0])
+		if child == nil {
+			break
+		}
+		n = child
+		watch = n.mutateCh
+
+		// Consume the search prefix
+		if strings.HasPrefix(search, n.prefix) {
+			search = search[len(n.prefix):]
+		} else {
+			break
+		}
+	}
+	return watch, zero, false
+}
+
+// WatchPrefix returns a channel that is closed when any entry
+// under the prefix is inserted, updated or deleted. The channel
+// belongs to the closest node covering the prefix, so it may also
+// be closed by changes to neighbouring keys sharing that node.
+func (t *ImmutableTree[V]) WatchPrefix(prefix string) <-chan struct{} {
+	n := t.root
+	search := prefix
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			return n.mutateCh
+		}
+
+		// Look for an edge, a change below a missing edge
+		// always rewrites the current node
+		_, child := n.getEdgeIndex(search[0])
+		if child == nil {
+			return n.mutateCh
+		}
+
+		// Consume the search prefix
+		if strings.HasPrefix(search, child.prefix) {
+			search = search[len(child.prefix):]
+			n = child
+		} else if strings.HasPrefix(child.prefix, search) {
+			// Child is under our search prefix
+			return child.mutateCh
+		} else {
+			return n.mutateCh
+		}
+	}
+}
+
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (t *ImmutableTree[V]) LongestPrefix(k string) (string, V, bool) {
+	return t.view().LongestPrefix(k)
+}
+
+// Minimum is used to return the minimum value in the tree
+func (t *ImmutableTree[V]) Minimum() (string, V, bool) {
+	return t.view().Minimum()
+}
+
+// Maximum is used to return the maximum value in the tree
+func (t *ImmutableTree[V]) Maximum() (string, V, bool) {
+	return t.view().Maximum()
+}
+
+// Walk is used to walk the tree
+func (t *ImmutableTree[V]) Walk(fn WalkFnOf[V]) {
+	t.view().Walk(fn)
+}
+
+// WalkPrefix is used to walk the tree under a prefix
+func (t *ImmutableTree[V]) WalkPrefix(prefix string, fn WalkFnOf[V]) {
+	t.view().WalkPrefix(prefix, fn)
+}
+
+// WalkPath is used to walk the tree, but only visiting nodes
+// from the root down to a given leaf.
+func (t *ImmutableTree[V]) WalkPath(path string, fn WalkFnOf[V]) {
+	t.view().WalkPath(path, fn)
+}
+
+// ToMap is used to walk the tree and convert it into a map
+func (t *ImmutableTree[V]) ToMap() map[string]V {
+	return t.view().ToMap()
+}
+
+// All returns an iterator over all the entries of the tree
+// in key order.
+func (t *ImmutableTree[V]) All() iter.Seq2[string, V] {
+	return t.view().All()
+}
+
+// Iterator returns an iterator positioned before the first entry
+func (t *ImmutableTree[V]) Iterator() *Iterator[V] {
+	return t.view().Iterator()
+}
+
+// SeekLowerBound returns the entry with the smallest key greater
+// than or equal to key
+func (t *ImmutableTree[V]) SeekLowerBound(key string) (string, V, bool) {
+	return t.view().SeekLowerBound(key)
+}
+
+// WalkRange is used to walk the entries with keys in [start, end)
+// in key order
+func (t *ImmutableTree[V]) WalkRange(start, end string, fn WalkFnOf[V]) {
+	t.view().WalkRange(start, end, fn)
+}
+
+// ReverseWalk is used to walk the tree in reverse key order
+func (t *ImmutableTree[V]) ReverseWalk(fn WalkFnOf[V]) {
+	t.view().ReverseWalk(fn)
+}
+
+// Match treats the keys of the tree as route patterns and returns
+// the pattern matching path, along with its value, see TreeOf.Match
+func (t *ImmutableTree[V]) Match(path string, ps *Params) (string, V, bool) {
+	return t.view().Match(path, ps)
+}
+
+// Txn batches modifications to an ImmutableTree. Nodes copied by
+// the transaction are modified in place until Commit, so a batch
+// only pays for each path once. A Txn must not be used from more
+// than one goroutine at a time.
+type Txn[V any] struct {
+	root *node[V]
+	size int
+
+	// writable holds the nodes created by this transaction,
+	// which are not visible to any reader yet
+	writable map[*node[V]]struct{}
+
+	// notify holds the channels to close on Commit
+	notify map[chan struct{}]struct{}
+}
+
+// Len is used to return the number of elements in the
+// transaction's tree
+func (t *Txn[V]) Len() int {
+	return t.size
+}
+
+// Get is used to lookup a specific key in the transaction's
+// tree, including uncommitted changes
+func (t *Txn[V]) Get(k string) (V, bool) {
+	return (&TreeOf[V]{root: t.root, size: t.size}).Get(k)
+}
+
+// Insert is used to add a newentry or update
+// an existing entry. Returns if updated.
+func (t *Txn[V]) Insert(k string, v V) (V, bool) {
+	newRoot, old, didUpdate := t.insert(t.root, k, k, v)
+	t.root = newRoot
+	if !didUpdate {
+		t.size++
+	}
+	return old, didUpdate
+}
+
+// Delete is used to delete a key, returning the previous
+// value and if it was deleted
+func (t *Txn[V]) Delete(k string) (V, bool) {
+	var zero V
+	newRoot, leaf := t.delete(true, t.root, k)
+	if newRoot == nil {
+		return zero, false
+	}
+	t.root = newRoot
+	t.size--
+	return leaf.val, true
+}
+
+// DeletePrefix is used to delete the subtree under a prefix
+// Returns how many entries were deleted
+func (t *Txn[V]) DeletePrefix(prefix string) int {
+	newRoot, num := t.deletePrefix(true, t.root, prefix)
+	if newRoot == nil {
+		return 0
+	}
+	t.root = newRoot
+	t.size -= num
+	return num
+}
+
+// Commit returns the new version of the tree and closes the
+// mutation channels of everything the transaction replaced.
+// The transaction may keep being used, further changes build
+// on the committed version.
+func (t *Txn[V]) Commit() *ImmutableTree[V] {
+	for ch := range t.notify {
+		select {
+		case <-ch:
+		default:
+			close(ch)
+		}
+	}
+	clear(t.notify)
+	clear(t.writable)
+	return &ImmutableTree[V]{root: t.root, size: t.size}
+}
+
+func (t *Txn[V]) trackChannel(ch chan struct{}) {
+	if ch != nil {
+		t.notify[ch] = struct{}{}
+	}
+}
+
+// newNode returns a node owned by the transaction
+func (t *Txn[V]) newNode(prefix string, leaf *leafNode[V]) *node[V] {
+	nc := &node[V]{
+		mutateCh: make(chan struct{}),
+		leaf:     leaf,
+		prefix:   prefix,
+	}
+	t.writable[nc] = struct{}{}
+	return nc
+}
+
+func (t *Txn[V]) newLeaf(k string, v V) *leafNode[V] {
+	return &leafNode[V]{mutateCh: make(chan struct{}), key: k, val: v}
+}
+
+// writeNode returns a copy of n that may be modified in place,
+// or n itself if the transaction already owns it
+func (t *Txn[V]) writeNode(n *node[V]) *node[V] {
+	if _, ok := t.writable[n]; ok {
+		return n
+	}
+	t.trackChannel(n.mutateCh)
+	nc := t.newNode(n.prefix, n.leaf)
+	if len(n.edges) != 0 {
+		nc.edges = make(edges[V], len(n.edges))
+		copy(nc.edges, n.edges)
+	}
+	return nc
+}
+
+// mergeChild collapses the only child of n into n, which must be
+// owned by the transaction. The child disappears from the tree, so
+// its watchers are notified even though its entries are unchanged.
+func (t *Txn[V]) mergeChild(n *node[V]) {
+	child := n.edges[0].node
+	if _, ok := t.writable[child]; !ok {
+		t.trackChannel(child.mutateCh)
+	}
+	n.prefix = n.prefix + child.prefix
+	n.leaf = child.leaf
+	if len(child.edges) != 0 {
+		n.edges = make(edges[V], len(child.edges))
+		copy(n.edges, child.edges)
+	} else {
+		n.edges = nil
+	}
+}
+
+func (t *Txn[V]) insert(n *node[V], k, search string, v V) (*node[V], V, bool) {
+	var zero V
+
+	// Handle key exhaution
+	if len(search) == 0 {
+		var old V
+		didUpdate := false
+		if n.isLeaf() {
+			old = n.leaf.val
+			didUpdate = true
+			t.trackChannel(n.leaf.mutateCh)
+		}
+		nc := t.writeNode(n)
+		nc.leaf = t.newLeaf(k, v)
+		return nc, old, didUpdate
+	}
+
+	// Look for the edge
+	idx, child := n.getEdgeIndex(search[0])
+
+	// No edge, create one
+	if child == nil {
+		nc := t.writeNode(n)
+		nc.addEdge(edge[V]{
+			label: search[0],
+			node:  t.newNode(search, t.newLeaf(k, v)),
+		})
+		return nc, zero, false
+	}
+
+	// Determine longest prefix of the search key on match
+	commonPrefix := longestPrefix(search, child.prefix)
+	if commonPrefix == len(child.prefix) {
+		newChild, old, didUpdate := t.insert(child, k, search[commonPrefix:], v)
+		nc := t.writeNode(n)
+		nc.edges[idx].node = newChild
+		return nc, old, didUpdate
+	}
+
+	// Split the node
+	nc := t.writeNode(n)
+	splitNode := t.newNode(search[:commonPrefix], nil)
+	nc.edges[idx].node = splitNode
+
+	// Restore the existing child
+	modChild := t.writeNode(child)
+	splitNode.addEdge(edge[V]{
+		label: modChild.prefix[commonPrefix],
+		node:  modChild,
+	})
+	modChild.prefix = modChild.prefix[commonPrefix:]
+
+	// If the new key is a subset, add to to this node
+	leaf := t.newLeaf(k, v)
+	search = search[commonPrefix:]
+	if len(search) == 0 {
+		splitNode.leaf = leaf
+		return nc, zero, false
+	}
+
+	// Create a new edge for the node
+	splitNode.addEdge(edge[V]{
+		label: search[0],
+		node:  t.newNode(search, leaf),
+	})
+	return nc, zero, false
+}
+
+// delete returns the replacement for n and the deleted leaf,
+// or a nil node if the key was not found
+func (t *Txn[V]) delete(isRoot bool, n *node[V], search string) (*node[V], *leafNode[V]) {
+	// Check for key exhaution
+	if len(search) == 0 {
+		if !n.isLeaf() {
+			return nil, nil
+		}
+		leaf := n.leaf
+		t.trackChannel(leaf.mutateCh)
+
+		nc := t.writeNode(n)
+		nc.leaf = nil
+
+		// Check if this node should be merged
+		if !isRoot && len(nc.edges) == 1 {
+			t.mergeChild(nc)
+		}
+		return nc, leaf
+	}
+
+	// Look for an edge
+	label := search[0]
+	idx, child := n.getEdgeIndex(label)
+	if child == nil || !strings.HasPrefix(search, child.prefix) {
+		return nil, nil
+	}
+
+	// Consume the search prefix
+	newChild, leaf := t.delete(false, child, search[len(child.prefix):])
+	if newChild == nil {
+		return nil, nil
+	}
+
+	nc := t.writeNode(n)
+	t.replaceChild(isRoot, nc, idx, label, newChild)
+	return nc, leaf
+}
+
+// deletePrefix returns the replacement for n and how many leaves
+// were removed, or a nil node if nothing matched
+func (t *Txn[V]) deletePrefix(isRoot bool, n *node[V], search string) (*node[V], int) {
+	// Check for key exhaustion
+	if len(search) == 0 {
+		// The whole subtree goes away, everybody watching
+		// inside it has to be told
+		num := 0
+		t.trackSubtree(n, &num)
+		return t.newNode(n.prefix, nil), num
+	}
+
+	// Look for an edge
+	label := search[0]
+	idx, child := n.getEdgeIndex(label)
+	if child == nil || (!strings.HasPrefix(child.prefix, search) && !strings.HasPrefix(search, child.prefix)) {
+		return nil, 0
+	}
+
+	// Consume the search prefix
+	if len(child.prefix) > len(search) {
+		search = search[len(search):]
+	} else {
+		search = search[len(child.prefix):]
+	}
+	newChild, num := t.deletePrefix(false, child, search)
+	if newChild == nil {
+		return nil, 0
+	}
+
+	nc := t.writeNode(n)
+	t.replaceChild(isRoot, nc, idx, label, newChild)
+	return nc, num
+}
+
+// replaceChild stores the new version of a child of nc, dropping
+// it if it became empty and merging nc if it is left with a single
+// child and no leaf
+func (t *Txn[V]) replaceChild(isRoot bool, nc *node[V], idx int, label byte, newChild *node[V]) {
+	if newChild.leaf != nil || len(newChild.edges) != 0 {
+		nc.edges[idx].node = newChild
+		return
+	}
+	nc.delEdge(label)
+	if !isRoot && len(nc.edges) == 1 && !nc.isLeaf() {
+		t.mergeChild(nc)
+	}
+}
+
+func (t *Txn[V]) trackSubtree(n *node[V], num *int) {
+	if _, ok := t.writable[n]; !ok {
+		t.trackChannel(n.mutateCh)
+	}
+	if n.leaf != nil {
+		t.trackChannel(n.leaf.mutateCh)
+		*num++
+	}
+	for _, e := range n.edges {
+		t.trackSubtree(e.node, num)
+	}
+}
+
+// getEdgeIndex is like getEdge, but also returns the
+// position of the edge
+func (n *node[V]) getEdgeIndex(label byte) (int, *node[V]) {
+	num := len(n.edges)
+	idx := sort.Search(num, func(i int) bool {
+		return n.edges[i].label >= label
+	})
+	if idx < num && n.edges[idx].label == label {
+		return idx, n.edges[idx].node
+	}
+	return -1, nil
+}
@@ -0,0 +1,204 @@
+package radix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImmutableTree(t *testing.T) {
+	inp := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		inp[generateUUID()] = i
+	}
+
+	r := NewImmutableTree[int]()
+	txn := r.Txn()
+	for k, v := range inp {
+		if _, ok := txn.Insert(k, v); ok {
+			t.Fatalf("bad insert: %v", k)
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("base tree modified: %v", r.Len())
+	}
+	if txn.Len() != len(inp) {
+		t.Fatalf("bad length: %v %v", txn.Len(), len(inp))
+	}
+
+	full := txn.Commit()
+	if !reflect.DeepEqual(full.ToMap(), inp) {
+		t.Fatalf("mis-match")
+	}
+
+	// Deleting from a new version leaves the old one intact
+	txn = full.Txn()
+	for k, v := range inp {
+		out, ok := txn.Delete(k)
+		if !ok {
+			t.Fatalf("missing key: %v", k)
+		}
+		if out != v {
+			t.Fatalf("value mis-match: %v %v", out, v)
+		}
+	}
+	empty := txn.Commit()
+	if empty.Len() != 0 || len(empty.ToMap()) != 0 {
+		t.Fatalf("bad length: %v", empty.Len())
+	}
+	if full.Len() != len(inp) || !reflect.DeepEqual(full.ToMap(), inp) {
+		t.Fatalf("old version modified")
+	}
+}
+
+func TestImmutableTreeStructure(t *testing.T) {
+	keys := []string{"", "A", "AB", "ABC", "R", "S", "foo", "foobar", "foozip"}
+
+	r := NewImmutableTree[string]()
+	m := NewTreeOf[string]()
+	for _, k := range keys {
+		r, _, _ = r.Insert(k, k)
+		m.Insert(k, k)
+	}
+
+	type exp struct {
+		prefix     string
+		numDeleted int
+	}
+	cases := []exp{
+		{"A", 3},
+		{"fooz", 1},
+		{"SS", 0},
+		{"", 5},
+	}
+	for _, test := range cases {
+		var num int
+		old := r.ToMap()
+		r, num = r.DeletePrefix(test.prefix)
+		if num != test.numDeleted || m.DeletePrefix(test.prefix) != num {
+			t.Fatalf("bad delete %q: %v %v", test.prefix, num, test.numDeleted)
+		}
+		if !reflect.DeepEqual(r.ToMap(), m.ToMap()) {
+			t.Fatalf("mis-match: %v %v", r.ToMap(), m.ToMap())
+		}
+		if len(old) < r.Len() {
+			t.Fatalf("bad length: %v %v", len(old), r.Len())
+		}
+	}
+
+	r, _, _ = r.Insert("foobar", "x")
+	if k, v, ok := r.LongestPrefix("foobarbaz"); !ok || k != "foobar" || v != "x" {
+		t.Fatalf("bad longest prefix: %v %v", k, v)
+	}
+	if k, _, _ := r.Minimum(); k != "foobar" {
+		t.Fatalf("bad minimum: %v", k)
+	}
+}
+
+func watchFired(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestImmutableTreeWatch(t *testing.T) {
+	r := NewImmutableTree[int]()
+	for i, k := range []string{"foo", "foo/bar", "foo/baz", "zip"} {
+		r, _, _ = r.Insert(k, i)
+	}
+
+	barCh, _, ok := r.GetWatch("foo/bar")
+	if !ok {
+		t.Fatalf("missing key")
+	}
+	missingCh, _, ok := r.GetWatch("foo/bax")
+	if ok {
+		t.Fatalf("unexpected key")
+	}
+	fooCh := r.WatchPrefix("foo/")
+	zipCh := r.WatchPrefix("zi")
+
+	// An uncommitted change is not visible to watchers
+	txn := r.Txn()
+	txn.Insert("foo/bar", 10)
+	if watchFired(barCh) || watchFired(fooCh) {
+		t.Fatalf("fired before commit")
+	}
+	r = txn.Commit()
+	if !watchFired(barCh) || !watchFired(fooCh) {
+		t.Fatalf("not fired on update")
+	}
+	if watchFired(zipCh) {
+		t.Fatalf("unrelated watch fired")
+	}
+
+	// Inserting a missing key notifies its watchers
+	r, _, _ = r.Insert("foo/bax", 1)
+	if !watchFired(missingCh) {
+		t.Fatalf("not fired on insert")
+	}
+
+	// Inserting under an empty prefix
+	qCh := r.WatchPrefix("q")
+	r, _, _ = r.Insert("quux", 1)
+	if !watchFired(qCh) {
+		t.Fatalf("not fired on insert under prefix")
+	}
+
+	// Deleting a subtree notifies everything inside it
+	zipLeaf, _, _ := r.GetWatch("zip")
+	r, _ = r.DeletePrefix("z")
+	if !watchFired(zipCh) || !watchFired(zipLeaf) {
+		t.Fatalf("not fired on delete prefix")
+	}
+
+	// Merged nodes notify watchers of the node that went away
+	bazCh := r.WatchPrefix("foo/baz")
+	r, _, _ = r.Delete("foo/bar")
+	r, _, _ = r.Delete("foo/bax")
+	watch := r.WatchPrefix("foo/baz")
+	r, _, _ = r.Insert("foo/baz/1", 1)
+	if !watchFired(bazCh) || !watchFired(watch) {
+		t.Fatalf("not fired after merge")
+	}
+	if _, ok := r.Get("foo/baz/1"); !ok {
+		t.Fatalf("missing key")
+	}
+}
+
+func TestImmutableTreeConcurrentReaders(t *testing.T) {
+	r := NewImmutableTree[int]()
+	for i := 0; i < 100; i++ {
+		r, _, _ = r.Insert(generateUUID(), i)
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func(r *ImmutableTree[int]) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				n := 0
+				r.Walk(func(string, int) bool {
+					n++
+					return false
+				})
+				if n != 100 {
+					t.Errorf("bad walk: %v", n)
+					return
+				}
+			}
+		}(r)
+	}
+
+	txn := r.Txn()
+	txn.DeletePrefix("")
+	for i := 0; i < 100; i++ {
+		txn.Insert(generateUUID(), i)
+	}
+	txn.Commit()
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,266 @@
+package radix
+
+import (
+	"iter"
+	"sort"
+	"strings"
+)
+
+// Iterator is a bidirectional cursor over the entries of a tree
+// in key order. The cursor sits between two entries: Next returns
+// the entry after it and moves forward, Prev returns the entry
+// before it and moves backward, so alternating Next and Prev
+// returns the same entry twice.
+//
+// The iterator holds the path from the root to the cursor, each
+// step costs amortized constant time. Modifying a TreeOf while
+// iterating over it is not supported, iterators over an
+// ImmutableTree are not affected by later transactions.
+type Iterator[V any] struct {
+	root  *node[V]
+	stack []iterFrame[V]
+}
+
+// iterFrame is one node on the path to the cursor. For the
+// last frame, idx is the slot the cursor sits before: -1 for the
+// leaf, 0..len(edges)-1 for a child and len(edges) for the end
+// of the node. For the other frames, idx is the child the path
+// goes through.
+type iterFrame[V any] struct {
+	n   *node[V]
+	idx int
+}
+
+// Iterator returns an iterator positioned before the first entry
+func (t *TreeOf[V]) Iterator() *Iterator[V] {
+	it := &Iterator[V]{root: t.root}
+	it.Seek("")
+	return it
+}
+
+// Seek restricts the iterator to the entries under a prefix and
+// positions it before the first of them
+func (it *Iterator[V]) Seek(prefix string) {
+	it.stack = it.stack[:0]
+	n := it.root
+	search := prefix
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			break
+		}
+
+		// Look for an edge
+		n = n.getEdge(search[0])
+		if n == nil {
+			return
+		}
+
+		// Consume the search prefix
+		if strings.HasPrefix(search, n.prefix) {
+			search = search[len(n.prefix):]
+		} else if strings.HasPrefix(n.prefix, search) {
+			// Child is under our search prefix
+			break
+		} else {
+			return
+		}
+	}
+	it.stack = append(it.stack, iterFrame[V]{n: n, idx: -1})
+}
+
+// SeekLowerBound removes any prefix restriction and positions the
+// iterator before the smallest key greater than or equal to key.
+// Prev then returns the largest key lower than key.
+func (it *Iterator[V]) SeekLowerBound(key string) {
+	it.stack = it.stack[:0]
+	n := it.root
+	search := key
+	for {
+		// Check for key exhaution, the cursor goes before the leaf
+		if len(search) == 0 {
+			it.stack = append(it.stack, iterFrame[V]{n: n, idx: -1})
+			return
+		}
+
+		// The leaf of this node is a strict prefix of key and sorts
+		// before it, find the first edge that may hold larger keys
+		num := len(n.edges)
+		idx := sort.Search(num, func(i int) bool {
+			return n.edges[i].label >= search[0]
+		})
+		if idx == num || n.edges[idx].label != search[0] {
+			it.stack = append(it.stack, iterFrame[V]{n: n, idx: idx})
+			return
+		}
+
+		child := n.edges[idx].node
+		commonPrefix := longestPrefix(search, child.prefix)
+		switch {
+		case commonPrefix == len(child.prefix):
+			// Descend into the child
+			it.stack = append(it.stack, iterFrame[V]{n: n, idx: idx})
+			search = search[commonPrefix:]
+			n = child
+		case commonPrefix == len(search) || child.prefix[commonPrefix] > search[commonPrefix]:
+			// Every key under the child is greater
+			it.stack = append(it.stack, iterFrame[V]{n: n, idx: idx})
+			return
+		default:
+			// Every key under the child is lower
+			it.stack = append(it.stack, iterFrame[V]{n: n, idx: idx + 1})
+			return
+		}
+	}
+}
+
+// SeekEnd positions the iterator after the last entry, keeping
+// any prefix restriction set by Seek, so Prev walks backward
+// from the end
+func (it *Iterator[V]) SeekEnd() {
+	if len(it.stack) == 0 {
+		return
+	}
+	n := it.stack[0].n
+	it.stack = append(it.stack[:0], iterFrame[V]{n: n, idx: len(n.edges)})
+}
+
+// Next returns the entry after the cursor and moves past it
+func (it *Iterator[V]) Next() (string, V, bool) {
+	var zero V
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		switch {
+		case top.idx == -1:
+			top.idx = 0
+			if top.n.leaf != nil {
+				return top.n.leaf.key, top.n.leaf.val, true
+			}
+		case top.idx < len(top.n.edges):
+			child := top.n.edges[top.idx].node
+			it.stack = append(it.stack, iterFrame[V]{n: child, idx: -1})
+		case len(it.stack) == 1:
+			return "", zero, false
+		default:
+			it.stack = it.stack[:len(it.stack)-1]
+			it.stack[len(it.stack)-1].idx++
+		}
+	}
+	return "", zero, false
+}
+
+// Prev returns the entry before the cursor and moves before it
+func (it *Iterator[V]) Prev() (string, V, bool) {
+	var zero V
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		switch {
+		case top.idx == -1:
+			if len(it.stack) == 1 {
+				return "", zero, false
+			}
+			it.stack = it.stack[:len(it.stack)-1]
+		case top.idx == 0:
+			top.idx = -1
+			if top.n.leaf != nil {
+				return top.n.leaf.key, top.n.leaf.val, true
+			}
+		default:
+			top.idx--
+			child := top.n.edges[top.idx].node
+			it.stack = append(it.stack, iterFrame[V]{n: child, idx: len(child.edges)})
+		}
+	}
+	return "", zero, false
+}
+
+// SeekLowerBound returns the entry with the smallest key greater
+// than or equal to key
+func (t *TreeOf[V]) SeekLowerBound(key string) (string, V, bool) {
+	it := &Iterator[V]{root: t.root}
+	it.SeekLowerBound(key)
+	return it.Next()
+}
+
+// WalkRange is used to walk the entries with keys in [start, end)
+// in key order
+func (t *TreeOf[V]) WalkRange(start, end string, fn WalkFnOf[V]) {
+	it := &Iterator[V]{root: t.root}
+	it.SeekLowerBound(start)
+	for {
+		k, v, ok := it.Next()
+		if !ok || k >= end || fn(k, v) {
+			return
+		}
+	}
+}
+
+// ReverseWalk is used to walk the tree in reverse key order
+func (t *TreeOf[V]) ReverseWalk(fn WalkFnOf[V]) {
+	reverseRecursiveWalk(t.root, fn)
+}
+
+// ReverseWalkPrefix is used to walk the tree under a prefix
+// in reverse key order
+func (t *TreeOf[V]) ReverseWalkPrefix(prefix string, fn WalkFnOf[V]) {
+	n := t.root
+	search := prefix
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			reverseRecursiveWalk(n, fn)
+			return
+		}
+
+		// Look for an edge
+		n = n.getEdge(search[0])
+		if n == nil {
+			return
+		}
+
+		// Consume the search prefix
+		if strings.HasPrefix(search, n.prefix) {
+			search = search[len(n.prefix):]
+		} else if strings.HasPrefix(n.prefix, search) {
+			// Child may be under our search prefix
+			reverseRecursiveWalk(n, fn)
+			return
+		} else {
+			return
+		}
+	}
+}
+
+// reverseRecursiveWalk is used to do a reverse pre-order walk
+// of a node recursively. Returns true if the walk should be aborted
+func reverseRecursiveWalk[V any](n *node[V], fn WalkFnOf[V]) bool {
+	// Recurse on the children
+	for i := len(n.edges) - 1; i >= 0; i-- {
+		if reverseRecursiveWalk(n.edges[i].node, fn) {
+			return true
+		}
+	}
+
+	// Visit the leaf values if any
+	return n.leaf != nil && fn(n.leaf.key, n.leaf.val)
+}
+
+// Range returns an iterator over the entries with keys in
+// [start, end) in key order.
+func (t *TreeOf[V]) Range(start, end string) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		t.WalkRange(start, end, func(k string, v V) bool {
+			return !yield(k, v)
+		})
+	}
+}
+
+// Backward returns an iterator over all the entries of the tree
+// in reverse key order.
+func (t *TreeOf[V]) Backward() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		t.ReverseWalk(func(k string, v V) bool {
+			return !yield(k, v)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package radix
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var iterKeys = []string{
+	"",
+	"a",
+	"ab",
+	"abc",
+	"abd",
+	"b",
+	"foo",
+	"foo/bar",
+	"foo/bar/baz",
+	"foo/baz",
+	"foobar",
+	"zip",
+	"zipzap",
+}
+
+func TestIteratorNextPrev(t *testing.T) {
+	r := NewTreeOf[int]()
+	for i, k := range iterKeys {
+		r.Insert(k, i)
+	}
+
+	it := r.Iterator()
+	out := []string{}
+	for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+		if iterKeys[v] != k {
+			t.Fatalf("value mis-match: %v %v", k, v)
+		}
+		out = append(out, k)
+	}
+	if !reflect.DeepEqual(out, iterKeys) {
+		t.Fatalf("mis-match: %v %v", out, iterKeys)
+	}
+
+	// Walk back from the end
+	out = out[:0]
+	for k, _, ok := it.Prev(); ok; k, _, ok = it.Prev() {
+		out = append(out, k)
+	}
+	for i := range out {
+		if out[i] != iterKeys[len(iterKeys)-1-i] {
+			t.Fatalf("mis-match: %v", out)
+		}
+	}
+	if len(out) != len(iterKeys) {
+		t.Fatalf("bad length: %v", len(out))
+	}
+
+	// Alternating returns the same entry
+	it.SeekLowerBound("foo/bay")
+	k1, _, _ := it.Next()
+	k2, _, _ := it.Prev()
+	if k1 != "foo/baz" || k2 != "foo/baz" {
+		t.Fatalf("bad alternation: %v %v", k1, k2)
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	r := NewTreeOf[int]()
+	for i, k := range iterKeys {
+		r.Insert(k, i)
+	}
+
+	type exp struct {
+		prefix string
+		out    []string
+	}
+	cases := []exp{
+		{"", iterKeys},
+		{"a", []string{"a", "ab", "abc", "abd"}},
+		{"fo", []string{"foo", "foo/bar", "foo/bar/baz", "foo/baz", "foobar"}},
+		{"foo/", []string{"foo/bar", "foo/bar/baz", "foo/baz"}},
+		{"foo/bar/", []string{"foo/bar/baz"}},
+		{"zipz", []string{"zipzap"}},
+		{"q", []string{}},
+		{"foox", []string{}},
+	}
+	for _, test := range cases {
+		it := r.Iterator()
+		it.Seek(test.prefix)
+		out := []string{}
+		for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+			out = append(out, k)
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match %q: %v %v", test.prefix, out, test.out)
+		}
+
+		it.SeekEnd()
+		out = out[:0]
+		for k, _, ok := it.Prev(); ok; k, _, ok = it.Prev() {
+			out = append(out, k)
+		}
+		if len(out) != len(test.out) {
+			t.Fatalf("bad reverse %q: %v %v", test.prefix, out, test.out)
+		}
+	}
+}
+
+func TestIteratorSeekLowerBound(t *testing.T) {
+	keys := make([]string, 0, 500)
+	r := NewTreeOf[int]()
+	for i := 0; i < 500; i++ {
+		k := generateUUID()[:rand.Intn(8)+1]
+		if _, ok := r.Insert(k, i); !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for i := 0; i < 1000; i++ {
+		bound := generateUUID()[:rand.Intn(6)]
+		if i%3 == 0 {
+			bound = keys[rand.Intn(len(keys))]
+		}
+		idx := sort.SearchStrings(keys, bound)
+
+		it := r.Iterator()
+		it.SeekLowerBound(bound)
+		k, _, ok := it.Next()
+		if idx == len(keys) {
+			if ok {
+				t.Fatalf("bound %q: unexpected %q", bound, k)
+			}
+		} else if !ok || k != keys[idx] {
+			t.Fatalf("bound %q: got %q want %q", bound, k, keys[idx])
+		}
+
+		it.SeekLowerBound(bound)
+		k, _, ok = it.Prev()
+		if idx == 0 {
+			if ok {
+				t.Fatalf("bound %q: unexpected prev %q", bound, k)
+			}
+		} else if !ok || k != keys[idx-1] {
+			t.Fatalf("bound %q: got prev %q want %q", bound, k, keys[idx-1])
+		}
+
+		if k, _, ok := r.SeekLowerBound(bound); ok != (idx < len(keys)) || (ok && k != keys[idx]) {
+			t.Fatalf("bound %q: bad SeekLowerBound %q", bound, k)
+		}
+	}
+}
+
+func TestWalkRange(t *testing.T) {
+	r := New()
+	for _, k := range iterKeys {
+		r.Insert(k, nil)
+	}
+
+	type exp struct {
+		start, end string
+		out        []string
+	}
+	cases := []exp{
+		{"", "a", []string{""}},
+		{"a", "b", []string{"a", "ab", "abc", "abd"}},
+		{"abc", "abd", []string{"abc"}},
+		{"ac", "foo/bar", []string{"b", "foo"}},
+		{"foo/", "foo0", []string{"foo/bar", "foo/bar/baz", "foo/baz"}},
+		{"zz", "zzz", []string{}},
+		{"b", "a", []string{}},
+	}
+	for _, test := range cases {
+		out := []string{}
+		r.WalkRange(test.start, test.end, func(k string, v interface{}) bool {
+			out = append(out, k)
+			return false
+		})
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match [%q, %q): %v %v", test.start, test.end, out, test.out)
+		}
+
+		out = out[:0]
+		for k := range r.Range(test.start, test.end) {
+			out = append(out, k)
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match [%q, %q): %v %v", test.start, test.end, out, test.out)
+		}
+	}
+}
+
+func TestReverseWalk(t *testing.T) {
+	r := New()
+	for _, k := range iterKeys {
+		r.Insert(k, nil)
+	}
+
+	out := []string{}
+	r.ReverseWalk(func(k string, v interface{}) bool {
+		out = append(out, k)
+		return false
+	})
+	rev := append([]string(nil), iterKeys...)
+	sort.Sort(sort.Reverse(sort.StringSlice(rev)))
+	if !reflect.DeepEqual(out, rev) {
+		t.Fatalf("mis-match: %v %v", out, rev)
+	}
+
+	out = out[:0]
+	for k := range r.Backward() {
+		out = append(out, k)
+	}
+	if !reflect.DeepEqual(out, rev) {
+		t.Fatalf("mis-match: %v %v", out, rev)
+	}
+
+	out = out[:0]
+	r.ReverseWalkPrefix("foo/", func(k string, v interface{}) bool {
+		out = append(out, k)
+		return len(out) == 2
+	})
+	if !reflect.DeepEqual(out, []string{"foo/baz", "foo/bar/baz"}) {
+		t.Fatalf("mis-match: %v", out)
+	}
+}
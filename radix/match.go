@@ -0,0 +1,200 @@
+package radix
+
+import "strings"
+
+// Param is a single route parameter captured by Match
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params holds the parameters captured by Match, in the order
+// they appear in the pattern
+type Params []Param
+
+// ByName returns the value of the first parameter with the
+// given name, or an empty string
+func (ps Params) ByName(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+func (ps *Params) push(value string) {
+	if ps != nil {
+		*ps = append(*ps, Param{Value: value})
+	}
+}
+
+func (ps *Params) truncate(mark int) {
+	if ps != nil {
+		*ps = (*ps)[:mark]
+	}
+}
+
+func (ps *Params) len() int {
+	if ps == nil {
+		return 0
+	}
+	return len(*ps)
+}
+
+// Match treats the keys of the tree as route patterns and returns
+// the pattern matching path, along with its value. In a pattern,
+// ":name" captures one path segment, up to the next '/', and
+// "*name" captures the rest of the path and must come last.
+//
+// Where several patterns match, static text is preferred over a
+// ":param" which is preferred over a "*catchall", at each point
+// the patterns diverge. The captured parameters are stored in ps,
+// reusing its backing array, so matching does not allocate once ps
+// has enough capacity. ps may be nil when the parameters are not
+// needed.
+func (t *TreeOf[V]) Match(path string, ps *Params) (string, V, bool) {
+	var zero V
+	ps.truncate(0)
+	leaf := matchNode(t.root, 0, path, ps)
+	if leaf == nil {
+		return "", zero, false
+	}
+	if ps != nil {
+		setParamNames(leaf.key, *ps)
+	}
+	return leaf.key, leaf.val, true
+}
+
+// matchNode matches search against the pattern starting at
+// n.prefix[i] and continuing in the children of n
+func matchNode[V any](n *node[V], i int, search string, ps *Params) *leafNode[V] {
+	for i < len(n.prefix) {
+		switch c := n.prefix[i]; c {
+		case ':':
+			end := strings.IndexByte(search, '/')
+			if end < 0 {
+				end = len(search)
+			}
+			if end == 0 {
+				return nil
+			}
+			mark := ps.len()
+			ps.push(search[:end])
+			if leaf := matchParamName(n, i+1, search[end:], ps); leaf != nil {
+				return leaf
+			}
+			ps.truncate(mark)
+			return nil
+		case '*':
+			mark := ps.len()
+			ps.push(search)
+			if leaf := matchCatchAll(n, i+1); leaf != nil {
+				return leaf
+			}
+			ps.truncate(mark)
+			return nil
+		default:
+			if len(search) == 0 || search[0] != c {
+				return nil
+			}
+			search = search[1:]
+			i++
+		}
+	}
+
+	// Check for path exhaution
+	if len(search) == 0 && n.isLeaf() {
+		return n.leaf
+	}
+
+	// Static edges first, then parameters, then catch-alls
+	if len(search) > 0 && search[0] != ':' && search[0] != '*' {
+		if child := n.getEdge(search[0]); child != nil {
+			if leaf := matchNode(child, 0, search, ps); leaf != nil {
+				return leaf
+			}
+		}
+	}
+	if child := n.getEdge(':'); child != nil {
+		if leaf := matchNode(child, 0, search, ps); leaf != nil {
+			return leaf
+		}
+	}
+	if child := n.getEdge('*'); child != nil {
+		return matchNode(child, 0, search, ps)
+	}
+	return nil
+}
+
+// matchParamName skips the name of a ":param" starting at
+// n.prefix[i], which may continue in the children of n, and
+// matches the rest of the path after it
+func matchParamName[V any](n *node[V], i int, rest string, ps *Params) *leafNode[V] {
+	for i < len(n.prefix) && n.prefix[i] != '/' {
+		i++
+	}
+	if i < len(n.prefix) {
+		return matchNode(n, i, rest, ps)
+	}
+
+	// The name ends with the pattern
+	if len(rest) == 0 && n.isLeaf() {
+		return n.leaf
+	}
+
+	// A '/' edge ends the name, any other edge continues it
+	for _, e := range n.edges {
+		var leaf *leafNode[V]
+		if e.label == '/' {
+			leaf = matchNode(e.node, 0, rest, ps)
+		} else {
+			leaf = matchParamName(e.node, 0, rest, ps)
+		}
+		if leaf != nil {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// matchCatchAll skips the name of a "*catchall" starting at
+// n.prefix[i], which must run to the end of the pattern
+func matchCatchAll[V any](n *node[V], i int) *leafNode[V] {
+	if strings.IndexByte(n.prefix[i:], '/') >= 0 {
+		return nil
+	}
+	if n.isLeaf() {
+		return n.leaf
+	}
+	for _, e := range n.edges {
+		if e.label == '/' {
+			continue
+		}
+		if leaf := matchCatchAll(e.node, 0); leaf != nil {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// setParamNames fills in the keys of ps from the parameter
+// names of the pattern, the values were captured in order
+func setParamNames(pattern string, ps Params) {
+	j := 0
+	for i := 0; i < len(pattern) && j < len(ps); i++ {
+		switch pattern[i] {
+		case ':':
+			end := strings.IndexByte(pattern[i:], '/')
+			if end < 0 {
+				end = len(pattern) - i
+			}
+			ps[j].Key = pattern[i+1 : i+end]
+			j++
+			i += end - 1
+		case '*':
+			ps[j].Key = pattern[i+1:]
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package radix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	r := NewTreeOf[int]()
+	routes := []string{
+		"/",
+		"/cmd/:tool/",
+		"/cmd/:tool/:sub",
+		"/src/*filepath",
+		"/search/",
+		"/search/:query",
+		"/user_:name",
+		"/user_:name/about",
+		"/files/:dir/*filepath",
+		"/doc/",
+		"/doc/go_faq.html",
+		"/info/:user/public",
+		"/info/:user/project/:project",
+		"/users/new",
+		"/users/:id",
+		"/users/:identity/keys",
+		"/static/*path",
+		"/static/favicon.ico",
+	}
+	for i, k := range routes {
+		r.Insert(k, i)
+	}
+
+	type exp struct {
+		path    string
+		route   string
+		params  Params
+		noMatch bool
+	}
+	cases := []exp{
+		{path: "/", route: "/"},
+		{path: "/cmd/test/", route: "/cmd/:tool/", params: Params{{"tool", "test"}}},
+		{path: "/cmd/test", noMatch: true},
+		{path: "/cmd/test/3", route: "/cmd/:tool/:sub", params: Params{{"tool", "test"}, {"sub", "3"}}},
+		{path: "/src/", route: "/src/*filepath", params: Params{{"filepath", ""}}},
+		{path: "/src/some/file.png", route: "/src/*filepath", params: Params{{"filepath", "some/file.png"}}},
+		{path: "/search/", route: "/search/"},
+		{path: "/search/someth!ng+in+ünìcodé", route: "/search/:query", params: Params{{"query", "someth!ng+in+ünìcodé"}}},
+		{path: "/search/someth!ng+in+ünìcodé/", noMatch: true},
+		{path: "/user_gopher", route: "/user_:name", params: Params{{"name", "gopher"}}},
+		{path: "/user_gopher/about", route: "/user_:name/about", params: Params{{"name", "gopher"}}},
+		{path: "/files/js/inc/framework.js", route: "/files/:dir/*filepath", params: Params{{"dir", "js"}, {"filepath", "inc/framework.js"}}},
+		{path: "/info/gordon/public", route: "/info/:user/public", params: Params{{"user", "gordon"}}},
+		{path: "/info/gordon/project/go", route: "/info/:user/project/:project", params: Params{{"user", "gordon"}, {"project", "go"}}},
+		{path: "/info/gordon", noMatch: true},
+		{path: "/doc/go_faq.html", route: "/doc/go_faq.html"},
+		{path: "/doc/other.html", noMatch: true},
+
+		// Static beats parameter, with backtracking
+		{path: "/users/new", route: "/users/new"},
+		{path: "/users/newer", route: "/users/:id", params: Params{{"id", "newer"}}},
+		{path: "/users/42/keys", route: "/users/:identity/keys", params: Params{{"identity", "42"}}},
+		{path: "/users/", noMatch: true},
+
+		// Static beats catch-all
+		{path: "/static/favicon.ico", route: "/static/favicon.ico"},
+		{path: "/static/favicon.ico.bak", route: "/static/*path", params: Params{{"path", "favicon.ico.bak"}}},
+		{path: "/nope", noMatch: true},
+	}
+
+	ps := make(Params, 0, 4)
+	for _, test := range cases {
+		route, v, ok := r.Match(test.path, &ps)
+		if test.noMatch {
+			if ok {
+				t.Fatalf("%q: unexpected match %q", test.path, route)
+			}
+			continue
+		}
+		if !ok || route != test.route || routes[v] != route {
+			t.Fatalf("%q: bad match %q %v", test.path, route, ok)
+		}
+		if len(ps) != len(test.params) || (len(ps) > 0 && !reflect.DeepEqual(ps, test.params)) {
+			t.Fatalf("%q: bad params %v %v", test.path, ps, test.params)
+		}
+		for _, p := range test.params {
+			if ps.ByName(p.Key) != p.Value {
+				t.Fatalf("%q: bad param %v", test.path, p.Key)
+			}
+		}
+
+		// Matching without params finds the same route
+		if route, _, _ := r.Match(test.path, nil); route != test.route {
+			t.Fatalf("%q: bad match without params %q", test.path, route)
+		}
+	}
+}
+
+func TestMatchAllocs(t *testing.T) {
+	r := New()
+	for _, k := range []string{"/users/new", "/users/:id", "/users/:id/keys/:key", "/static/*path"} {
+		r.Insert(k, true)
+	}
+
+	ps := make(Params, 0, 4)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, _, ok := r.Match("/users/42/keys/7", &ps); !ok {
+			t.Fatalf("no match")
+		}
+		if _, _, ok := r.Match("/static/a/b/c", &ps); !ok {
+			t.Fatalf("no match")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("bad allocs: %v", allocs)
+	}
+}
@@ -0,0 +1,375 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The on-disk layout written by Save is
+//
+//	magic    [4]byte  "RDXT"
+//	version  byte
+//	size     uvarint  number of leaves in the tree
+//	root     node
+//	checksum uint32   little-endian CRC-32C of everything above
+//
+// and every node is encoded in pre-order as
+//
+//	prefix   uvarint length + bytes
+//	flags    byte     flagLeaf if the node carries a value
+//	value    uvarint length + bytes, present only with flagLeaf
+//	edges    uvarint  number of children, followed by each child node
+//
+// Edge labels are not stored: the label of an edge is always the
+// first byte of the child's prefix. Leaf keys are not stored either,
+// they are rebuilt from the prefixes on the path from the root.
+const (
+	fileVersion = 1
+
+	flagLeaf = 1 << 0
+)
+
+var fileMagic = [4]byte{'R', 'D', 'X', 'T'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrInvalidFormat is returned by Load when the file is not a
+	// radix tree or is structurally corrupted.
+	ErrInvalidFormat = errors.New(`radix: invalid file format`)
+
+	// ErrUnsupportedVersion is returned by Load when the file was
+	// written by an unknown version of the format.
+	ErrUnsupportedVersion = errors.New(`radix: unsupported file version`)
+
+	// ErrChecksum is returned by Load when the file checksum does not
+	// match its contents.
+	ErrChecksum = errors.New(`radix: checksum mismatch`)
+)
+
+// Codec encodes and decodes the leaf values of a Tree when it is
+// saved to or loaded from a file.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// GobCodec is the default Codec. It uses encoding/gob, so concrete
+// types other than the builtin ones must be registered with
+// gob.Register before saving or loading.
+type GobCodec struct{}
+
+// Marshal encodes v with gob. A nil value is encoded as no bytes.
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a value produced by Marshal.
+func (GobCodec) Unmarshal(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetCodec sets the Codec used by Save and Load for the leaf values.
+// A nil Codec restores the default GobCodec.
+func (t *Tree) SetCodec(c Codec) {
+	t.codec = c
+}
+
+func (t *Tree) valueCodec() Codec {
+	if t.codec == nil {
+		return GobCodec{}
+	}
+	return t.codec
+}
+
+// Save writes the tree to file. The file is written to a temporary
+// location first and renamed into place, so an existing file is never
+// left half written.
+func (t *Tree) Save(file string) error {
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err = t.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Load replaces the contents of the tree with the tree stored in file.
+func (t *Tree) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = t.ReadFrom(f)
+	return err
+}
+
+// WriteTo writes the tree to w in the format used by Save.
+func (t *Tree) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	enc := &encoder{
+		w:     bufio.NewWriter(cw),
+		crc:   crc32.New(crcTable),
+		codec: t.valueCodec(),
+	}
+	enc.write(fileMagic[:])
+	enc.write([]byte{fileVersion})
+	enc.uvarint(uint64(t.tree.size))
+	enc.node(t.tree.root)
+	if enc.err == nil {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], enc.crc.Sum32())
+		_, enc.err = enc.w.Write(sum[:])
+	}
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	return cw.n, enc.err
+}
+
+// ReadFrom replaces the contents of the tree with a tree read from r
+// in the format used by Save. The node structure is rebuilt directly,
+// without inserting the keys one at a time.
+func (t *Tree) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	n := int64(len(data))
+	if err != nil {
+		return n, err
+	}
+
+	if len(data) < len(fileMagic)+1+4 || !bytes.Equal(data[:len(fileMagic)], fileMagic[:]) {
+		return n, ErrInvalidFormat
+	}
+	if data[len(fileMagic)] != fileVersion {
+		return n, ErrUnsupportedVersion
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(sum) {
+		return n, ErrChecksum
+	}
+
+	dec := &decoder{
+		buf:   body[len(fileMagic)+1:],
+		codec: t.valueCodec(),
+	}
+	size := dec.uvarint()
+	root := dec.node(true)
+	if dec.err == nil && (len(dec.buf) != 0 || uint64(dec.leaves) != size) {
+		dec.err = ErrInvalidFormat
+	}
+	if dec.err != nil {
+		return n, dec.err
+	}
+
+	t.tree.root = root
+	t.tree.size = dec.leaves
+	return n, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// encoder writes the pre-order node stream, keeping track of the
+// first error and the running checksum.
+type encoder struct {
+	w     *bufio.Writer
+	crc   hash.Hash32
+	codec Codec
+	err   error
+	tmp   [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc.Write(p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.tmp[:], v)
+	e.write(e.tmp[:n])
+}
+
+func (e *encoder) bytes(p []byte) {
+	e.uvarint(uint64(len(p)))
+	e.write(p)
+}
+
+func (e *encoder) node(n *node[interface{}]) {
+	if e.err != nil {
+		return
+	}
+	e.bytes([]byte(n.prefix))
+	if n.isLeaf() {
+		val, err := e.codec.Marshal(n.leaf.val)
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.write([]byte{flagLeaf})
+		e.bytes(val)
+	} else {
+		e.write([]byte{0})
+	}
+	e.uvarint(uint64(len(n.edges)))
+	for _, edge := range n.edges {
+		e.node(edge.node)
+	}
+}
+
+// decoder rebuilds nodes from a checksummed buffer.
+type decoder struct {
+	buf    []byte
+	codec  Codec
+	err    error
+	leaves int
+	key    []byte
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrInvalidFormat
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	l := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if l > uint64(len(d.buf)) {
+		d.fail()
+		return nil
+	}
+	p := d.buf[:l]
+	d.buf = d.buf[l:]
+	return p
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) node(root bool) *node[interface{}] {
+	prefix := d.bytes()
+	flags := d.byte()
+	if d.err != nil {
+		return nil
+	}
+	if (root && len(prefix) != 0) || (!root && len(prefix) == 0) || flags&^flagLeaf != 0 {
+		d.fail()
+		return nil
+	}
+
+	n := &node[interface{}]{prefix: string(prefix)}
+	mark := len(d.key)
+	d.key = append(d.key, prefix...)
+
+	if flags&flagLeaf != 0 {
+		raw := d.bytes()
+		if d.err != nil {
+			return nil
+		}
+		val, err := d.codec.Unmarshal(raw)
+		if err != nil {
+			d.err = err
+			return nil
+		}
+		n.leaf = &leafNode[interface{}]{key: string(d.key), val: val}
+		d.leaves++
+	}
+
+	num := d.uvarint()
+	if num > 256 {
+		d.fail()
+	}
+	if d.err != nil {
+		return nil
+	}
+	if num > 0 {
+		n.edges = make(edges[interface{}], 0, num)
+	}
+	for i := uint64(0); i < num; i++ {
+		child := d.node(false)
+		if d.err != nil {
+			return nil
+		}
+		label := child.prefix[0]
+		if i > 0 && n.edges[i-1].label >= label {
+			d.fail()
+			return nil
+		}
+		n.edges = append(n.edges, edge[interface{}]{label: label, node: child})
+	}
+
+	d.key = d.key[:mark]
+	return n
+}
@@ -0,0 +1,136 @@
+package radix
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	inp := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		inp[generateUUID()] = i
+	}
+	inp[""] = "root"
+	inp["foo"] = nil
+	inp["foobar"] = []byte("bar")
+
+	r := NewFromMap(inp)
+	file := filepath.Join(t.TempDir(), "tree.rdx")
+	if err := r.Save(file); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out := New()
+	if err := out.Load(file); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if out.Len() != r.Len() {
+		t.Fatalf("bad length: %v %v", out.Len(), r.Len())
+	}
+	if !reflect.DeepEqual(out.ToMap(), inp) {
+		t.Fatalf("mis-match after load")
+	}
+
+	// The loaded tree must stay fully usable
+	out.Insert("foobaz", true)
+	if _, ok := out.Delete("foobar"); !ok {
+		t.Fatalf("missing key after load")
+	}
+	if m, _, _ := out.LongestPrefix("foobazzz"); m != "foobaz" {
+		t.Fatalf("bad longest prefix: %v", m)
+	}
+}
+
+func TestSaveLoadEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := New().WriteTo(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	r := NewFromMap(map[string]interface{}{"a": 1})
+	if _, err := r.ReadFrom(&buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("bad length: %v", r.Len())
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+func TestCodec(t *testing.T) {
+	r := New()
+	r.SetCodec(jsonCodec{})
+	r.Insert("a", "x")
+	r.Insert("ab", 1.5)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("bad count: %v %v", n, buf.Len())
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"x"`)) {
+		t.Fatalf("codec not used")
+	}
+
+	out := New()
+	out.SetCodec(jsonCodec{})
+	if _, err := out.ReadFrom(&buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !reflect.DeepEqual(out.ToMap(), r.ToMap()) {
+		t.Fatalf("mis-match: %v %v", out.ToMap(), r.ToMap())
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foobar", "zip"} {
+		r.Insert(k, k)
+	}
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data := buf.Bytes()
+
+	type exp struct {
+		data []byte
+		err  error
+	}
+	flip := append([]byte(nil), data...)
+	flip[len(flip)/2] ^= 0xff
+	version := append([]byte(nil), data...)
+	version[4] = 99
+	cases := []exp{
+		{nil, ErrInvalidFormat},
+		{[]byte("RDXX\x01\x00\x00\x00\x00"), ErrInvalidFormat},
+		{version, ErrUnsupportedVersion},
+		{flip, ErrChecksum},
+		{data[:len(data)-1], ErrChecksum},
+	}
+	for i, test := range cases {
+		out := NewFromMap(map[string]interface{}{"keep": true})
+		if _, err := out.ReadFrom(bytes.NewReader(test.data)); err != test.err {
+			t.Fatalf("case %d: bad error: %v %v", i, err, test.err)
+		}
+		if _, ok := out.Get("keep"); !ok || out.Len() != 1 {
+			t.Fatalf("case %d: tree modified on failed load", i)
+		}
+	}
+}
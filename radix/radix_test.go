@@ -343,6 +343,101 @@ func TestWalkPath(t *testing.T) {
 	}
 }
 
+func TestTreeOf(t *testing.T) {
+	inp := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		inp[generateUUID()] = i
+	}
+
+	r := NewTreeOfFromMap(inp)
+	if r.Len() != len(inp) {
+		t.Fatalf("bad length: %v %v", r.Len(), len(inp))
+	}
+	for k, v := range inp {
+		out, ok := r.Get(k)
+		if !ok {
+			t.Fatalf("missing key: %v", k)
+		}
+		if out != v {
+			t.Fatalf("value mis-match: %v %v", out, v)
+		}
+	}
+	if !reflect.DeepEqual(r.ToMap(), inp) {
+		t.Fatalf("mis-match")
+	}
+
+	out, ok := r.Get("missing")
+	if ok || out != 0 {
+		t.Fatalf("bad: %v %v", out, ok)
+	}
+	_, _, ok = NewTreeOf[int]().Minimum()
+	if ok {
+		t.Fatalf("bad minimum on empty tree")
+	}
+
+	for k, v := range inp {
+		out, ok := r.Delete(k)
+		if !ok {
+			t.Fatalf("missing key: %v", k)
+		}
+		if out != v {
+			t.Fatalf("value mis-match: %v %v", out, v)
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("bad length: %v", r.Len())
+	}
+}
+
+func TestIterators(t *testing.T) {
+	r := NewTreeOf[int]()
+	keys := []string{
+		"foo",
+		"foo/bar",
+		"foo/bar/baz",
+		"foo/baz/bar",
+		"zipzap",
+	}
+	for i, k := range keys {
+		r.Insert(k, i)
+	}
+
+	type exp struct {
+		seq func(yield func(string, int) bool)
+		out []string
+	}
+	cases := []exp{
+		{r.All(), keys},
+		{r.Prefix("foo/"), []string{"foo/bar", "foo/bar/baz", "foo/baz/bar"}},
+		{r.Prefix("q"), []string{}},
+		{r.Path("foo/bar/bazoo"), []string{"foo", "foo/bar", "foo/bar/baz"}},
+	}
+	for _, test := range cases {
+		out := []string{}
+		for k, v := range test.seq {
+			if keys[v] != k {
+				t.Fatalf("value mis-match: %v %v", k, v)
+			}
+			out = append(out, k)
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match: %v %v", out, test.out)
+		}
+	}
+
+	// Breaking out of the loop stops the walk
+	out := []string{}
+	for k := range r.All() {
+		out = append(out, k)
+		if len(out) == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(out, keys[:2]) {
+		t.Fatalf("mis-match: %v", out)
+	}
+}
+
 // generateUUID is used to generate a random UUID
 func generateUUID() string {
 	buf := make([]byte, 16)
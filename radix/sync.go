@@ -0,0 +1,157 @@
+package radix
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// SyncTree is a tree that is safe for concurrent use. It publishes
+// versions of an ImmutableTree: lookups and walks load the current
+// version without locking, modifications are serialized by a mutex
+// and commit a new version through a Txn.
+//
+// The walk methods have snapshot semantics: they walk the version
+// current when they started, so a callback may freely modify the
+// tree without deadlocking and sees the entries as they were when
+// the walk started.
+type SyncTree[V any] struct {
+	mu   sync.Mutex
+	txn  *Txn[V]
+	tree atomic.Pointer[ImmutableTree[V]]
+}
+
+// NewSyncTree returns an empty SyncTree
+func NewSyncTree[V any]() *SyncTree[V] {
+	return NewSyncTreeFromMap[V](nil)
+}
+
+// NewSyncTreeFromMap returns a new tree containing the keys
+// from an existing map
+func NewSyncTreeFromMap[V any](m map[string]V) *SyncTree[V] {
+	t := &SyncTree[V]{txn: NewImmutableTree[V]().Txn()}
+	for k, v := range m {
+		t.txn.Insert(k, v)
+	}
+	t.tree.Store(t.txn.Commit())
+	return t
+}
+
+// load returns the current version of the tree
+func (t *SyncTree[V]) load() *ImmutableTree[V] {
+	return t.tree.Load()
+}
+
+// Len is used to return the number of elements in the tree
+func (t *SyncTree[V]) Len() int {
+	return t.load().Len()
+}
+
+// Insert is used to add a newentry or update
+// an existing entry. Returns if updated.
+func (t *SyncTree[V]) Insert(s string, v V) (V, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, ok := t.txn.Insert(s, v)
+	t.tree.Store(t.txn.Commit())
+	return old, ok
+}
+
+// Delete is used to delete a key, returning the previous
+// value and if it was deleted
+func (t *SyncTree[V]) Delete(s string) (V, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, ok := t.txn.Delete(s)
+	if ok {
+		t.tree.Store(t.txn.Commit())
+	}
+	return old, ok
+}
+
+// DeletePrefix is used to delete the subtree under a prefix
+// Returns how many nodes were deleted
+func (t *SyncTree[V]) DeletePrefix(s string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.txn.DeletePrefix(s)
+	if n > 0 {
+		t.tree.Store(t.txn.Commit())
+	}
+	return n
+}
+
+// Get is used to lookup a specific key, returning
+// the value and if it was found
+func (t *SyncTree[V]) Get(s string) (V, bool) {
+	return t.load().Get(s)
+}
+
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (t *SyncTree[V]) LongestPrefix(s string) (string, V, bool) {
+	return t.load().LongestPrefix(s)
+}
+
+// Minimum is used to return the minimum value in the tree
+func (t *SyncTree[V]) Minimum() (string, V, bool) {
+	return t.load().Minimum()
+}
+
+// Maximum is used to return the maximum value in the tree
+func (t *SyncTree[V]) Maximum() (string, V, bool) {
+	return t.load().Maximum()
+}
+
+// SeekLowerBound returns the entry with the smallest key greater
+// than or equal to key
+func (t *SyncTree[V]) SeekLowerBound(key string) (string, V, bool) {
+	return t.load().SeekLowerBound(key)
+}
+
+// Match treats the keys of the tree as route patterns and returns
+// the pattern matching path, along with its value, see TreeOf.Match
+func (t *SyncTree[V]) Match(path string, ps *Params) (string, V, bool) {
+	return t.load().Match(path, ps)
+}
+
+// Walk is used to walk a snapshot of the tree
+func (t *SyncTree[V]) Walk(fn WalkFnOf[V]) {
+	t.load().Walk(fn)
+}
+
+// WalkPrefix is used to walk a snapshot of the tree under a prefix
+func (t *SyncTree[V]) WalkPrefix(prefix string, fn WalkFnOf[V]) {
+	t.load().WalkPrefix(prefix, fn)
+}
+
+// WalkPath is used to walk a snapshot of the tree, but only
+// visiting nodes from the root down to a given leaf.
+func (t *SyncTree[V]) WalkPath(path string, fn WalkFnOf[V]) {
+	t.load().WalkPath(path, fn)
+}
+
+// WalkRange is used to walk a snapshot of the entries with keys
+// in [start, end) in key order
+func (t *SyncTree[V]) WalkRange(start, end string, fn WalkFnOf[V]) {
+	t.load().WalkRange(start, end, fn)
+}
+
+// ReverseWalk is used to walk a snapshot of the tree in reverse
+// key order
+func (t *SyncTree[V]) ReverseWalk(fn WalkFnOf[V]) {
+	t.load().ReverseWalk(fn)
+}
+
+// ToMap is used to walk the tree and convert it into a map
+func (t *SyncTree[V]) ToMap() map[string]V {
+	return t.load().ToMap()
+}
+
+// All returns an iterator over a snapshot of all the entries
+// of the tree in key order.
+func (t *SyncTree[V]) All() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		t.load().All()(yield)
+	}
+}
@@ -0,0 +1,167 @@
+package radix
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSyncTree(t *testing.T) {
+	r := NewSyncTree[int]()
+	keys := []string{"foo", "foo/bar", "foo/baz", "zip"}
+	for i, k := range keys {
+		r.Insert(k, i)
+	}
+
+	// Callbacks may write to the tree and see the snapshot
+	out := []string{}
+	r.Walk(func(k string, v int) bool {
+		out = append(out, k)
+		r.Delete(k)
+		r.Insert(k+"/new", v)
+		return false
+	})
+	if !reflect.DeepEqual(out, keys) {
+		t.Fatalf("mis-match: %v %v", out, keys)
+	}
+	if r.Len() != len(keys) {
+		t.Fatalf("bad length: %v", r.Len())
+	}
+	if _, ok := r.Get("foo/bar/new"); !ok {
+		t.Fatalf("missing key")
+	}
+
+	out = out[:0]
+	r.WalkPrefix("foo/", func(k string, v int) bool {
+		out = append(out, k)
+		r.DeletePrefix("foo/")
+		return false
+	})
+	if !reflect.DeepEqual(out, []string{"foo/bar/new", "foo/baz/new", "foo/new"}) {
+		t.Fatalf("mis-match: %v", out)
+	}
+	if !reflect.DeepEqual(r.ToMap(), map[string]int{"zip/new": 3}) {
+		t.Fatalf("mis-match: %v", r.ToMap())
+	}
+}
+
+func TestSyncTreeConcurrent(t *testing.T) {
+	r := NewSyncTree[int]()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				k := strconv.Itoa(i) + "/" + strconv.Itoa(j)
+				r.Insert(k, j)
+				if j%3 == 0 {
+					r.Delete(k)
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Get(strconv.Itoa(i) + "/" + strconv.Itoa(j))
+				r.LongestPrefix(strconv.Itoa(i) + "/")
+				r.WalkPrefix(strconv.Itoa(i), func(k string, v int) bool {
+					return v > 10
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if r.Len() != 4*333 {
+		t.Fatalf("bad length: %v", r.Len())
+	}
+}
+
+// mutexTree is the single-lock wrapper SyncTree is compared against
+type mutexTree struct {
+	mu   sync.Mutex
+	tree *TreeOf[int]
+}
+
+func (t *mutexTree) Insert(k string, v int) {
+	t.mu.Lock()
+	t.tree.Insert(k, v)
+	t.mu.Unlock()
+}
+
+func (t *mutexTree) Get(k string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tree.Get(k)
+}
+
+type benchTree interface {
+	Insert(k string, v int)
+	Get(k string) (int, bool)
+}
+
+type syncBenchTree struct {
+	*SyncTree[int]
+}
+
+func (t syncBenchTree) Insert(k string, v int) {
+	t.SyncTree.Insert(k, v)
+}
+
+func benchmarkMixed(b *testing.B, tree benchTree, writeEvery int) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = generateUUID()
+		tree.Insert(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := keys[i%len(keys)]
+			if i%writeEvery == 0 {
+				tree.Insert(k, i)
+			} else {
+				tree.Get(k)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSyncTreeRead(b *testing.B) {
+	benchmarkMixed(b, syncBenchTree{NewSyncTree[int]()}, 1<<30)
+}
+
+func BenchmarkMutexTreeRead(b *testing.B) {
+	benchmarkMixed(b, &mutexTree{tree: NewTreeOf[int]()}, 1<<30)
+}
+
+func BenchmarkSyncTreeMixed(b *testing.B) {
+	benchmarkMixed(b, syncBenchTree{NewSyncTree[int]()}, 10)
+}
+
+func BenchmarkMutexTreeMixed(b *testing.B) {
+	benchmarkMixed(b, &mutexTree{tree: NewTreeOf[int]()}, 10)
+}
+
+func TestSyncTreeSnapshotAllocs(t *testing.T) {
+	r := NewSyncTree[int]()
+	for i := 0; i < 1000; i++ {
+		r.Insert(strconv.Itoa(i), i)
+	}
+	// Taking a snapshot does not copy the entries
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Walk(func(k string, v int) bool {
+			return true
+		})
+		for range r.All() {
+			break
+		}
+	})
+	if allocs > 10 {
+		t.Fatalf("too many allocations: %v", allocs)
+	}
+}
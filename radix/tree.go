@@ -0,0 +1,164 @@
+package radix
+
+import "iter"
+
+// WalkFn is used when walking the tree. Takes a
+// key and value, returning if iteration should
+// be terminated.
+type WalkFn func(s string, v interface{}) bool
+
+// Tree implements a radix tree. This can be treated as a
+// Dictionary abstract data type. The main advantage over
+// a standard hash map is prefix-based lookups and
+// ordered iteration,
+//
+// Tree is a thin wrapper over TreeOf[interface{}], use
+// TreeOf directly to avoid type assertions on every lookup.
+type Tree struct {
+	tree  *TreeOf[interface{}]
+	codec Codec
+}
+
+// New returns an empty Tree
+func New() *Tree {
+	return NewFromMap(nil)
+}
+
+// NewFromMap returns a new tree containing the keys
+// from an existing map
+func NewFromMap(m map[string]interface{}) *Tree {
+	return &Tree{tree: NewTreeOfFromMap(m)}
+}
+
+// Len is used to return the number of elements in the tree
+func (t *Tree) Len() int {
+	return t.tree.Len()
+}
+
+// Insert is used to add a newentry or update
+// an existing entry. Returns if updated.
+func (t *Tree) Insert(s string, v interface{}) (interface{}, bool) {
+	return t.tree.Insert(s, v)
+}
+
+// Delete is used to delete a key, returning the previous
+// value and if it was deleted
+func (t *Tree) Delete(s string) (interface{}, bool) {
+	return t.tree.Delete(s)
+}
+
+// DeletePrefix is used to delete the subtree under a prefix
+// Returns how many nodes were deleted
+// Use this to delete large subtrees efficiently
+func (t *Tree) DeletePrefix(s string) int {
+	return t.tree.DeletePrefix(s)
+}
+
+// Get is used to lookup a specific key, returning
+// the value and if it was found
+func (t *Tree) Get(s string) (interface{}, bool) {
+	return t.tree.Get(s)
+}
+
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (t *Tree) LongestPrefix(s string) (string, interface{}, bool) {
+	return t.tree.LongestPrefix(s)
+}
+
+// Minimum is used to return the minimum value in the tree
+func (t *Tree) Minimum() (string, interface{}, bool) {
+	return t.tree.Minimum()
+}
+
+// Maximum is used to return the maximum value in the tree
+func (t *Tree) Maximum() (string, interface{}, bool) {
+	return t.tree.Maximum()
+}
+
+// Walk is used to walk the tree
+func (t *Tree) Walk(fn WalkFn) {
+	t.tree.Walk(WalkFnOf[interface{}](fn))
+}
+
+// WalkPrefix is used to walk the tree under a prefix
+func (t *Tree) WalkPrefix(prefix string, fn WalkFn) {
+	t.tree.WalkPrefix(prefix, WalkFnOf[interface{}](fn))
+}
+
+// WalkPath is used to walk the tree, but only visiting nodes
+// from the root down to a given leaf. Where WalkPrefix walks
+// all the entries *under* the given prefix, this walks the
+// entries *above* the given prefix.
+func (t *Tree) WalkPath(path string, fn WalkFn) {
+	t.tree.WalkPath(path, WalkFnOf[interface{}](fn))
+}
+
+// ToMap is used to walk the tree and convert it into a map
+func (t *Tree) ToMap() map[string]interface{} {
+	return t.tree.ToMap()
+}
+
+// All returns an iterator over all the entries of the tree
+// in key order.
+func (t *Tree) All() iter.Seq2[string, interface{}] {
+	return t.tree.All()
+}
+
+// Prefix returns an iterator over the entries under a prefix
+// in key order, like WalkPrefix.
+func (t *Tree) Prefix(prefix string) iter.Seq2[string, interface{}] {
+	return t.tree.Prefix(prefix)
+}
+
+// Path returns an iterator over the entries from the root
+// down to a given path, like WalkPath.
+func (t *Tree) Path(path string) iter.Seq2[string, interface{}] {
+	return t.tree.Path(path)
+}
+
+// Iterator returns an iterator positioned before the first entry
+func (t *Tree) Iterator() *Iterator[interface{}] {
+	return t.tree.Iterator()
+}
+
+// SeekLowerBound returns the entry with the smallest key greater
+// than or equal to key
+func (t *Tree) SeekLowerBound(key string) (string, interface{}, bool) {
+	return t.tree.SeekLowerBound(key)
+}
+
+// WalkRange is used to walk the entries with keys in [start, end)
+// in key order
+func (t *Tree) WalkRange(start, end string, fn WalkFn) {
+	t.tree.WalkRange(start, end, WalkFnOf[interface{}](fn))
+}
+
+// ReverseWalk is used to walk the tree in reverse key order
+func (t *Tree) ReverseWalk(fn WalkFn) {
+	t.tree.ReverseWalk(WalkFnOf[interface{}](fn))
+}
+
+// ReverseWalkPrefix is used to walk the tree under a prefix
+// in reverse key order
+func (t *Tree) ReverseWalkPrefix(prefix string, fn WalkFn) {
+	t.tree.ReverseWalkPrefix(prefix, WalkFnOf[interface{}](fn))
+}
+
+// Range returns an iterator over the entries with keys in
+// [start, end) in key order.
+func (t *Tree) Range(start, end string) iter.Seq2[string, interface{}] {
+	return t.tree.Range(start, end)
+}
+
+// Backward returns an iterator over all the entries of the tree
+// in reverse key order.
+func (t *Tree) Backward() iter.Seq2[string, interface{}] {
+	return t.tree.Backward()
+}
+
+// Match treats the keys of the tree as route patterns and returns
+// the pattern matching path, along with its value, see TreeOf.Match
+func (t *Tree) Match(path string, ps *Params) (string, interface{}, bool) {
+	return t.tree.Match(path, ps)
+}